@@ -2,14 +2,18 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pavithrankb/weTransfer/internal/migrations"
+	"github.com/pavithrankb/weTransfer/internal/repository"
 	"github.com/pavithrankb/weTransfer/internal/server"
 	"github.com/pavithrankb/weTransfer/internal/storage"
 	"github.com/pavithrankb/weTransfer/internal/worker"
@@ -43,30 +47,57 @@ func main() {
 		logger.Panic("DATABASE_URL is not set")
 	}
 
-	pool, err := pgxpool.New(ctx, dbURL)
+	var repo repository.Repository
+	if dbURL == "memory" {
+		// handy with STORAGE_BACKEND=local; nothing survives a restart
+		if flag.Arg(0) == "migrate" {
+			logger.Panic("migrate: DATABASE_URL=memory has no schema to migrate")
+		}
+		logger.Println("warning: DATABASE_URL=memory, using the in-memory repository")
+		repo = repository.NewMemory()
+	} else {
+		pool, err := pgxpool.New(ctx, dbURL)
+		if err != nil {
+			logger.Panicf("unable to create db pool: %s", err)
+		}
+		defer pool.Close()
+
+		// `api migrate ...` only touches the schema and exits
+		if flag.Arg(0) == "migrate" {
+			if err := runMigrate(context.Background(), pool, logger, flag.Args()[1:]); err != nil {
+				logger.Panicf("migrate: %s", err)
+			}
+			return
+		}
+
+		// apply pending migrations on startup unless disabled (MIGRATE_ON_START=false)
+		if os.Getenv("MIGRATE_ON_START") != "false" {
+			if err := migrations.Up(context.Background(), pool, logger); err != nil {
+				logger.Panicf("unable to migrate database: %s", err)
+			}
+		}
+		repo = repository.NewPostgres(pool)
+	}
+
+	// initialize object store (STORAGE_BACKEND=s3 by default, or local for development)
+	store, err := newObjectStore(ctx, logger)
 	if err != nil {
-		logger.Panicf("unable to create db pool: %s", err)
+		logger.Panicf("unable to initialize object store: %s", err)
 	}
-	defer pool.Close()
 
-	// initialize S3 helper
-	s3h, err := storage.NewS3(ctx)
+	// initialize the message broker (MESSAGE_BROKER=aws by default, or memory for development)
+	broker, err := newBroker(ctx, logger)
 	if err != nil {
-		logger.Panicf("unable to initialize s3 helper: %s", err)
+		logger.Panicf("unable to initialize message broker: %s", err)
 	}
 
-	// initialize SNS helper (optional - if SNS_TOPIC_ARN is not set, email sharing will not work)
-	var snsh *storage.SNS
-	if os.Getenv("SNS_TOPIC_ARN") != "" {
-		snsh, err = storage.NewSNS(ctx)
-		if err != nil {
-			logger.Printf("warning: unable to initialize sns helper: %s (email sharing disabled)", err)
-		}
-	} else {
-		logger.Println("warning: SNS_TOPIC_ARN not set, email sharing disabled")
+	// malware scanner (SCANNER=clamav or noop); unset makes uploads READY without a scan
+	scanner, err := newScanner(logger)
+	if err != nil {
+		logger.Panicf("unable to initialize malware scanner: %s", err)
 	}
 
-	srv := server.NewServer(pool, s3h, snsh, logger, *debugMode)
+	srv := server.NewServer(repo, store, broker.publisher, broker.topic, scanner, logger, *debugMode)
 
 	// background cleanup job
 	go func() {
@@ -80,11 +111,216 @@ func main() {
 		}
 	}()
 
+	// webhook deliveries and their retries
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			srv.RunWebhookDeliveries()
+		}
+	}()
+
+	// malware scans of completed uploads
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			srv.RunScans()
+		}
+	}()
+
+	// outbox relay for share-download emails
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			srv.RunShareRelay()
+		}
+	}()
+
+	// S3 ObjectCreated notifications complete transfers whose clients never call /complete
+	if queue := os.Getenv("UPLOAD_EVENTS_QUEUE_URL"); queue != "" {
+		if broker.consumer == nil {
+			logger.Println("warning: UPLOAD_EVENTS_QUEUE_URL set without a message broker, upload events disabled")
+		} else {
+			go srv.ConsumeUploadEvents(context.Background(), broker.consumer, queue)
+		}
+	}
+
 	// start email worker
-	go worker.StartEmailWorker(ctx, logger)
+	mailer, err := newMailer(ctx, logger)
+	if err != nil {
+		logger.Panicf("unable to initialize mailer: %s", err)
+	}
+	go worker.StartEmailWorker(ctx, broker.consumer, broker.queue, mailer, repo, logger)
 
 	logger.Println("Server listening on port 8080...")
 	if err := srv.ListenAndServe(); err != nil {
 		logger.Panicf("cannot start server: %s", err)
 	}
 }
+
+// newObjectStore builds the object store selected by STORAGE_BACKEND.
+func newObjectStore(ctx context.Context, logger *log.Logger) (storage.ObjectStore, error) {
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", "s3":
+		return storage.NewS3(ctx)
+	case "local":
+		dir := os.Getenv("LOCAL_STORAGE_DIR")
+		if dir == "" {
+			dir = "data"
+		}
+		baseURL := os.Getenv("PUBLIC_BASE_URL")
+		if baseURL == "" {
+			baseURL = "http://localhost:8080"
+		}
+		secret := []byte(os.Getenv("LOCAL_STORAGE_SECRET"))
+		if len(secret) == 0 {
+			// presigned URLs will not survive a restart, which is fine for a laptop
+			logger.Println("warning: LOCAL_STORAGE_SECRET not set, using a random signing key")
+			secret = make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				return nil, err
+			}
+		}
+		logger.Printf("using local object store dir=%s base_url=%s", dir, baseURL)
+		return storage.NewLocalStore(dir, baseURL, secret)
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q (expected s3 or local)", backend)
+	}
+}
+
+// newScanner builds the malware scanner selected by SCANNER. It returns nil when scanning
+// is disabled.
+func newScanner(logger *log.Logger) (storage.Scanner, error) {
+	switch scanner := os.Getenv("SCANNER"); scanner {
+	case "":
+		logger.Println("warning: SCANNER not set, uploads are not scanned for malware")
+		return nil, nil
+	case "noop":
+		logger.Println("warning: SCANNER=noop, every upload is reported clean")
+		return storage.NoopScanner{}, nil
+	case "clamav":
+		addr := os.Getenv("CLAMD_ADDR")
+		if addr == "" {
+			addr = "tcp://localhost:3310"
+		}
+		timeout := 5 * time.Minute
+		if v := os.Getenv("CLAMD_TIMEOUT"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CLAMD_TIMEOUT %q", v)
+			}
+			timeout = d
+		}
+		logger.Printf("using clamav scanner addr=%s", addr)
+		return storage.NewClamdScanner(addr, timeout)
+	default:
+		return nil, fmt.Errorf("unknown SCANNER %q (expected clamav or noop)", scanner)
+	}
+}
+
+// messaging is the email pipeline: share events are published to topic and the email
+// worker consumes them from queue.
+type messaging struct {
+	publisher storage.Publisher
+	consumer  storage.Consumer
+	topic     string
+	queue     string
+}
+
+// newBroker builds the publisher and consumer of the email pipeline selected by MESSAGE_BROKER.
+// Either is nil when its half of the pipeline is not configured.
+func newBroker(ctx context.Context, logger *log.Logger) (messaging, error) {
+	m := messaging{topic: os.Getenv("SNS_TOPIC_ARN"), queue: os.Getenv("SQS_QUEUE_URL")}
+	switch broker := os.Getenv("MESSAGE_BROKER"); broker {
+	case "", "aws":
+		// both are optional - without SNS_TOPIC_ARN email sharing is disabled
+		if m.topic != "" {
+			snsh, err := storage.NewSNS(ctx)
+			if err != nil {
+				logger.Printf("warning: unable to initialize sns helper: %s (email sharing disabled)", err)
+			} else {
+				m.publisher = snsh
+			}
+		} else {
+			logger.Println("warning: SNS_TOPIC_ARN not set, email sharing disabled")
+		}
+
+		if m.queue != "" || os.Getenv("UPLOAD_EVENTS_QUEUE_URL") != "" {
+			sqsh, err := storage.NewSQS(ctx)
+			if err != nil {
+				logger.Printf("warning: unable to initialize sqs helper: %s (email worker disabled)", err)
+			} else {
+				m.consumer = sqsh
+			}
+		}
+		return m, nil
+	case "memory":
+		// SNS_TOPIC_ARN and SQS_QUEUE_URL just name the in-process topic and queue
+		if m.topic == "" {
+			m.topic = "transfer-shares"
+		}
+		if m.queue == "" {
+			m.queue = "transfer-emails"
+		}
+		// a message is received once per send attempt and again whenever a lease or a
+		// crash delays it, so leave the worker room to exhaust EMAIL_MAX_ATTEMPTS before
+		// the message is dead-lettered
+		maxReceives := 2 * worker.EmailMaxAttempts()
+		if v, err := strconv.Atoi(os.Getenv("BROKER_MAX_RECEIVES")); err == nil {
+			maxReceives = v
+		}
+
+		b := storage.NewMemoryBroker(maxReceives)
+		b.Subscribe(m.topic, m.queue)
+		if q := os.Getenv("UPLOAD_EVENTS_QUEUE_URL"); q != "" {
+			// synthetic S3 notifications are sent to it with SendMessage
+			b.CreateQueue(q)
+		}
+		logger.Printf("warning: MESSAGE_BROKER=memory, topic=%s queue=%s; queued messages are lost on restart", m.topic, m.queue)
+		m.publisher, m.consumer = b, b
+		return m, nil
+	default:
+		return messaging{}, fmt.Errorf("unknown MESSAGE_BROKER %q (expected aws or memory)", broker)
+	}
+}
+
+// newMailer builds the email sender selected by MAIL_BACKEND. It returns a nil mailer when
+// SES is selected but AWS_REGION is not set.
+func newMailer(ctx context.Context, logger *log.Logger) (storage.Mailer, error) {
+	switch backend := os.Getenv("MAIL_BACKEND"); backend {
+	case "", "ses":
+		if os.Getenv("AWS_REGION") == "" {
+			logger.Println("warning: AWS_REGION not set, SES mailer disabled")
+			return nil, nil
+		}
+		return storage.NewSES(ctx)
+	case "smtp":
+		port := 587
+		if v := os.Getenv("SMTP_PORT"); v != "" {
+			p, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SMTP_PORT %q", v)
+			}
+			port = p
+		}
+		logger.Printf("using smtp mailer host=%s port=%d", os.Getenv("SMTP_HOST"), port)
+		return storage.NewSMTPMailer(storage.SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			StartTLS: os.Getenv("SMTP_STARTTLS") != "false",
+		})
+	case "file":
+		dir := os.Getenv("MAIL_DROP_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		logger.Printf("using file mailer dir=%s", dir)
+		return storage.NewFileMailer(dir)
+	default:
+		return nil, fmt.Errorf("unknown MAIL_BACKEND %q (expected ses, smtp or file)", backend)
+	}
+}
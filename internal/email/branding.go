@@ -0,0 +1,98 @@
+package email
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// Branding is the sender identity shown in share emails.
+type Branding struct {
+	Name            string `json:"name"`
+	LogoURL         string `json:"logo_url"`
+	PrimaryColor    string `json:"primary_color"`
+	BackgroundColor string `json:"background_color"`
+	Footer          string `json:"footer"`
+}
+
+// brandingConfig is the EMAIL_BRANDING_FILE format: a default brand and overrides keyed
+// by transfer owner (the owner_id of the API key). Empty fields fall back to the default.
+type brandingConfig struct {
+	Default Branding            `json:"default"`
+	Owners  map[string]Branding `json:"owners"`
+}
+
+var defaultBranding = Branding{
+	Name:            "weTransfer",
+	PrimaryColor:    "#409fff",
+	BackgroundColor: "#f4f5f7",
+}
+
+// colours end up in style attributes, so only plain hex values are accepted
+var colorPattern = regexp.MustCompile(`^#(?:[0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+func loadBranding(path string) (brandingConfig, error) {
+	cfg := brandingConfig{Default: defaultBranding}
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	var file brandingConfig
+	if err := json.Unmarshal(data, &file); err != nil {
+		return cfg, err
+	}
+
+	cfg.Default = file.Default.over(defaultBranding)
+	cfg.Owners = make(map[string]Branding, len(file.Owners))
+	for owner, b := range file.Owners {
+		cfg.Owners[owner] = b.over(cfg.Default)
+	}
+
+	for owner, b := range cfg.Owners {
+		if err := b.validate(); err != nil {
+			return cfg, fmt.Errorf("owner %q: %w", owner, err)
+		}
+	}
+	return cfg, cfg.Default.validate()
+}
+
+func (c brandingConfig) forOwner(ownerID string) Branding {
+	if b, ok := c.Owners[ownerID]; ok && ownerID != "" {
+		return b
+	}
+	return c.Default
+}
+
+// over fills the empty fields of b from base.
+func (b Branding) over(base Branding) Branding {
+	if b.Name == "" {
+		b.Name = base.Name
+	}
+	if b.LogoURL == "" {
+		b.LogoURL = base.LogoURL
+	}
+	if b.PrimaryColor == "" {
+		b.PrimaryColor = base.PrimaryColor
+	}
+	if b.BackgroundColor == "" {
+		b.BackgroundColor = base.BackgroundColor
+	}
+	if b.Footer == "" {
+		b.Footer = base.Footer
+	}
+	return b
+}
+
+func (b Branding) validate() error {
+	for _, c := range []string{b.PrimaryColor, b.BackgroundColor} {
+		if !colorPattern.MatchString(c) {
+			return fmt.Errorf("invalid colour %q", c)
+		}
+	}
+	return nil
+}
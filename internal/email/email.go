@@ -0,0 +1,271 @@
+// Package email renders the share-download email from html/template and text/template pairs.
+//
+// Templates live under templates/<locale>/ and are embedded in the binary. A directory set
+// with EMAIL_TEMPLATE_DIR uses the same layout and overrides the embedded files one by one,
+// so a deployment can restyle a single template or add a locale without copying the rest.
+// Each locale has:
+//
+//	share.txt   text/template defining "subject" and "body"
+//	share.html  html/template defining "body"
+package email
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"net/url"
+	"os"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/pavithrankb/weTransfer/internal/storage"
+)
+
+// DefaultLocale is used when a share has no locale or none of its templates exist.
+const DefaultLocale = "en"
+
+//go:embed templates
+var embedded embed.FS
+
+// Renderer renders share emails in the locale of the share and the branding of its owner.
+type Renderer struct {
+	locales  map[string]*localeTemplates
+	branding brandingConfig
+}
+
+type localeTemplates struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// ShareData is what the share templates are executed with.
+type ShareData struct {
+	Brand     Branding
+	Files     []SharedFile
+	TotalSize int64
+	ExpiresAt time.Time
+	// ArchiveURL downloads every file as one ZIP; only set for multi-file transfers
+	// shared through personal links.
+	ArchiveURL string
+	// UnlockURL exchanges the password for a download token; only set for
+	// password-protected transfers.
+	UnlockURL string
+}
+
+// SharedFile is one download link of ShareData.
+type SharedFile struct {
+	Filename    string
+	Size        int64
+	DownloadURL string
+}
+
+// Rendered is a rendered email without its addresses.
+type Rendered struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// NewRenderer parses the embedded templates, overridden by those in dir if it is not empty,
+// and loads per-owner branding from brandingFile if it is not empty.
+func NewRenderer(dir, brandingFile string) (*Renderer, error) {
+	root, err := fs.Sub(embedded, "templates")
+	if err != nil {
+		return nil, err
+	}
+	fsys := fs.FS(root)
+	if dir != "" {
+		fsys = overlayFS{top: os.DirFS(dir), bottom: root}
+	}
+
+	r := &Renderer{locales: make(map[string]*localeTemplates)}
+	names, err := localeNames(fsys)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		lt, err := parseLocale(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("templates for %q: %w", name, err)
+		}
+		r.locales[normalizeLocale(name)] = lt
+	}
+	if _, ok := r.locales[DefaultLocale]; !ok {
+		return nil, fmt.Errorf("no templates for default locale %q", DefaultLocale)
+	}
+
+	r.branding, err = loadBranding(brandingFile)
+	if err != nil {
+		return nil, fmt.Errorf("branding: %w", err)
+	}
+	return r, nil
+}
+
+// RenderShare renders msg for recipient to in the share's locale and owner's branding.
+// A recipient with a personal link gets that link instead of the presigned URLs.
+func (r *Renderer) RenderShare(msg storage.ShareDownloadMessage, to string) (Rendered, error) {
+	data := ShareData{
+		Brand:     r.branding.forOwner(msg.OwnerID),
+		TotalSize: msg.FileSize,
+	}
+	link := msg.Links[to]
+	for _, f := range msg.Files {
+		sf := SharedFile{Filename: f.Filename, Size: f.FileSize, DownloadURL: f.DownloadURL}
+		if link != "" {
+			sf.DownloadURL = link
+			if len(msg.Files) > 1 {
+				sf.DownloadURL = link + "?file_id=" + url.QueryEscape(f.ID)
+			}
+		}
+		data.Files = append(data.Files, sf)
+	}
+	if link != "" && len(data.Files) > 1 {
+		data.ArchiveURL = link
+	}
+	if msg.PasswordProtected {
+		data.UnlockURL = msg.UnlockURL
+		if link != "" {
+			data.UnlockURL = link + "/unlock"
+		}
+	}
+	// messages published before multi-file transfers only carry download_url
+	if len(data.Files) == 0 && msg.DownloadURL != "" {
+		data.Files = []SharedFile{{Filename: msg.Filename, Size: msg.FileSize, DownloadURL: msg.DownloadURL}}
+	}
+	if len(data.Files) == 0 {
+		return Rendered{}, errors.New("share has no download links")
+	}
+	if exp, err := time.Parse(time.RFC3339, msg.ExpiresAt); err == nil {
+		data.ExpiresAt = exp
+	}
+
+	lt := r.locale(msg.Locale)
+	var out Rendered
+	var buf bytes.Buffer
+	if err := lt.text.ExecuteTemplate(&buf, "subject", data); err != nil {
+		return Rendered{}, err
+	}
+	out.Subject = strings.TrimSpace(buf.String())
+
+	buf.Reset()
+	if err := lt.text.ExecuteTemplate(&buf, "body", data); err != nil {
+		return Rendered{}, err
+	}
+	out.Text = buf.String()
+
+	buf.Reset()
+	if err := lt.html.ExecuteTemplate(&buf, "body", data); err != nil {
+		return Rendered{}, err
+	}
+	out.HTML = buf.String()
+	return out, nil
+}
+
+// locale picks the templates for a tag such as "de-CH": the exact locale, then its
+// language, then DefaultLocale.
+func (r *Renderer) locale(tag string) *localeTemplates {
+	tag = normalizeLocale(tag)
+	if lt, ok := r.locales[tag]; ok {
+		return lt
+	}
+	if lang, _, ok := strings.Cut(tag, "-"); ok {
+		if lt, ok := r.locales[lang]; ok {
+			return lt
+		}
+	}
+	return r.locales[DefaultLocale]
+}
+
+func normalizeLocale(tag string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+}
+
+func localeNames(fsys fs.FS) ([]string, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+func parseLocale(fsys fs.FS, name string) (*localeTemplates, error) {
+	text, err := texttemplate.New("share.txt").Funcs(texttemplate.FuncMap(funcs)).ParseFS(fsys, name+"/share.txt")
+	if err != nil {
+		return nil, err
+	}
+	html, err := htmltemplate.New("share.html").Funcs(htmltemplate.FuncMap(funcs)).ParseFS(fsys, name+"/share.html")
+	if err != nil {
+		return nil, err
+	}
+	for _, def := range []string{"subject", "body"} {
+		if text.Lookup(def) == nil {
+			return nil, fmt.Errorf("share.txt does not define %q", def)
+		}
+	}
+	if html.Lookup("body") == nil {
+		return nil, errors.New(`share.html does not define "body"`)
+	}
+	return &localeTemplates{text: text, html: html}, nil
+}
+
+var funcs = map[string]interface{}{
+	"humanSize": HumanSize,
+}
+
+// HumanSize formats a byte count with binary units, e.g. 1536 as "1.5 KB".
+func HumanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 4; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTP"[exp])
+}
+
+// overlayFS serves files from top and falls back to bottom. Directory listings are merged.
+type overlayFS struct {
+	top, bottom fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.top.Open(name)
+	if err == nil {
+		return f, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return o.bottom.Open(name)
+}
+
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	seen := make(map[string]bool)
+	var out []fs.DirEntry
+	for _, fsys := range []fs.FS{o.top, o.bottom} {
+		entries, err := fs.ReadDir(fsys, name)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		for _, e := range entries {
+			if !seen[e.Name()] {
+				seen[e.Name()] = true
+				out = append(out, e)
+			}
+		}
+	}
+	return out, nil
+}
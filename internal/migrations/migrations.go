@@ -0,0 +1,256 @@
+// Package migrations applies the versioned SQL files under sql/ to the database.
+//
+// Files are named NNNN_description.up.sql / NNNN_description.down.sql and are embedded
+// in the binary. Applied versions are recorded in schema_migrations, and every run holds a
+// Postgres advisory lock so API instances starting together don't apply the same migration twice.
+package migrations
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockID is the pg_advisory_lock key shared by every instance running migrations.
+const lockID int64 = 0x77657472616e73 // "wetrans"
+
+// Migration is one numbered schema change.
+type Migration struct {
+	Version int
+	Name    string
+	up      string
+	down    string
+}
+
+// Status describes a migration and whether it is applied.
+type Status struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// Load returns the embedded migrations ordered by version.
+func Load() ([]Migration, error) {
+	entries, err := fs.ReadDir(files, "sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		name := e.Name()
+		base, direction, ok := strings.Cut(strings.TrimSuffix(name, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s: expected NNNN_name.up.sql or NNNN_name.down.sql", name)
+		}
+		num, desc, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if !ok || err != nil || version < 1 {
+			return nil, fmt.Errorf("migration %s: invalid version prefix", name)
+		}
+
+		body, err := files.ReadFile(path.Join("sql", name))
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: desc}
+			byVersion[version] = m
+		} else if m.Name != desc {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, desc)
+		}
+		if direction == "up" {
+			m.up = string(body)
+		} else {
+			m.down = string(body)
+		}
+	}
+
+	out := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, fmt.Errorf("migration %d (%s) has no up file", m.Version, m.Name)
+		}
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// Up applies every pending migration in order, each in its own transaction.
+func Up(ctx context.Context, pool *pgxpool.Pool, logger *log.Logger) error {
+	migrations, err := Load()
+	if err != nil {
+		return err
+	}
+
+	return withLock(ctx, pool, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		pending := 0
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			pending++
+
+			logger.Printf("migrate: applying %04d_%s", m.Version, m.Name)
+			tx, err := conn.Begin(ctx)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, m.up); err != nil {
+				_ = tx.Rollback(ctx)
+				return fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+			}
+			if _, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name); err != nil {
+				_ = tx.Rollback(ctx)
+				return fmt.Errorf("migration %04d_%s: record version: %w", m.Version, m.Name, err)
+			}
+			if err := tx.Commit(ctx); err != nil {
+				return fmt.Errorf("migration %04d_%s: commit: %w", m.Version, m.Name, err)
+			}
+		}
+
+		if pending == 0 {
+			logger.Println("migrate: schema is up to date")
+		}
+		return nil
+	})
+}
+
+// Down reverts the latest steps applied migrations, newest first.
+func Down(ctx context.Context, pool *pgxpool.Pool, logger *log.Logger, steps int) error {
+	migrations, err := Load()
+	if err != nil {
+		return err
+	}
+
+	return withLock(ctx, pool, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			steps--
+
+			if m.down == "" {
+				return fmt.Errorf("migration %04d_%s has no down file", m.Version, m.Name)
+			}
+
+			logger.Printf("migrate: reverting %04d_%s", m.Version, m.Name)
+			tx, err := conn.Begin(ctx)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, m.down); err != nil {
+				_ = tx.Rollback(ctx)
+				return fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+			}
+			if _, err := tx.Exec(ctx, `DELETE FROM schema_migrations WHERE version=$1`, m.Version); err != nil {
+				_ = tx.Rollback(ctx)
+				return fmt.Errorf("migration %04d_%s: remove version: %w", m.Version, m.Name, err)
+			}
+			if err := tx.Commit(ctx); err != nil {
+				return fmt.Errorf("migration %04d_%s: commit: %w", m.Version, m.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// List reports every known migration with the time it was applied, if it was.
+func List(ctx context.Context, pool *pgxpool.Pool) ([]Status, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Status
+	err = withLock(ctx, pool, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			st := Status{Version: m.Version, Name: m.Name}
+			if at, ok := applied[m.Version]; ok {
+				st.AppliedAt = &at
+			}
+			out = append(out, st)
+		}
+		return nil
+	})
+	return out, err
+}
+
+// withLock runs fn on a single connection holding the migrations advisory lock.
+// The lock is session scoped, so it must be taken and released on the same connection.
+func withLock(ctx context.Context, pool *pgxpool.Pool, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquire connection: %w", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, lockID); err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	defer func() {
+		// the context may already be done; unlock regardless so the pooled connection is clean
+		_, _ = conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID)
+	}()
+
+	_, err = conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INT PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`)
+	if err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	return fn(conn)
+}
+
+func appliedVersions(ctx context.Context, conn *pgxpool.Conn) (map[int]time.Time, error) {
+	rows, err := conn.Query(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var v int
+		var at time.Time
+		if err := rows.Scan(&v, &at); err != nil {
+			return nil, err
+		}
+		applied[v] = at
+	}
+	return applied, rows.Err()
+}
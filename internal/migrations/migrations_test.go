@@ -0,0 +1,99 @@
+package migrations
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// testPool connects to TEST_DATABASE_URL, a database the test may migrate up and down.
+func testPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	pool, err := pgxpool.New(t.Context(), url)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+// downTo reverts every applied migration newer than version.
+func downTo(ctx context.Context, t *testing.T, pool *pgxpool.Pool, logger *log.Logger, version int) {
+	t.Helper()
+	migrations, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	steps := 0
+	for _, m := range migrations {
+		if m.Version > version {
+			steps++
+		}
+	}
+	if err := Down(ctx, pool, logger, steps); err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+}
+
+func TestBackfillExpiredFrom(t *testing.T) {
+	pool := testPool(t)
+	ctx := t.Context()
+	logger := log.New(io.Discard, "", 0)
+
+	if err := Up(ctx, pool, logger); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	downTo(ctx, t, pool, logger, 15)
+
+	legacy, scanning, unuploaded := uuid.NewString(), uuid.NewString(), uuid.NewString()
+	t.Cleanup(func() {
+		_, _ = pool.Exec(context.Background(), `DELETE FROM transfer_events WHERE transfer_id = ANY($1)`, []string{legacy, scanning, unuploaded})
+		_, _ = pool.Exec(context.Background(), `DELETE FROM transfers WHERE id = ANY($1)`, []string{legacy, scanning, unuploaded})
+	})
+	for _, q := range []struct {
+		sql  string
+		args []interface{}
+	}{
+		// expired before the audit log existed: no event to take the status from
+		{`INSERT INTO transfers (id, status, expires_at, uploaded_at) VALUES ($1, 'EXPIRED', NOW(), NOW())`, []interface{}{legacy}},
+		{`INSERT INTO transfers (id, status, expires_at, uploaded_at) VALUES ($1, 'EXPIRED', NOW(), NOW())`, []interface{}{scanning}},
+		{`INSERT INTO transfer_events (transfer_id, type, actor, old_value, new_value)
+			VALUES ($1, 'expired', 'system', '{"status": "SCANNING"}', '{"status": "EXPIRED"}')`, []interface{}{scanning}},
+		{`INSERT INTO transfers (id, status, expires_at) VALUES ($1, 'EXPIRED', NOW())`, []interface{}{unuploaded}},
+	} {
+		if _, err := pool.Exec(ctx, q.sql, q.args...); err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+	}
+
+	if err := Up(ctx, pool, logger); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	for id, want := range map[string]*string{legacy: ptr("READY"), scanning: ptr("SCANNING"), unuploaded: nil} {
+		var got *string
+		if err := pool.QueryRow(ctx, `SELECT expired_from FROM transfers WHERE id=$1`, id).Scan(&got); err != nil {
+			t.Fatalf("select: %v", err)
+		}
+		if (got == nil) != (want == nil) || (got != nil && *got != *want) {
+			t.Errorf("expired_from of %s = %v, want %v", id, deref(got), deref(want))
+		}
+	}
+}
+
+func ptr(s string) *string { return &s }
+
+func deref(s *string) string {
+	if s == nil {
+		return "NULL"
+	}
+	return *s
+}
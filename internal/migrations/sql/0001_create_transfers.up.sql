@@ -0,0 +1,16 @@
+-- IF NOT EXISTS lets databases created from the README snippet adopt the migrations.
+CREATE TABLE IF NOT EXISTS transfers (
+    id UUID PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL,
+    status TEXT NOT NULL DEFAULT 'INIT',
+    object_key TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    max_downloads INT NOT NULL DEFAULT 1,
+    download_count INT NOT NULL DEFAULT 0,
+    filename TEXT,
+    file_type TEXT,
+    file_size BIGINT,
+    uploaded_at TIMESTAMPTZ
+);
+
+ALTER TABLE transfers ADD COLUMN IF NOT EXISTS upload_id TEXT;
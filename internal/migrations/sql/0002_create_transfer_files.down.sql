@@ -0,0 +1,10 @@
+ALTER TABLE transfers ADD COLUMN object_key TEXT;
+ALTER TABLE transfers ADD COLUMN upload_id TEXT;
+
+-- only single-file transfers fit the old layout
+UPDATE transfers t SET object_key = f.object_key, upload_id = f.upload_id
+FROM transfer_files f
+WHERE f.transfer_id = t.id
+  AND (SELECT COUNT(*) FROM transfer_files c WHERE c.transfer_id = t.id) = 1;
+
+DROP TABLE transfer_files;
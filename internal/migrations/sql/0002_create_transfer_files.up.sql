@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS transfer_files (
+    id UUID PRIMARY KEY,
+    transfer_id UUID NOT NULL REFERENCES transfers(id) ON DELETE CASCADE,
+    filename TEXT NOT NULL,
+    content_type TEXT NOT NULL DEFAULT '',
+    object_key TEXT NOT NULL,
+    upload_id TEXT,
+    file_type TEXT,
+    file_size BIGINT,
+    uploaded_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (transfer_id, filename)
+);
+
+-- move single-file transfers into the manifest, unless that was already done by hand
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'transfers' AND column_name = 'object_key') THEN
+        INSERT INTO transfer_files (id, transfer_id, filename, object_key, upload_id, file_type, file_size, uploaded_at)
+        SELECT gen_random_uuid(), id, regexp_replace(object_key, '^.*/', ''), object_key, upload_id, file_type, file_size, uploaded_at
+        FROM transfers WHERE object_key IS NOT NULL
+        ON CONFLICT (transfer_id, filename) DO NOTHING;
+    END IF;
+END $$;
+
+ALTER TABLE transfers DROP COLUMN IF EXISTS object_key;
+ALTER TABLE transfers DROP COLUMN IF EXISTS upload_id;
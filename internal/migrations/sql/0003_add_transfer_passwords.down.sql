@@ -0,0 +1,2 @@
+ALTER TABLE transfers DROP COLUMN failed_unlock_attempts;
+ALTER TABLE transfers DROP COLUMN password_hash;
@@ -0,0 +1,2 @@
+ALTER TABLE transfers ADD COLUMN IF NOT EXISTS password_hash TEXT;
+ALTER TABLE transfers ADD COLUMN IF NOT EXISTS failed_unlock_attempts INT NOT NULL DEFAULT 0;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS transfers_owner_id_idx;
+ALTER TABLE transfers DROP COLUMN share_token;
+ALTER TABLE transfers DROP COLUMN owner_id;
+DROP TABLE api_keys;
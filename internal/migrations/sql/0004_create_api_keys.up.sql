@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id UUID PRIMARY KEY,
+    owner_id TEXT NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    key_hash TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+
+ALTER TABLE transfers ADD COLUMN IF NOT EXISTS owner_id TEXT;
+ALTER TABLE transfers ADD COLUMN IF NOT EXISTS share_token TEXT UNIQUE;
+CREATE INDEX IF NOT EXISTS transfers_owner_id_idx ON transfers (owner_id);
@@ -0,0 +1,14 @@
+-- no foreign key: the audit log outlives deleted transfers
+CREATE TABLE IF NOT EXISTS transfer_events (
+    id BIGSERIAL PRIMARY KEY,
+    transfer_id UUID NOT NULL,
+    type TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    client_ip TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    old_value JSONB,
+    new_value JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS transfer_events_transfer_id_idx ON transfer_events (transfer_id, id);
@@ -0,0 +1,15 @@
+-- share-download requests, published to SNS by the relay
+CREATE TABLE IF NOT EXISTS share_outbox (
+    id UUID PRIMARY KEY,
+    transfer_id UUID NOT NULL REFERENCES transfers(id) ON DELETE CASCADE,
+    emails TEXT[] NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS share_outbox_due_idx ON share_outbox (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS share_outbox_transfer_id_idx ON share_outbox (transfer_id, created_at);
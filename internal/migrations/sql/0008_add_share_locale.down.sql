@@ -0,0 +1 @@
+ALTER TABLE share_outbox DROP COLUMN IF EXISTS locale;
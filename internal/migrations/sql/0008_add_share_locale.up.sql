@@ -0,0 +1,2 @@
+-- language of the share email; empty uses the default templates
+ALTER TABLE share_outbox ADD COLUMN IF NOT EXISTS locale TEXT NOT NULL DEFAULT '';
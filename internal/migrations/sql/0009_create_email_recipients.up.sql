@@ -0,0 +1,16 @@
+-- share email per recipient, written by the email worker. share_id is text because
+-- messages published before the outbox are keyed by their SNS message id.
+CREATE TABLE IF NOT EXISTS email_recipients (
+    share_id TEXT NOT NULL,
+    transfer_id UUID NOT NULL,
+    email TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMPTZ,
+    PRIMARY KEY (share_id, email)
+);
+
+CREATE INDEX IF NOT EXISTS email_recipients_transfer_id_idx ON email_recipients (transfer_id, created_at);
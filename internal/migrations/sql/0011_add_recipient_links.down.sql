@@ -0,0 +1,3 @@
+ALTER TABLE email_recipients DROP COLUMN IF EXISTS last_downloaded_at;
+ALTER TABLE email_recipients DROP COLUMN IF EXISTS download_count;
+ALTER TABLE email_recipients DROP COLUMN IF EXISTS token;
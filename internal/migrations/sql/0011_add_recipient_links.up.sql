@@ -0,0 +1,4 @@
+-- personal download link of each recipient, resolved by GET /s/{token}
+ALTER TABLE email_recipients ADD COLUMN IF NOT EXISTS token TEXT UNIQUE;
+ALTER TABLE email_recipients ADD COLUMN IF NOT EXISTS download_count INT NOT NULL DEFAULT 0;
+ALTER TABLE email_recipients ADD COLUMN IF NOT EXISTS last_downloaded_at TIMESTAMPTZ;
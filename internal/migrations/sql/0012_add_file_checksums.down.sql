@@ -0,0 +1,2 @@
+ALTER TABLE transfer_files DROP COLUMN IF EXISTS checksum_crc32c;
+ALTER TABLE transfer_files DROP COLUMN IF EXISTS checksum_sha256;
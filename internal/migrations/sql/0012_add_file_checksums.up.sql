@@ -0,0 +1,4 @@
+-- base64 checksums as S3 reports them: declared by the client at upload-url, replaced by
+-- the verified values when the transfer becomes READY
+ALTER TABLE transfer_files ADD COLUMN IF NOT EXISTS checksum_sha256 TEXT;
+ALTER TABLE transfer_files ADD COLUMN IF NOT EXISTS checksum_crc32c TEXT;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS transfers_scanning_idx;
+ALTER TABLE transfers DROP COLUMN IF EXISTS scan_lease_until;
+ALTER TABLE transfer_files DROP COLUMN IF EXISTS scanned_at;
+ALTER TABLE transfer_files DROP COLUMN IF EXISTS scan_detail;
+ALTER TABLE transfer_files DROP COLUMN IF EXISTS scan_verdict;
@@ -0,0 +1,8 @@
+-- malware scan verdict of each file, recorded while the transfer is SCANNING
+ALTER TABLE transfer_files ADD COLUMN IF NOT EXISTS scan_verdict TEXT;
+ALTER TABLE transfer_files ADD COLUMN IF NOT EXISTS scan_detail TEXT;
+ALTER TABLE transfer_files ADD COLUMN IF NOT EXISTS scanned_at TIMESTAMPTZ;
+
+-- keeps a second scanner off a transfer that is being scanned
+ALTER TABLE transfers ADD COLUMN IF NOT EXISTS scan_lease_until TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS transfers_scanning_idx ON transfers (uploaded_at) WHERE status = 'SCANNING';
@@ -0,0 +1,2 @@
+ALTER TABLE transfer_files DROP COLUMN IF EXISTS upload_offset;
+ALTER TABLE transfer_files DROP COLUMN IF EXISTS upload_length;
@@ -0,0 +1,3 @@
+-- progress of resumable (tus) uploads; NULL for files uploaded any other way
+ALTER TABLE transfer_files ADD COLUMN IF NOT EXISTS upload_length BIGINT;
+ALTER TABLE transfer_files ADD COLUMN IF NOT EXISTS upload_offset BIGINT;
@@ -0,0 +1,2 @@
+ALTER TABLE transfer_files DROP COLUMN IF EXISTS upload_lease_until;
+ALTER TABLE transfer_files DROP COLUMN IF EXISTS upload_lease;
@@ -0,0 +1,3 @@
+-- the tus PATCH writing to a file, so two API instances never store the same parts
+ALTER TABLE transfer_files ADD COLUMN IF NOT EXISTS upload_lease TEXT;
+ALTER TABLE transfer_files ADD COLUMN IF NOT EXISTS upload_lease_until TIMESTAMPTZ;
@@ -0,0 +1,14 @@
+-- the status a transfer had when it expired; only a transfer that was READY may be revived
+ALTER TABLE transfers ADD COLUMN IF NOT EXISTS expired_from TEXT;
+
+-- transfers that expired before the column existed take it from their last expiry event
+UPDATE transfers t SET expired_from = (
+    SELECT e.old_value->>'status' FROM transfer_events e
+    WHERE e.transfer_id = t.id AND e.new_value->>'status' = 'EXPIRED'
+    ORDER BY e.id DESC LIMIT 1)
+WHERE t.status = 'EXPIRED' AND t.expired_from IS NULL;
+
+-- those that expired before the audit log existed were READY if they were uploaded: the
+-- SCANNING status came after the audit log, so an expiry from it always has an event
+UPDATE transfers SET expired_from = 'READY'
+WHERE status = 'EXPIRED' AND uploaded_at IS NOT NULL AND expired_from IS NULL;
@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+// Event types recorded in transfer_events.
+const (
+	EventCreated      = "created"
+	EventFilesAdded   = "files_added"
+	EventUploaded     = "uploaded"
+	EventScanned      = "scanned"
+	EventDownloaded   = "downloaded"
+	EventShared       = "shared"
+	EventUpdated      = "updated"
+	EventRevived      = "revived"
+	EventExpired      = "expired"
+	EventUnlockFailed = "unlock_failed"
+	EventUnlocked     = "unlocked"
+	EventDeleted      = "deleted"
+)
+
+// Event is one row of the transfer_events audit log. OldValue and NewValue hold the
+// fields the change touched, before and after.
+type Event struct {
+	ID         int64
+	TransferID string
+	Type       string
+	Actor      string
+	ClientIP   string
+	UserAgent  string
+	OldValue   map[string]interface{}
+	NewValue   map[string]interface{}
+	CreatedAt  time.Time
+}
+
+// Actor identifies who caused a change. The repository reads it from the context, so
+// every write records its event in the same transaction without extra parameters.
+type Actor struct {
+	ID        string
+	IP        string
+	UserAgent string
+}
+
+// SystemActor is recorded for changes made by the server itself (lazy expiry, cleanup).
+var SystemActor = Actor{ID: "system"}
+
+type actorCtxKey struct{}
+
+// WithActor returns a context whose writes are attributed to a.
+func WithActor(ctx context.Context, a Actor) context.Context {
+	return context.WithValue(ctx, actorCtxKey{}, a)
+}
+
+func actorFrom(ctx context.Context) Actor {
+	if a, ok := ctx.Value(actorCtxKey{}).(Actor); ok {
+		return a
+	}
+	return SystemActor
+}
+
+// EventRepository reads the audit log and records events that don't change a transfer.
+type EventRepository interface {
+	// RecordEvent appends an event attributed to the actor in ctx.
+	RecordEvent(ctx context.Context, transferID, typ string, oldValue, newValue map[string]interface{}) error
+	// ListEvents returns one page of a transfer's events, oldest first, and the total count.
+	// Events outlive the transfer so deletions stay on record.
+	ListEvents(ctx context.Context, transferID string, limit, offset int) ([]Event, int, error)
+}
+
+func newEvent(ctx context.Context, transferID, typ string, oldValue, newValue map[string]interface{}) *Event {
+	a := actorFrom(ctx)
+	return &Event{
+		TransferID: transferID,
+		Type:       typ,
+		Actor:      a.ID,
+		ClientIP:   a.IP,
+		UserAgent:  a.UserAgent,
+		OldValue:   oldValue,
+		NewValue:   newValue,
+		CreatedAt:  time.Now().UTC(),
+	}
+}
+
+// updateEvent describes u applied to a transfer whose current values are cur.
+func updateEvent(ctx context.Context, cur *Transfer, u TransferUpdate) *Event {
+	oldValue := map[string]interface{}{}
+	newValue := map[string]interface{}{}
+	typ := EventUpdated
+	if u.ExpiresAt != nil {
+		oldValue["expires_at"] = cur.ExpiresAt
+		newValue["expires_at"] = *u.ExpiresAt
+	}
+	if u.MaxDownloads != nil {
+		oldValue["max_downloads"] = cur.MaxDownloads
+		newValue["max_downloads"] = *u.MaxDownloads
+	}
+	if u.Status != nil {
+		oldValue["status"] = cur.Status
+		newValue["status"] = *u.Status
+		switch *u.Status {
+		case StatusReady:
+			typ = EventRevived
+		case StatusExpired:
+			typ = EventExpired
+		}
+	}
+	return newEvent(ctx, cur.ID, typ, oldValue, newValue)
+}
+
+func statusChange(from, to Status) (map[string]interface{}, map[string]interface{}) {
+	return map[string]interface{}{"status": from}, map[string]interface{}{"status": to}
+}
+
+func createdEvent(ctx context.Context, t *Transfer) *Event {
+	return newEvent(ctx, t.ID, EventCreated, nil, map[string]interface{}{
+		"status":             t.Status,
+		"expires_at":         t.ExpiresAt,
+		"max_downloads":      t.MaxDownloads,
+		"password_protected": t.PasswordHash != nil,
+	})
+}
+
+func filesEvent(ctx context.Context, transferID string, files []File) *Event {
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.Filename
+	}
+	return newEvent(ctx, transferID, EventFilesAdded, nil, map[string]interface{}{"files": names})
+}
+
+func readyEvent(ctx context.Context, transferID string, u ReadyUpdate) *Event {
+	oldValue, newValue := statusChange(StatusInit, u.status())
+	newValue["filename"] = u.Filename
+	newValue["file_size"] = u.FileSize
+	newValue["file_count"] = len(u.Files)
+	return newEvent(ctx, transferID, EventUploaded, oldValue, newValue)
+}
+
+func downloadEvent(ctx context.Context, transferID, object string, count int) *Event {
+	return newEvent(ctx, transferID, EventDownloaded,
+		map[string]interface{}{"download_count": count - 1},
+		map[string]interface{}{"download_count": count, "object": object})
+}
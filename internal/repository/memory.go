@@ -0,0 +1,1055 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Memory implements Repository in process memory. It is meant for tests and for
+// running the API locally without Postgres; nothing survives a restart.
+type Memory struct {
+	mu         sync.Mutex
+	transfers  map[string]*Transfer
+	files      map[string]*File
+	apiKeys    map[string]*APIKey
+	events     []Event
+	webhooks   map[string]*Webhook
+	deliveries []*Delivery
+	shares     []*Share
+	recipients []*Recipient
+	// suppressions is keyed by the lower-cased address
+	suppressions map[string]*Suppression
+	// scanLeases holds the lease of each SCANNING transfer being scanned
+	scanLeases map[string]time.Time
+	// uploadLeases holds the lease of each file a tus PATCH is writing to
+	uploadLeases map[string]uploadLease
+}
+
+type uploadLease struct {
+	id    string
+	until time.Time
+}
+
+var _ Repository = (*Memory)(nil)
+
+func NewMemory() *Memory {
+	return &Memory{
+		transfers: map[string]*Transfer{},
+		files:     map[string]*File{},
+		apiKeys:   map[string]*APIKey{},
+		webhooks:  map[string]*Webhook{},
+
+		suppressions: map[string]*Suppression{},
+		scanLeases:   map[string]time.Time{},
+		uploadLeases: map[string]uploadLease{},
+	}
+}
+
+// copies are handed out so callers can't mutate the stored rows
+
+func (t Transfer) clone() *Transfer { return &t }
+func (f File) clone() *File         { return &f }
+func (k APIKey) clone() *APIKey     { return &k }
+func (w Webhook) clone() *Webhook   { return &w }
+
+func (m *Memory) Create(ctx context.Context, t *Transfer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t.CreatedAt = time.Now().UTC()
+	m.transfers[t.ID] = t.clone()
+	m.record(createdEvent(ctx, t))
+	return nil
+}
+
+func (m *Memory) Get(ctx context.Context, id string) (*Transfer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.transfers[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return t.clone(), nil
+}
+
+func (m *Memory) GetByShareToken(ctx context.Context, token string) (*Transfer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.transfers {
+		if t.ShareToken != nil && *t.ShareToken == token {
+			return t.clone(), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *Memory) List(ctx context.Context, f ListFilter) ([]Transfer, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var matched []Transfer
+	for _, t := range m.transfers {
+		if f.OwnerID != nil && (t.OwnerID == nil || *t.OwnerID != *f.OwnerID) {
+			continue
+		}
+		if f.Status != "" && t.Status != f.Status {
+			continue
+		}
+		matched = append(matched, *t)
+	}
+
+	less := func(a, b Transfer) bool { return a.CreatedAt.Before(b.CreatedAt) }
+	switch f.SortBy {
+	case "expires_at":
+		less = func(a, b Transfer) bool { return a.ExpiresAt.Before(b.ExpiresAt) }
+	case "max_downloads":
+		less = func(a, b Transfer) bool { return a.MaxDownloads < b.MaxDownloads }
+	case "file_size":
+		// like Postgres, NULL sorts as the largest value
+		less = func(a, b Transfer) bool {
+			if a.FileSize == nil || b.FileSize == nil {
+				return a.FileSize != nil && b.FileSize == nil
+			}
+			return *a.FileSize < *b.FileSize
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		if f.Ascending {
+			return less(matched[i], matched[j])
+		}
+		return less(matched[j], matched[i])
+	})
+
+	total := len(matched)
+	if f.Offset >= total {
+		return nil, total, nil
+	}
+	end := total
+	if f.Limit > 0 && f.Offset+f.Limit < end {
+		end = f.Offset + f.Limit
+	}
+	return matched[f.Offset:end], total, nil
+}
+
+func (m *Memory) Update(ctx context.Context, id string, u TransferUpdate) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u.Status != nil {
+		if err := CheckTransition(u.From, *u.Status); err != nil {
+			return err
+		}
+	}
+
+	if u.ExpiresAt == nil && u.MaxDownloads == nil && u.Status == nil {
+		return nil
+	}
+	t, ok := m.transfers[id]
+	if !ok || t.Status != u.From {
+		return ErrConflict
+	}
+	m.record(updateEvent(ctx, t, u))
+	if u.ExpiresAt != nil {
+		t.ExpiresAt = *u.ExpiresAt
+	}
+	if u.MaxDownloads != nil {
+		t.MaxDownloads = *u.MaxDownloads
+	}
+	if u.Status != nil {
+		if *u.Status == StatusExpired {
+			from := t.Status
+			t.ExpiredFrom = &from
+		}
+		t.Status = *u.Status
+	}
+	return nil
+}
+
+func (m *Memory) MarkReady(ctx context.Context, id string, u ReadyUpdate) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.transfers[id]
+	if !ok || t.Status != StatusInit {
+		return ErrConflict
+	}
+
+	filename, size, uploadedAt := u.Filename, u.FileSize, u.UploadedAt
+	t.Status = u.status()
+	t.Filename = &filename
+	t.FileType = u.FileType
+	t.FileSize = &size
+	t.UploadedAt = &uploadedAt
+
+	for _, uf := range u.Files {
+		f, ok := m.files[uf.ID]
+		if !ok {
+			continue
+		}
+		f.FileType = uf.FileType
+		f.FileSize = uf.FileSize
+		f.UploadedAt = &uploadedAt
+		f.UploadID = nil
+		f.ChecksumSHA256 = uf.ChecksumSHA256
+		f.ChecksumCRC32C = uf.ChecksumCRC32C
+	}
+	m.record(readyEvent(ctx, id, u))
+	return nil
+}
+
+func (m *Memory) IncrementDownload(ctx context.Context, id, object string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.transfers[id]
+	if !ok || t.Status != StatusReady {
+		return 0, ErrConflict
+	}
+	if t.DownloadCount >= t.MaxDownloads {
+		return 0, ErrLimitReached
+	}
+	t.DownloadCount++
+	m.record(downloadEvent(ctx, id, object, t.DownloadCount))
+	return t.DownloadCount, nil
+}
+
+func (m *Memory) Expire(ctx context.Context, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.transfers[id]
+	if !ok || !CanTransition(t.Status, StatusExpired) {
+		return false, nil
+	}
+	oldValue, newValue := statusChange(t.Status, StatusExpired)
+	from := t.Status
+	t.Status, t.ExpiredFrom = StatusExpired, &from
+	m.record(newEvent(ctx, id, EventExpired, oldValue, newValue))
+	return true, nil
+}
+
+func (m *Memory) MarkDeleted(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.transfers[id]
+	if !ok || !CanTransition(t.Status, StatusDeleted) {
+		return ErrConflict
+	}
+	oldValue, newValue := statusChange(t.Status, StatusDeleted)
+	t.Status = StatusDeleted
+	m.record(newEvent(ctx, id, EventDeleted, oldValue, newValue))
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.transfers[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(m.transfers, id)
+	delete(m.scanLeases, id)
+	for fid, f := range m.files {
+		if f.TransferID == id {
+			delete(m.files, fid)
+		}
+	}
+	kept := m.shares[:0]
+	for _, sh := range m.shares {
+		if sh.TransferID != id {
+			kept = append(kept, sh)
+		}
+	}
+	m.shares = kept
+	keptRecipients := m.recipients[:0]
+	for _, rc := range m.recipients {
+		if rc.TransferID != id {
+			keptRecipients = append(keptRecipients, rc)
+		}
+	}
+	m.recipients = keptRecipients
+	// events are kept
+	m.record(newEvent(ctx, id, EventDeleted, map[string]interface{}{"status": t.Status}, nil))
+	return nil
+}
+
+func (m *Memory) CleanupCandidates(ctx context.Context) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	withFiles := map[string]bool{}
+	for _, f := range m.files {
+		withFiles[f.TransferID] = true
+	}
+	now := time.Now().UTC()
+	var ids []string
+	for id, t := range m.transfers {
+		due := t.Status == StatusExpired || (t.Status == StatusQuarantined && !t.ExpiresAt.After(now))
+		if due && withFiles[id] {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (m *Memory) ClaimUnlockAttempt(ctx context.Context, id string, max int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.transfers[id]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	if t.FailedUnlockAttempts >= max {
+		return t.FailedUnlockAttempts, ErrLocked
+	}
+	t.FailedUnlockAttempts++
+	return t.FailedUnlockAttempts, nil
+}
+
+func (m *Memory) RecordUnlockFailure(ctx context.Context, id string, attempts int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.record(newEvent(ctx, id, EventUnlockFailed,
+		map[string]interface{}{"failed_unlock_attempts": attempts - 1},
+		map[string]interface{}{"failed_unlock_attempts": attempts}))
+	return nil
+}
+
+func (m *Memory) ResetUnlockFailures(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t, ok := m.transfers[id]; ok {
+		// the attempt claimed for the right password was no failure
+		m.record(newEvent(ctx, id, EventUnlocked,
+			map[string]interface{}{"failed_unlock_attempts": max(t.FailedUnlockAttempts-1, 0)},
+			map[string]interface{}{"failed_unlock_attempts": 0}))
+		t.FailedUnlockAttempts = 0
+	}
+	return nil
+}
+
+func (m *Memory) ListFiles(ctx context.Context, transferID string) ([]File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var files []File
+	for _, f := range m.files {
+		if f.TransferID == transferID {
+			files = append(files, *f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Filename < files[j].Filename })
+	return files, nil
+}
+
+func (m *Memory) PutFiles(ctx context.Context, transferID string, files []File) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range files {
+		f := &files[i]
+		f.TransferID = transferID
+
+		var existing *File
+		for _, e := range m.files {
+			if e.TransferID == transferID && e.Filename == f.Filename {
+				existing = e
+				break
+			}
+		}
+		if existing != nil {
+			existing.ContentType = f.ContentType
+			existing.UploadID = f.UploadID
+			existing.ChecksumSHA256 = f.ChecksumSHA256
+			existing.ChecksumCRC32C = f.ChecksumCRC32C
+			existing.UploadLength = f.UploadLength
+			existing.UploadOffset = f.UploadOffset
+			existing.FileSize = f.FileSize
+			delete(m.uploadLeases, existing.ID)
+			f.ID = existing.ID
+			continue
+		}
+
+		f.ID = uuid.New().String()
+		m.files[f.ID] = &File{
+			ID:             f.ID,
+			TransferID:     transferID,
+			Filename:       f.Filename,
+			ContentType:    f.ContentType,
+			ObjectKey:      f.ObjectKey,
+			UploadID:       f.UploadID,
+			ChecksumSHA256: f.ChecksumSHA256,
+			ChecksumCRC32C: f.ChecksumCRC32C,
+			UploadLength:   f.UploadLength,
+			UploadOffset:   f.UploadOffset,
+			FileSize:       f.FileSize,
+		}
+	}
+	m.record(filesEvent(ctx, transferID, files))
+	return nil
+}
+
+func (m *Memory) ClaimUpload(ctx context.Context, fileID, lease string, from int64, d time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.files[fileID]
+	if !ok || f.UploadOffset == nil || *f.UploadOffset != from {
+		return ErrConflict
+	}
+	now := time.Now()
+	if l, held := m.uploadLeases[fileID]; held && l.id != lease && l.until.After(now) {
+		return ErrLocked
+	}
+	m.uploadLeases[fileID] = uploadLease{id: lease, until: now.Add(d)}
+	return nil
+}
+
+func (m *Memory) AdvanceUpload(ctx context.Context, fileID, lease string, from, to int64, done bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.files[fileID]
+	if !ok || f.UploadOffset == nil || *f.UploadOffset != from || m.uploadLeases[fileID].id != lease {
+		return ErrConflict
+	}
+	f.UploadOffset = &to
+	if done {
+		f.UploadID = nil
+	}
+	delete(m.uploadLeases, fileID)
+	return nil
+}
+
+func (m *Memory) ReleaseUpload(ctx context.Context, fileID, lease string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.uploadLeases[fileID].id == lease {
+		delete(m.uploadLeases, fileID)
+	}
+	return nil
+}
+
+func (m *Memory) MarkAssembled(ctx context.Context, fileID, uploadID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if f, ok := m.files[fileID]; ok && f.UploadID != nil && *f.UploadID == uploadID {
+		f.UploadID = nil
+	}
+	return nil
+}
+
+func (m *Memory) DeleteFile(ctx context.Context, fileID string, uploadID *string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.files[fileID]
+	if !ok {
+		return nil
+	}
+	if uploadID != nil && (f.UploadID == nil || *f.UploadID != *uploadID) {
+		return nil
+	}
+	delete(m.files, fileID)
+	return nil
+}
+
+// record appends e to the audit log; the caller holds m.mu.
+func (m *Memory) record(e *Event) {
+	e.ID = int64(len(m.events) + 1)
+	m.events = append(m.events, *e)
+}
+
+func (m *Memory) RecordEvent(ctx context.Context, transferID, typ string, oldValue, newValue map[string]interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.record(newEvent(ctx, transferID, typ, oldValue, newValue))
+	return nil
+}
+
+func (m *Memory) ListEvents(ctx context.Context, transferID string, limit, offset int) ([]Event, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var matched []Event
+	for _, e := range m.events {
+		if e.TransferID == transferID {
+			matched = append(matched, e)
+		}
+	}
+
+	total := len(matched)
+	if offset >= total {
+		return nil, total, nil
+	}
+	end := total
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+	return matched[offset:end], total, nil
+}
+
+func (m *Memory) CreateWebhook(ctx context.Context, w *Webhook) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.CreatedAt = time.Now().UTC()
+	m.webhooks[w.ID] = w.clone()
+	return nil
+}
+
+func (m *Memory) GetWebhook(ctx context.Context, id string) (*Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w, ok := m.webhooks[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return w.clone(), nil
+}
+
+func (m *Memory) ListWebhooks(ctx context.Context, ownerID *string) ([]Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var hooks []Webhook
+	for _, w := range m.webhooks {
+		if ownerID == nil || (w.OwnerID != nil && *w.OwnerID == *ownerID) {
+			hooks = append(hooks, *w)
+		}
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].CreatedAt.After(hooks[j].CreatedAt) })
+	return hooks, nil
+}
+
+func (m *Memory) DeleteWebhook(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.webhooks[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.webhooks, id)
+	kept := m.deliveries[:0]
+	for _, d := range m.deliveries {
+		if d.WebhookID != id {
+			kept = append(kept, d)
+		}
+	}
+	m.deliveries = kept
+	return nil
+}
+
+func (m *Memory) EnqueueDeliveries(ctx context.Context, ownerID *string, eventType, transferID string, payload []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	n := 0
+	for _, w := range m.webhooks {
+		if !subscribed(w, ownerID, eventType) {
+			continue
+		}
+		m.deliveries = append(m.deliveries, &Delivery{
+			ID:            uuid.New().String(),
+			WebhookID:     w.ID,
+			EventType:     eventType,
+			TransferID:    transferID,
+			Payload:       append([]byte(nil), payload...),
+			Status:        DeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+		n++
+	}
+	return n, nil
+}
+
+func (m *Memory) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	var claimed []Delivery
+	for _, d := range m.deliveries {
+		if len(claimed) >= limit {
+			break
+		}
+		w, ok := m.webhooks[d.WebhookID]
+		if !ok || d.Status != DeliveryPending || d.NextAttemptAt.After(now) {
+			continue
+		}
+		d.NextAttemptAt = now.Add(lease)
+		c := *d
+		c.URL, c.Secret = w.URL, w.Secret
+		claimed = append(claimed, c)
+	}
+	return claimed, nil
+}
+
+func (m *Memory) RecordDeliveryAttempt(ctx context.Context, id string, r DeliveryResult) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, d := range m.deliveries {
+		if d.ID != id {
+			continue
+		}
+		status, next, deliveredAt := deliveryOutcome(r)
+		d.Attempts++
+		d.Status = status
+		if next != nil {
+			d.NextAttemptAt = *next
+		}
+		d.LastStatusCode, d.LastError = nil, nil
+		if r.StatusCode != 0 {
+			code := r.StatusCode
+			d.LastStatusCode = &code
+		}
+		if r.Error != "" {
+			msg := r.Error
+			d.LastError = &msg
+		}
+		d.DeliveredAt = deliveredAt
+	}
+	return nil
+}
+
+func (m *Memory) ListDeliveries(ctx context.Context, webhookID string, limit, offset int) ([]Delivery, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var matched []Delivery
+	// newest first
+	for i := len(m.deliveries) - 1; i >= 0; i-- {
+		if d := m.deliveries[i]; d.WebhookID == webhookID {
+			matched = append(matched, *d)
+		}
+	}
+
+	total := len(matched)
+	if offset >= total {
+		return nil, total, nil
+	}
+	end := total
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+	return matched[offset:end], total, nil
+}
+
+func (m *Memory) ReplayDelivery(ctx context.Context, webhookID, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, d := range m.deliveries {
+		if d.ID != id || d.WebhookID != webhookID {
+			continue
+		}
+		if d.Status != DeliveryFailed {
+			return ErrConflict
+		}
+		d.Status = DeliveryPending
+		d.Attempts = 0
+		d.NextAttemptAt = time.Now().UTC()
+		return nil
+	}
+	return ErrNotFound
+}
+
+func (m *Memory) CreateShare(ctx context.Context, sh *Share) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	sh.Status, sh.NextAttemptAt, sh.CreatedAt = SharePending, now, now
+	c := *sh
+	c.Emails = append([]string(nil), sh.Emails...)
+	c.Tokens = nil
+	m.shares = append(m.shares, &c)
+	for _, e := range sh.Emails {
+		if m.recipient(sh.ID, e) != nil {
+			continue
+		}
+		rc := &Recipient{
+			ShareID: sh.ID, TransferID: sh.TransferID, Email: e,
+			Status: RecipientPending, NextAttemptAt: now, CreatedAt: now,
+		}
+		if tok, ok := sh.Tokens[e]; ok {
+			rc.Token = &tok
+		}
+		m.recipients = append(m.recipients, rc)
+	}
+	m.record(shareEvent(ctx, sh))
+	return nil
+}
+
+func (m *Memory) ListShares(ctx context.Context, transferID string) ([]Share, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var shares []Share
+	for i := len(m.shares) - 1; i >= 0; i-- {
+		if sh := m.shares[i]; sh.TransferID == transferID {
+			shares = append(shares, *sh)
+		}
+	}
+	return shares, nil
+}
+
+func (m *Memory) ClaimShares(ctx context.Context, limit int, lease time.Duration) ([]Share, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	var claimed []Share
+	for _, sh := range m.shares {
+		if len(claimed) >= limit {
+			break
+		}
+		if sh.Status != SharePending || sh.NextAttemptAt.After(now) {
+			continue
+		}
+		sh.NextAttemptAt = now.Add(lease)
+		claimed = append(claimed, *sh)
+	}
+	return claimed, nil
+}
+
+func (m *Memory) ClaimScans(ctx context.Context, limit int, lease time.Duration) ([]Transfer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	var due []*Transfer
+	for _, t := range m.transfers {
+		if t.Status != StatusScanning || m.scanLeases[t.ID].After(now) {
+			continue
+		}
+		due = append(due, t)
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].UploadedAt.Before(*due[j].UploadedAt) })
+
+	var claimed []Transfer
+	for _, t := range due {
+		if len(claimed) >= limit {
+			break
+		}
+		m.scanLeases[t.ID] = now.Add(lease)
+		claimed = append(claimed, *t)
+	}
+	return claimed, nil
+}
+
+func (m *Memory) RecordScanVerdict(ctx context.Context, fileID, verdict string, detail *string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if f, ok := m.files[fileID]; ok {
+		now := time.Now().UTC()
+		f.ScanVerdict = &verdict
+		f.ScanDetail = detail
+		f.ScannedAt = &now
+	}
+	return nil
+}
+
+func (m *Memory) FinishScan(ctx context.Context, id string, to Status, infected []string) error {
+	if err := CheckTransition(StatusScanning, to); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.transfers[id]
+	if !ok || t.Status != StatusScanning {
+		return ErrConflict
+	}
+	t.Status = to
+	delete(m.scanLeases, id)
+	m.record(scannedEvent(ctx, id, to, infected))
+	return nil
+}
+
+func (m *Memory) MarkShareSent(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, sh := range m.shares {
+		if sh.ID == id {
+			now := time.Now().UTC()
+			sh.Status = ShareSent
+			sh.Attempts++
+			sh.LastError = nil
+			sh.SentAt = &now
+		}
+	}
+	return nil
+}
+
+func (m *Memory) RecordShareFailure(ctx context.Context, id, errMsg string, nextAttemptAt *time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, sh := range m.shares {
+		if sh.ID != id {
+			continue
+		}
+		sh.Attempts++
+		sh.LastError = &errMsg
+		if nextAttemptAt != nil {
+			sh.NextAttemptAt = *nextAttemptAt
+		} else {
+			sh.Status = ShareFailed
+		}
+	}
+	return nil
+}
+
+func (m *Memory) recipient(shareID, email string) *Recipient {
+	for _, rc := range m.recipients {
+		if rc.ShareID == shareID && rc.Email == email {
+			return rc
+		}
+	}
+	return nil
+}
+
+func (m *Memory) EnsureRecipients(ctx context.Context, shareID, transferID string, emails []string) ([]Recipient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	for _, e := range emails {
+		if m.recipient(shareID, e) == nil {
+			m.recipients = append(m.recipients, &Recipient{
+				ShareID: shareID, TransferID: transferID, Email: e,
+				Status: RecipientPending, NextAttemptAt: now, CreatedAt: now,
+			})
+		}
+	}
+
+	var out []Recipient
+	for _, rc := range m.recipients {
+		if rc.ShareID == shareID {
+			out = append(out, *rc)
+		}
+	}
+	return out, nil
+}
+
+func (m *Memory) ClaimRecipient(ctx context.Context, shareID, email string, lease time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	rc := m.recipient(shareID, email)
+	if rc == nil || rc.Status != RecipientPending || rc.NextAttemptAt.After(now) {
+		return false, nil
+	}
+	rc.NextAttemptAt = now.Add(lease)
+	return true, nil
+}
+
+func (m *Memory) MarkRecipientSent(ctx context.Context, shareID, email string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if rc := m.recipient(shareID, email); rc != nil {
+		now := time.Now().UTC()
+		rc.Status = RecipientSent
+		rc.Attempts++
+		rc.LastError = nil
+		rc.SentAt = &now
+	}
+	return nil
+}
+
+func (m *Memory) RecordRecipientFailure(ctx context.Context, shareID, email, errMsg string, nextAttemptAt *time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rc := m.recipient(shareID, email)
+	if rc == nil {
+		return nil
+	}
+	rc.Attempts++
+	rc.LastError = &errMsg
+	if nextAttemptAt != nil {
+		rc.NextAttemptAt = *nextAttemptAt
+	} else {
+		rc.Status = RecipientFailed
+	}
+	return nil
+}
+
+func (m *Memory) ListRecipients(ctx context.Context, transferID string) ([]Recipient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var out []Recipient
+	for _, rc := range m.recipients {
+		if rc.TransferID == transferID {
+			out = append(out, *rc)
+		}
+	}
+	return out, nil
+}
+
+func (m *Memory) GetRecipientByToken(ctx context.Context, token string) (*Recipient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, rc := range m.recipients {
+		if rc.Token != nil && *rc.Token == token {
+			c := *rc
+			return &c, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *Memory) RecordRecipientDownload(ctx context.Context, shareID, email string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if rc := m.recipient(shareID, email); rc != nil {
+		now := time.Now().UTC()
+		rc.DownloadCount++
+		rc.LastDownloadedAt = &now
+	}
+	return nil
+}
+
+func (m *Memory) Suppress(ctx context.Context, email, reason string, detail *string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	key := normalizeEmail(email)
+	if sp, ok := m.suppressions[key]; ok {
+		sp.Reason, sp.Detail, sp.UpdatedAt = reason, detail, now
+		return nil
+	}
+	m.suppressions[key] = &Suppression{Email: key, Reason: reason, Detail: detail, CreatedAt: now, UpdatedAt: now}
+	return nil
+}
+
+func (m *Memory) Suppressed(ctx context.Context, emails []string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var suppressed []string
+	for _, e := range emails {
+		if _, ok := m.suppressions[normalizeEmail(e)]; ok {
+			suppressed = append(suppressed, e)
+		}
+	}
+	return suppressed, nil
+}
+
+func (m *Memory) ListSuppressions(ctx context.Context, limit, offset int) ([]Suppression, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]Suppression, 0, len(m.suppressions))
+	for _, sp := range m.suppressions {
+		list = append(list, *sp)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].UpdatedAt.Equal(list[j].UpdatedAt) {
+			return list[i].UpdatedAt.After(list[j].UpdatedAt)
+		}
+		return list[i].Email < list[j].Email
+	})
+
+	total := len(list)
+	if offset >= total {
+		return nil, total, nil
+	}
+	end := total
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+	return list[offset:end], total, nil
+}
+
+func (m *Memory) DeleteSuppression(ctx context.Context, email string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := normalizeEmail(email)
+	if _, ok := m.suppressions[key]; !ok {
+		return ErrNotFound
+	}
+	delete(m.suppressions, key)
+	return nil
+}
+
+func (m *Memory) CreateAPIKey(ctx context.Context, k *APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k.CreatedAt = time.Now().UTC()
+	m.apiKeys[k.ID] = k.clone()
+	return nil
+}
+
+func (m *Memory) ListAPIKeys(ctx context.Context, ownerID string) ([]APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []APIKey
+	for _, k := range m.apiKeys {
+		if ownerID == "" || k.OwnerID == ownerID {
+			keys = append(keys, *k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+func (m *Memory) RevokeAPIKey(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k, ok := m.apiKeys[id]
+	if !ok || k.RevokedAt != nil {
+		return ErrNotFound
+	}
+	now := time.Now().UTC()
+	k.RevokedAt = &now
+	return nil
+}
+
+func (m *Memory) LookupAPIKey(ctx context.Context, keyHash string) (*APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, k := range m.apiKeys {
+		if k.KeyHash == keyHash && k.RevokedAt == nil {
+			now := time.Now().UTC()
+			k.LastUsedAt = &now
+			return k.clone(), nil
+		}
+	}
+	return nil, ErrNotFound
+}
@@ -0,0 +1,1084 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Postgres implements Repository on top of a pgx pool.
+type Postgres struct {
+	db *pgxpool.Pool
+}
+
+var _ Repository = (*Postgres)(nil)
+
+func NewPostgres(db *pgxpool.Pool) *Postgres {
+	return &Postgres{db: db}
+}
+
+const transferColumns = `id, owner_id, share_token, status, expires_at, created_at, max_downloads, download_count,
+	filename, file_type, file_size, uploaded_at, password_hash, failed_unlock_attempts, expired_from`
+
+func scanTransfer(row pgx.Row) (*Transfer, error) {
+	var t Transfer
+	err := row.Scan(&t.ID, &t.OwnerID, &t.ShareToken, &t.Status, &t.ExpiresAt, &t.CreatedAt, &t.MaxDownloads, &t.DownloadCount,
+		&t.Filename, &t.FileType, &t.FileSize, &t.UploadedAt, &t.PasswordHash, &t.FailedUnlockAttempts, &t.ExpiredFrom)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+// lockTransfer reads a transfer inside tx and holds its row lock until tx ends.
+func lockTransfer(ctx context.Context, tx pgx.Tx, id string) (*Transfer, error) {
+	return scanTransfer(tx.QueryRow(ctx, `SELECT `+transferColumns+` FROM transfers WHERE id=$1 FOR UPDATE`, id))
+}
+
+// insertEvent appends e to the audit log as part of tx.
+func insertEvent(ctx context.Context, tx pgx.Tx, e *Event) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO transfer_events (transfer_id, type, actor, client_ip, user_agent, old_value, new_value, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		e.TransferID, e.Type, e.Actor, e.ClientIP, e.UserAgent, e.OldValue, e.NewValue, e.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("record %s event: %w", e.Type, err)
+	}
+	return nil
+}
+
+func (p *Postgres) Create(ctx context.Context, t *Transfer) error {
+	return pgx.BeginFunc(ctx, p.db, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, `
+			INSERT INTO transfers (id, owner_id, share_token, status, expires_at, created_at, max_downloads, password_hash)
+			VALUES ($1, $2, $3, $4, $5, NOW(), $6, $7)
+			RETURNING created_at`,
+			t.ID, t.OwnerID, t.ShareToken, string(t.Status), t.ExpiresAt, t.MaxDownloads, t.PasswordHash).Scan(&t.CreatedAt)
+		if err != nil {
+			return err
+		}
+		return insertEvent(ctx, tx, createdEvent(ctx, t))
+	})
+}
+
+func (p *Postgres) Get(ctx context.Context, id string) (*Transfer, error) {
+	return scanTransfer(p.db.QueryRow(ctx, `SELECT `+transferColumns+` FROM transfers WHERE id=$1`, id))
+}
+
+func (p *Postgres) GetByShareToken(ctx context.Context, token string) (*Transfer, error) {
+	return scanTransfer(p.db.QueryRow(ctx, `SELECT `+transferColumns+` FROM transfers WHERE share_token=$1`, token))
+}
+
+func (p *Postgres) List(ctx context.Context, f ListFilter) ([]Transfer, int, error) {
+	var conds []string
+	var args []interface{}
+	if f.OwnerID != nil {
+		args = append(args, *f.OwnerID)
+		conds = append(conds, fmt.Sprintf("owner_id=$%d", len(args)))
+	}
+	if f.Status != "" {
+		args = append(args, string(f.Status))
+		conds = append(conds, fmt.Sprintf("status=$%d", len(args)))
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	var total int
+	if err := p.db.QueryRow(ctx, `SELECT COUNT(*) FROM transfers`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	// only whitelisted column names reach the ORDER BY
+	column := "created_at"
+	switch f.SortBy {
+	case "expires_at", "max_downloads", "file_size":
+		column = f.SortBy
+	}
+	order := "DESC"
+	if f.Ascending {
+		order = "ASC"
+	}
+
+	args = append(args, f.Limit, f.Offset)
+	query := fmt.Sprintf(`SELECT %s FROM transfers%s ORDER BY %s %s LIMIT $%d OFFSET $%d`,
+		transferColumns, where, column, order, len(args)-1, len(args))
+
+	rows, err := p.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var out []Transfer
+	for rows.Next() {
+		t, err := scanTransfer(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		out = append(out, *t)
+	}
+	return out, total, rows.Err()
+}
+
+func (p *Postgres) Update(ctx context.Context, id string, u TransferUpdate) error {
+	if u.Status != nil {
+		if err := CheckTransition(u.From, *u.Status); err != nil {
+			return err
+		}
+	}
+
+	var sets []string
+	var args []interface{}
+	if u.ExpiresAt != nil {
+		args = append(args, *u.ExpiresAt)
+		sets = append(sets, fmt.Sprintf("expires_at=$%d", len(args)))
+	}
+	if u.MaxDownloads != nil {
+		args = append(args, *u.MaxDownloads)
+		sets = append(sets, fmt.Sprintf("max_downloads=$%d", len(args)))
+	}
+	if u.Status != nil {
+		args = append(args, string(*u.Status))
+		sets = append(sets, fmt.Sprintf("status=$%d", len(args)))
+		if *u.Status == StatusExpired {
+			args = append(args, string(u.From))
+			sets = append(sets, fmt.Sprintf("expired_from=$%d", len(args)))
+		}
+	}
+	if len(sets) == 0 {
+		return nil
+	}
+
+	return pgx.BeginFunc(ctx, p.db, func(tx pgx.Tx) error {
+		// the locked row supplies the old values for the event
+		cur, err := lockTransfer(ctx, tx, id)
+		if errors.Is(err, ErrNotFound) {
+			return ErrConflict
+		}
+		if err != nil {
+			return err
+		}
+
+		args = append(args, id, string(u.From))
+		query := fmt.Sprintf(`UPDATE transfers SET %s WHERE id=$%d AND status=$%d`, strings.Join(sets, ", "), len(args)-1, len(args))
+		tag, err := tx.Exec(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrConflict
+		}
+		return insertEvent(ctx, tx, updateEvent(ctx, cur, u))
+	})
+}
+
+func (p *Postgres) MarkReady(ctx context.Context, id string, u ReadyUpdate) error {
+	return pgx.BeginFunc(ctx, p.db, func(tx pgx.Tx) error {
+		// strict update: only an INIT transfer becomes READY or SCANNING
+		tag, err := tx.Exec(ctx, `
+			UPDATE transfers
+			SET status=$1, filename=$2, file_type=$3, file_size=$4, uploaded_at=$5
+			WHERE id=$6 AND status=$7`,
+			string(u.status()), u.Filename, u.FileType, u.FileSize, u.UploadedAt, id, string(StatusInit))
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrConflict
+		}
+
+		for _, f := range u.Files {
+			_, err := tx.Exec(ctx, `
+				UPDATE transfer_files
+				SET file_type=$1, file_size=$2, uploaded_at=$3, upload_id=NULL,
+					checksum_sha256=$4, checksum_crc32c=$5
+				WHERE id=$6`,
+				f.FileType, f.FileSize, u.UploadedAt, f.ChecksumSHA256, f.ChecksumCRC32C, f.ID)
+			if err != nil {
+				return fmt.Errorf("update file %s: %w", f.ID, err)
+			}
+		}
+
+		return insertEvent(ctx, tx, readyEvent(ctx, id, u))
+	})
+}
+
+func (p *Postgres) IncrementDownload(ctx context.Context, id, object string) (int, error) {
+	var count int
+	err := pgx.BeginFunc(ctx, p.db, func(tx pgx.Tx) error {
+		// the condition enforces max_downloads atomically, and never counts a download of a
+		// transfer that expired or was deleted since the caller checked it
+		err := tx.QueryRow(ctx, `
+			UPDATE transfers SET download_count = download_count + 1
+			WHERE id=$1 AND status=$2 AND download_count < max_downloads
+			RETURNING download_count`, id, string(StatusReady)).Scan(&count)
+		if errors.Is(err, pgx.ErrNoRows) {
+			var status string
+			err := tx.QueryRow(ctx, `SELECT status FROM transfers WHERE id=$1`, id).Scan(&status)
+			if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+				return err
+			}
+			if Status(status) != StatusReady {
+				return ErrConflict
+			}
+			return ErrLimitReached
+		}
+		if err != nil {
+			return err
+		}
+		return insertEvent(ctx, tx, downloadEvent(ctx, id, object, count))
+	})
+	return count, err
+}
+
+func (p *Postgres) Expire(ctx context.Context, id string) (bool, error) {
+	expired := false
+	err := pgx.BeginFunc(ctx, p.db, func(tx pgx.Tx) error {
+		cur, err := lockTransfer(ctx, tx, id)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !CanTransition(cur.Status, StatusExpired) {
+			return nil
+		}
+		if _, err := tx.Exec(ctx, `UPDATE transfers SET status=$2, expired_from=$3 WHERE id=$1`,
+			id, string(StatusExpired), string(cur.Status)); err != nil {
+			return err
+		}
+		oldValue, newValue := statusChange(cur.Status, StatusExpired)
+		if err := insertEvent(ctx, tx, newEvent(ctx, id, EventExpired, oldValue, newValue)); err != nil {
+			return err
+		}
+		expired = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return expired, nil
+}
+
+func (p *Postgres) MarkDeleted(ctx context.Context, id string) error {
+	return pgx.BeginFunc(ctx, p.db, func(tx pgx.Tx) error {
+		cur, err := lockTransfer(ctx, tx, id)
+		if errors.Is(err, ErrNotFound) {
+			return ErrConflict
+		}
+		if err != nil {
+			return err
+		}
+		if !CanTransition(cur.Status, StatusDeleted) {
+			return ErrConflict
+		}
+		if _, err := tx.Exec(ctx, `UPDATE transfers SET status=$2 WHERE id=$1`, id, string(StatusDeleted)); err != nil {
+			return err
+		}
+		oldValue, newValue := statusChange(cur.Status, StatusDeleted)
+		return insertEvent(ctx, tx, newEvent(ctx, id, EventDeleted, oldValue, newValue))
+	})
+}
+
+func (p *Postgres) Delete(ctx context.Context, id string) error {
+	return pgx.BeginFunc(ctx, p.db, func(tx pgx.Tx) error {
+		cur, err := lockTransfer(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		// transfer_files and share_outbox rows cascade; transfer_events rows are kept
+		if _, err := tx.Exec(ctx, `DELETE FROM transfers WHERE id=$1`, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM email_recipients WHERE transfer_id=$1`, id); err != nil {
+			return err
+		}
+		return insertEvent(ctx, tx, newEvent(ctx, id, EventDeleted, map[string]interface{}{"status": cur.Status}, nil))
+	})
+}
+
+func (p *Postgres) CleanupCandidates(ctx context.Context) ([]string, error) {
+	rows, err := p.db.Query(ctx, `
+		SELECT t.id FROM transfers t
+		WHERE (t.status = 'EXPIRED' OR (t.status = 'QUARANTINED' AND t.expires_at <= NOW()))
+			AND EXISTS (SELECT 1 FROM transfer_files f WHERE f.transfer_id = t.id)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (p *Postgres) ClaimUnlockAttempt(ctx context.Context, id string, max int) (int, error) {
+	var attempts int
+	err := p.db.QueryRow(ctx, `
+		UPDATE transfers SET failed_unlock_attempts = failed_unlock_attempts + 1
+		WHERE id=$1 AND failed_unlock_attempts < $2
+		RETURNING failed_unlock_attempts`, id, max).Scan(&attempts)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// either the transfer is gone or it is locked
+		if err := p.db.QueryRow(ctx, `SELECT failed_unlock_attempts FROM transfers WHERE id=$1`, id).Scan(&attempts); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return 0, ErrNotFound
+			}
+			return 0, err
+		}
+		return attempts, ErrLocked
+	}
+	return attempts, err
+}
+
+func (p *Postgres) RecordUnlockFailure(ctx context.Context, id string, attempts int) error {
+	return pgx.BeginFunc(ctx, p.db, func(tx pgx.Tx) error {
+		return insertEvent(ctx, tx, newEvent(ctx, id, EventUnlockFailed,
+			map[string]interface{}{"failed_unlock_attempts": attempts - 1},
+			map[string]interface{}{"failed_unlock_attempts": attempts}))
+	})
+}
+
+func (p *Postgres) ResetUnlockFailures(ctx context.Context, id string) error {
+	return pgx.BeginFunc(ctx, p.db, func(tx pgx.Tx) error {
+		cur, err := lockTransfer(ctx, tx, id)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE transfers SET failed_unlock_attempts=0 WHERE id=$1`, id); err != nil {
+			return err
+		}
+		// the attempt claimed for the right password was no failure
+		return insertEvent(ctx, tx, newEvent(ctx, id, EventUnlocked,
+			map[string]interface{}{"failed_unlock_attempts": max(cur.FailedUnlockAttempts-1, 0)},
+			map[string]interface{}{"failed_unlock_attempts": 0}))
+	})
+}
+
+func (p *Postgres) ListFiles(ctx context.Context, transferID string) ([]File, error) {
+	rows, err := p.db.Query(ctx, `
+		SELECT id, transfer_id, filename, content_type, object_key, upload_id, file_type, file_size, uploaded_at,
+			checksum_sha256, checksum_crc32c, scan_verdict, scan_detail, scanned_at, upload_length, upload_offset
+		FROM transfer_files WHERE transfer_id=$1 ORDER BY filename`, transferID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []File
+	for rows.Next() {
+		var f File
+		if err := rows.Scan(&f.ID, &f.TransferID, &f.Filename, &f.ContentType, &f.ObjectKey, &f.UploadID, &f.FileType, &f.FileSize, &f.UploadedAt,
+			&f.ChecksumSHA256, &f.ChecksumCRC32C, &f.ScanVerdict, &f.ScanDetail, &f.ScannedAt, &f.UploadLength, &f.UploadOffset); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+func (p *Postgres) PutFiles(ctx context.Context, transferID string, files []File) error {
+	return pgx.BeginFunc(ctx, p.db, func(tx pgx.Tx) error {
+		for i := range files {
+			f := &files[i]
+			f.TransferID = transferID
+			err := tx.QueryRow(ctx, `
+				INSERT INTO transfer_files (id, transfer_id, filename, content_type, object_key, upload_id, checksum_sha256, checksum_crc32c,
+					upload_length, upload_offset, file_size)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+				ON CONFLICT (transfer_id, filename)
+				DO UPDATE SET content_type=EXCLUDED.content_type, upload_id=EXCLUDED.upload_id,
+					checksum_sha256=EXCLUDED.checksum_sha256, checksum_crc32c=EXCLUDED.checksum_crc32c,
+					upload_length=EXCLUDED.upload_length, upload_offset=EXCLUDED.upload_offset,
+					upload_lease=NULL, upload_lease_until=NULL, file_size=EXCLUDED.file_size
+				RETURNING id`,
+				uuid.New().String(), transferID, f.Filename, f.ContentType, f.ObjectKey, f.UploadID, f.ChecksumSHA256, f.ChecksumCRC32C,
+				f.UploadLength, f.UploadOffset, f.FileSize).Scan(&f.ID)
+			if err != nil {
+				return fmt.Errorf("upsert %q: %w", f.Filename, err)
+			}
+		}
+		return insertEvent(ctx, tx, filesEvent(ctx, transferID, files))
+	})
+}
+
+func (p *Postgres) DeleteFile(ctx context.Context, fileID string, uploadID *string) error {
+	var err error
+	if uploadID != nil {
+		_, err = p.db.Exec(ctx, `DELETE FROM transfer_files WHERE id=$1 AND upload_id=$2`, fileID, *uploadID)
+	} else {
+		_, err = p.db.Exec(ctx, `DELETE FROM transfer_files WHERE id=$1`, fileID)
+	}
+	return err
+}
+
+func (p *Postgres) ClaimUpload(ctx context.Context, fileID, lease string, from int64, d time.Duration) error {
+	var offset *int64
+	var claimed bool
+	err := p.db.QueryRow(ctx, `
+		WITH claim AS (
+			UPDATE transfer_files SET upload_lease=$2, upload_lease_until = NOW() + make_interval(secs => $4)
+			WHERE id=$1 AND upload_offset=$3
+				AND (upload_lease IS NULL OR upload_lease=$2 OR upload_lease_until <= NOW())
+			RETURNING id)
+		SELECT upload_offset, EXISTS (SELECT 1 FROM claim) FROM transfer_files WHERE id=$1`,
+		fileID, lease, from, d.Seconds()).Scan(&offset, &claimed)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrConflict
+	}
+	if err != nil {
+		return err
+	}
+	if claimed {
+		return nil
+	}
+	if offset == nil || *offset != from {
+		return ErrConflict
+	}
+	return ErrLocked
+}
+
+func (p *Postgres) AdvanceUpload(ctx context.Context, fileID, lease string, from, to int64, done bool) error {
+	tag, err := p.db.Exec(ctx, `
+		UPDATE transfer_files SET upload_offset=$4, upload_id=CASE WHEN $5 THEN NULL ELSE upload_id END,
+			upload_lease=NULL, upload_lease_until=NULL
+		WHERE id=$1 AND upload_lease=$2 AND upload_offset=$3`, fileID, lease, from, to, done)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (p *Postgres) ReleaseUpload(ctx context.Context, fileID, lease string) error {
+	_, err := p.db.Exec(ctx, `
+		UPDATE transfer_files SET upload_lease=NULL, upload_lease_until=NULL
+		WHERE id=$1 AND upload_lease=$2`, fileID, lease)
+	return err
+}
+
+func (p *Postgres) MarkAssembled(ctx context.Context, fileID, uploadID string) error {
+	_, err := p.db.Exec(ctx, `UPDATE transfer_files SET upload_id=NULL WHERE id=$1 AND upload_id=$2`, fileID, uploadID)
+	return err
+}
+
+func (p *Postgres) RecordEvent(ctx context.Context, transferID, typ string, oldValue, newValue map[string]interface{}) error {
+	return pgx.BeginFunc(ctx, p.db, func(tx pgx.Tx) error {
+		return insertEvent(ctx, tx, newEvent(ctx, transferID, typ, oldValue, newValue))
+	})
+}
+
+func (p *Postgres) ListEvents(ctx context.Context, transferID string, limit, offset int) ([]Event, int, error) {
+	var total int
+	if err := p.db.QueryRow(ctx, `SELECT COUNT(*) FROM transfer_events WHERE transfer_id=$1`, transferID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := p.db.Query(ctx, `
+		SELECT id, transfer_id, type, actor, client_ip, user_agent, old_value, new_value, created_at
+		FROM transfer_events WHERE transfer_id=$1
+		ORDER BY id LIMIT $2 OFFSET $3`, transferID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(&e.ID, &e.TransferID, &e.Type, &e.Actor, &e.ClientIP, &e.UserAgent, &e.OldValue, &e.NewValue, &e.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		events = append(events, e)
+	}
+	return events, total, rows.Err()
+}
+
+func (p *Postgres) CreateWebhook(ctx context.Context, w *Webhook) error {
+	if w.Events == nil {
+		w.Events = []string{} // a nil slice would be stored as NULL
+	}
+	return p.db.QueryRow(ctx, `
+		INSERT INTO webhooks (id, owner_id, url, secret, events, created_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		RETURNING created_at`,
+		w.ID, w.OwnerID, w.URL, w.Secret, w.Events).Scan(&w.CreatedAt)
+}
+
+const webhookColumns = `id, owner_id, url, secret, events, created_at`
+
+func scanWebhook(row pgx.Row) (*Webhook, error) {
+	var w Webhook
+	if err := row.Scan(&w.ID, &w.OwnerID, &w.URL, &w.Secret, &w.Events, &w.CreatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (p *Postgres) GetWebhook(ctx context.Context, id string) (*Webhook, error) {
+	return scanWebhook(p.db.QueryRow(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE id=$1`, id))
+}
+
+func (p *Postgres) ListWebhooks(ctx context.Context, ownerID *string) ([]Webhook, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhooks`
+	var args []interface{}
+	if ownerID != nil {
+		query += ` WHERE owner_id=$1`
+		args = append(args, *ownerID)
+	}
+	query += ` ORDER BY created_at DESC`
+
+	rows, err := p.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hooks []Webhook
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, *w)
+	}
+	return hooks, rows.Err()
+}
+
+func (p *Postgres) DeleteWebhook(ctx context.Context, id string) error {
+	// webhook_deliveries rows cascade
+	tag, err := p.db.Exec(ctx, `DELETE FROM webhooks WHERE id=$1`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *Postgres) EnqueueDeliveries(ctx context.Context, ownerID *string, eventType, transferID string, payload []byte) (int, error) {
+	tag, err := p.db.Exec(ctx, `
+		INSERT INTO webhook_deliveries (id, webhook_id, event_type, transfer_id, payload, status, next_attempt_at, created_at)
+		SELECT gen_random_uuid(), w.id, $2, $3, $4, $5, NOW(), NOW()
+		FROM webhooks w
+		WHERE (w.owner_id IS NULL OR w.owner_id = $1)
+		  AND (cardinality(w.events) = 0 OR $2 = ANY(w.events))`,
+		ownerID, eventType, transferID, payload, DeliveryPending)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+const deliveryColumns = `d.id, d.webhook_id, d.event_type, d.transfer_id, d.payload, d.status, d.attempts,
+	d.next_attempt_at, d.last_status_code, d.last_error, d.created_at, d.delivered_at`
+
+func scanDelivery(row pgx.Row, extra ...interface{}) (*Delivery, error) {
+	var d Delivery
+	dest := append([]interface{}{&d.ID, &d.WebhookID, &d.EventType, &d.TransferID, &d.Payload, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.DeliveredAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (p *Postgres) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]Delivery, error) {
+	// pushing next_attempt_at forward is the lease; SKIP LOCKED keeps instances apart
+	rows, err := p.db.Query(ctx, `
+		UPDATE webhook_deliveries d SET next_attempt_at = NOW() + make_interval(secs => $2)
+		FROM webhooks w
+		WHERE w.id = d.webhook_id AND d.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = $3 AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING `+deliveryColumns+`, w.url, w.secret`,
+		limit, lease.Seconds(), DeliveryPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []Delivery
+	for rows.Next() {
+		var url, secret string
+		d, err := scanDelivery(rows, &url, &secret)
+		if err != nil {
+			return nil, err
+		}
+		d.URL, d.Secret = url, secret
+		deliveries = append(deliveries, *d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (p *Postgres) RecordDeliveryAttempt(ctx context.Context, id string, r DeliveryResult) error {
+	status, next, deliveredAt := deliveryOutcome(r)
+	var statusCode *int
+	if r.StatusCode != 0 {
+		statusCode = &r.StatusCode
+	}
+	var lastError *string
+	if r.Error != "" {
+		lastError = &r.Error
+	}
+	_, err := p.db.Exec(ctx, `
+		UPDATE webhook_deliveries
+		SET attempts = attempts + 1, status=$2, next_attempt_at=COALESCE($3, next_attempt_at),
+			last_status_code=$4, last_error=$5, delivered_at=$6
+		WHERE id=$1`,
+		id, status, next, statusCode, lastError, deliveredAt)
+	return err
+}
+
+func (p *Postgres) ListDeliveries(ctx context.Context, webhookID string, limit, offset int) ([]Delivery, int, error) {
+	var total int
+	if err := p.db.QueryRow(ctx, `SELECT COUNT(*) FROM webhook_deliveries WHERE webhook_id=$1`, webhookID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := p.db.Query(ctx, `
+		SELECT `+deliveryColumns+` FROM webhook_deliveries d
+		WHERE d.webhook_id=$1
+		ORDER BY d.created_at DESC, d.id LIMIT $2 OFFSET $3`, webhookID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var deliveries []Delivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		deliveries = append(deliveries, *d)
+	}
+	return deliveries, total, rows.Err()
+}
+
+func (p *Postgres) ReplayDelivery(ctx context.Context, webhookID, id string) error {
+	var status string
+	err := p.db.QueryRow(ctx, `SELECT status FROM webhook_deliveries WHERE id=$1 AND webhook_id=$2`, id, webhookID).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	tag, err := p.db.Exec(ctx, `
+		UPDATE webhook_deliveries SET status=$2, attempts=0, next_attempt_at=NOW()
+		WHERE id=$1 AND status=$3`, id, DeliveryPending, DeliveryFailed)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (p *Postgres) CreateShare(ctx context.Context, sh *Share) error {
+	return pgx.BeginFunc(ctx, p.db, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, `
+			INSERT INTO share_outbox (id, transfer_id, emails, locale, status, next_attempt_at, created_at)
+			VALUES ($1, $2, $3, $4, $5, NOW(), NOW())
+			RETURNING status, next_attempt_at, created_at`,
+			sh.ID, sh.TransferID, sh.Emails, sh.Locale, SharePending).Scan(&sh.Status, &sh.NextAttemptAt, &sh.CreatedAt)
+		if err != nil {
+			return err
+		}
+		for _, e := range sh.Emails {
+			var token *string
+			if tok, ok := sh.Tokens[e]; ok {
+				token = &tok
+			}
+			_, err := tx.Exec(ctx, `
+				INSERT INTO email_recipients (share_id, transfer_id, email, status, token)
+				VALUES ($1, $2, $3, $4, $5)
+				ON CONFLICT (share_id, email) DO NOTHING`,
+				sh.ID, sh.TransferID, e, RecipientPending, token)
+			if err != nil {
+				return err
+			}
+		}
+		return insertEvent(ctx, tx, shareEvent(ctx, sh))
+	})
+}
+
+const shareColumns = `id, transfer_id, emails, locale, status, attempts, next_attempt_at, last_error, created_at, sent_at`
+
+func scanShares(rows pgx.Rows) ([]Share, error) {
+	defer rows.Close()
+
+	var shares []Share
+	for rows.Next() {
+		var sh Share
+		if err := rows.Scan(&sh.ID, &sh.TransferID, &sh.Emails, &sh.Locale, &sh.Status, &sh.Attempts, &sh.NextAttemptAt, &sh.LastError, &sh.CreatedAt, &sh.SentAt); err != nil {
+			return nil, err
+		}
+		shares = append(shares, sh)
+	}
+	return shares, rows.Err()
+}
+
+func (p *Postgres) ListShares(ctx context.Context, transferID string) ([]Share, error) {
+	rows, err := p.db.Query(ctx, `SELECT `+shareColumns+` FROM share_outbox WHERE transfer_id=$1 ORDER BY created_at DESC`, transferID)
+	if err != nil {
+		return nil, err
+	}
+	return scanShares(rows)
+}
+
+func (p *Postgres) ClaimShares(ctx context.Context, limit int, lease time.Duration) ([]Share, error) {
+	rows, err := p.db.Query(ctx, `
+		UPDATE share_outbox SET next_attempt_at = NOW() + make_interval(secs => $2)
+		WHERE id IN (
+			SELECT id FROM share_outbox
+			WHERE status = $3 AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING `+shareColumns,
+		limit, lease.Seconds(), SharePending)
+	if err != nil {
+		return nil, err
+	}
+	return scanShares(rows)
+}
+
+func (p *Postgres) MarkShareSent(ctx context.Context, id string) error {
+	_, err := p.db.Exec(ctx, `
+		UPDATE share_outbox SET status=$2, attempts = attempts + 1, last_error=NULL, sent_at=NOW()
+		WHERE id=$1`, id, ShareSent)
+	return err
+}
+
+func (p *Postgres) RecordShareFailure(ctx context.Context, id, errMsg string, nextAttemptAt *time.Time) error {
+	status := SharePending
+	if nextAttemptAt == nil {
+		status = ShareFailed
+	}
+	_, err := p.db.Exec(ctx, `
+		UPDATE share_outbox SET status=$2, attempts = attempts + 1, last_error=$3,
+			next_attempt_at=COALESCE($4, next_attempt_at)
+		WHERE id=$1`, id, status, errMsg, nextAttemptAt)
+	return err
+}
+
+func (p *Postgres) ClaimScans(ctx context.Context, limit int, lease time.Duration) ([]Transfer, error) {
+	rows, err := p.db.Query(ctx, `
+		UPDATE transfers SET scan_lease_until = NOW() + make_interval(secs => $2)
+		WHERE id IN (
+			SELECT id FROM transfers
+			WHERE status = $3 AND (scan_lease_until IS NULL OR scan_lease_until <= NOW())
+			ORDER BY uploaded_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING `+transferColumns,
+		limit, lease.Seconds(), string(StatusScanning))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Transfer
+	for rows.Next() {
+		t, err := scanTransfer(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *t)
+	}
+	return out, rows.Err()
+}
+
+func (p *Postgres) RecordScanVerdict(ctx context.Context, fileID, verdict string, detail *string) error {
+	_, err := p.db.Exec(ctx, `
+		UPDATE transfer_files SET scan_verdict=$2, scan_detail=$3, scanned_at=NOW()
+		WHERE id=$1`, fileID, verdict, detail)
+	return err
+}
+
+func (p *Postgres) FinishScan(ctx context.Context, id string, to Status, infected []string) error {
+	if err := CheckTransition(StatusScanning, to); err != nil {
+		return err
+	}
+	return pgx.BeginFunc(ctx, p.db, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `
+			UPDATE transfers SET status=$2, scan_lease_until=NULL
+			WHERE id=$1 AND status=$3`, id, string(to), string(StatusScanning))
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrConflict
+		}
+		return insertEvent(ctx, tx, scannedEvent(ctx, id, to, infected))
+	})
+}
+
+const recipientColumns = `share_id, transfer_id, email, status, attempts, next_attempt_at, last_error, created_at, sent_at,
+	token, download_count, last_downloaded_at`
+
+func scanRecipients(rows pgx.Rows) ([]Recipient, error) {
+	defer rows.Close()
+
+	var recipients []Recipient
+	for rows.Next() {
+		var rc Recipient
+		if err := rows.Scan(&rc.ShareID, &rc.TransferID, &rc.Email, &rc.Status, &rc.Attempts, &rc.NextAttemptAt, &rc.LastError, &rc.CreatedAt, &rc.SentAt,
+			&rc.Token, &rc.DownloadCount, &rc.LastDownloadedAt); err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, rc)
+	}
+	return recipients, rows.Err()
+}
+
+func (p *Postgres) EnsureRecipients(ctx context.Context, shareID, transferID string, emails []string) ([]Recipient, error) {
+	_, err := p.db.Exec(ctx, `
+		INSERT INTO email_recipients (share_id, transfer_id, email, status)
+		SELECT $1, $2, e, $4 FROM unnest($3::text[]) AS e
+		ON CONFLICT (share_id, email) DO NOTHING`,
+		shareID, transferID, emails, RecipientPending)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := p.db.Query(ctx, `SELECT `+recipientColumns+` FROM email_recipients WHERE share_id=$1 ORDER BY created_at, email`, shareID)
+	if err != nil {
+		return nil, err
+	}
+	return scanRecipients(rows)
+}
+
+func (p *Postgres) ClaimRecipient(ctx context.Context, shareID, email string, lease time.Duration) (bool, error) {
+	tag, err := p.db.Exec(ctx, `
+		UPDATE email_recipients SET next_attempt_at = NOW() + make_interval(secs => $3)
+		WHERE share_id=$1 AND email=$2 AND status=$4 AND next_attempt_at <= NOW()`,
+		shareID, email, lease.Seconds(), RecipientPending)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (p *Postgres) MarkRecipientSent(ctx context.Context, shareID, email string) error {
+	_, err := p.db.Exec(ctx, `
+		UPDATE email_recipients SET status=$3, attempts = attempts + 1, last_error=NULL, sent_at=NOW()
+		WHERE share_id=$1 AND email=$2`, shareID, email, RecipientSent)
+	return err
+}
+
+func (p *Postgres) RecordRecipientFailure(ctx context.Context, shareID, email, errMsg string, nextAttemptAt *time.Time) error {
+	status := RecipientPending
+	if nextAttemptAt == nil {
+		status = RecipientFailed
+	}
+	_, err := p.db.Exec(ctx, `
+		UPDATE email_recipients SET status=$3, attempts = attempts + 1, last_error=$4,
+			next_attempt_at=COALESCE($5, next_attempt_at)
+		WHERE share_id=$1 AND email=$2`, shareID, email, status, errMsg, nextAttemptAt)
+	return err
+}
+
+func (p *Postgres) ListRecipients(ctx context.Context, transferID string) ([]Recipient, error) {
+	rows, err := p.db.Query(ctx, `SELECT `+recipientColumns+` FROM email_recipients WHERE transfer_id=$1 ORDER BY created_at, email`, transferID)
+	if err != nil {
+		return nil, err
+	}
+	return scanRecipients(rows)
+}
+
+func (p *Postgres) GetRecipientByToken(ctx context.Context, token string) (*Recipient, error) {
+	rows, err := p.db.Query(ctx, `SELECT `+recipientColumns+` FROM email_recipients WHERE token=$1`, token)
+	if err != nil {
+		return nil, err
+	}
+	recipients, err := scanRecipients(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(recipients) == 0 {
+		return nil, ErrNotFound
+	}
+	return &recipients[0], nil
+}
+
+func (p *Postgres) RecordRecipientDownload(ctx context.Context, shareID, email string) error {
+	_, err := p.db.Exec(ctx, `
+		UPDATE email_recipients SET download_count = download_count + 1, last_downloaded_at=NOW()
+		WHERE share_id=$1 AND email=$2`, shareID, email)
+	return err
+}
+
+func (p *Postgres) Suppress(ctx context.Context, email, reason string, detail *string) error {
+	_, err := p.db.Exec(ctx, `
+		INSERT INTO email_suppressions (email, reason, detail, created_at, updated_at)
+		VALUES ($1, $2, $3, NOW(), NOW())
+		ON CONFLICT (email) DO UPDATE SET reason=EXCLUDED.reason, detail=EXCLUDED.detail, updated_at=NOW()`,
+		normalizeEmail(email), reason, detail)
+	return err
+}
+
+func (p *Postgres) Suppressed(ctx context.Context, emails []string) ([]string, error) {
+	normalized := make([]string, len(emails))
+	for i, e := range emails {
+		normalized[i] = normalizeEmail(e)
+	}
+	rows, err := p.db.Query(ctx, `SELECT email FROM email_suppressions WHERE email = ANY($1)`, normalized)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := make(map[string]bool)
+	for rows.Next() {
+		var e string
+		if err := rows.Scan(&e); err != nil {
+			return nil, err
+		}
+		found[e] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var suppressed []string
+	for i, e := range emails {
+		if found[normalized[i]] {
+			suppressed = append(suppressed, e)
+		}
+	}
+	return suppressed, nil
+}
+
+func (p *Postgres) ListSuppressions(ctx context.Context, limit, offset int) ([]Suppression, int, error) {
+	var total int
+	if err := p.db.QueryRow(ctx, `SELECT COUNT(*) FROM email_suppressions`).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := p.db.Query(ctx, `
+		SELECT email, reason, detail, created_at, updated_at FROM email_suppressions
+		ORDER BY updated_at DESC, email LIMIT $1 OFFSET $2`, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var list []Suppression
+	for rows.Next() {
+		var sp Suppression
+		if err := rows.Scan(&sp.Email, &sp.Reason, &sp.Detail, &sp.CreatedAt, &sp.UpdatedAt); err != nil {
+			return nil, 0, err
+		}
+		list = append(list, sp)
+	}
+	return list, total, rows.Err()
+}
+
+func (p *Postgres) DeleteSuppression(ctx context.Context, email string) error {
+	tag, err := p.db.Exec(ctx, `DELETE FROM email_suppressions WHERE email=$1`, normalizeEmail(email))
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *Postgres) CreateAPIKey(ctx context.Context, k *APIKey) error {
+	return p.db.QueryRow(ctx, `
+		INSERT INTO api_keys (id, owner_id, name, key_hash, created_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		RETURNING created_at`,
+		k.ID, k.OwnerID, k.Name, k.KeyHash).Scan(&k.CreatedAt)
+}
+
+func (p *Postgres) ListAPIKeys(ctx context.Context, ownerID string) ([]APIKey, error) {
+	query := `SELECT id, owner_id, name, key_hash, created_at, last_used_at, revoked_at FROM api_keys`
+	var args []interface{}
+	if ownerID != "" {
+		query += ` WHERE owner_id=$1`
+		args = append(args, ownerID)
+	}
+	query += ` ORDER BY created_at DESC`
+
+	rows, err := p.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		var k APIKey
+		if err := rows.Scan(&k.ID, &k.OwnerID, &k.Name, &k.KeyHash, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (p *Postgres) RevokeAPIKey(ctx context.Context, id string) error {
+	tag, err := p.db.Exec(ctx, `UPDATE api_keys SET revoked_at=NOW() WHERE id=$1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *Postgres) LookupAPIKey(ctx context.Context, keyHash string) (*APIKey, error) {
+	var k APIKey
+	err := p.db.QueryRow(ctx, `
+		SELECT id, owner_id, name, key_hash, created_at, last_used_at, revoked_at
+		FROM api_keys WHERE key_hash=$1 AND revoked_at IS NULL`, keyHash).
+		Scan(&k.ID, &k.OwnerID, &k.Name, &k.KeyHash, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	// last_used_at is informational; only touch it once a minute per key
+	_, _ = p.db.Exec(ctx, `UPDATE api_keys SET last_used_at=NOW() WHERE id=$1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`, k.ID)
+	return &k, nil
+}
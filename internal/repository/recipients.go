@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+// Recipient states.
+const (
+	RecipientPending = "pending"
+	RecipientSent    = "sent"
+	RecipientFailed  = "failed"
+)
+
+// Recipient is one row of the email_recipients table: the share email to one address.
+type Recipient struct {
+	ShareID       string
+	TransferID    string
+	Email         string
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     *string
+	CreatedAt     time.Time
+	SentAt        *time.Time
+	// Token resolves the recipient's personal download link; nil for shares accepted
+	// before links were introduced.
+	Token            *string
+	DownloadCount    int
+	LastDownloadedAt *time.Time
+}
+
+// RecipientRepository tracks the share email per recipient so the email worker can retry
+// failed addresses without mailing the others twice.
+type RecipientRepository interface {
+	// EnsureRecipients creates pending rows for the emails of a share that have none yet and
+	// returns every recipient of the share.
+	EnsureRecipients(ctx context.Context, shareID, transferID string, emails []string) ([]Recipient, error)
+	// ClaimRecipient reserves a pending recipient that is due for lease and reports whether
+	// it got it, so two workers holding the same message never both send.
+	ClaimRecipient(ctx context.Context, shareID, email string, lease time.Duration) (bool, error)
+	MarkRecipientSent(ctx context.Context, shareID, email string) error
+	// RecordRecipientFailure counts a failed attempt. nextAttemptAt schedules a retry; nil
+	// gives up and marks the recipient failed.
+	RecordRecipientFailure(ctx context.Context, shareID, email, errMsg string, nextAttemptAt *time.Time) error
+	// ListRecipients returns the recipients of every share of a transfer, oldest first.
+	ListRecipients(ctx context.Context, transferID string) ([]Recipient, error)
+	// GetRecipientByToken resolves a personal download link; ErrNotFound if unknown.
+	GetRecipientByToken(ctx context.Context, token string) (*Recipient, error)
+	// RecordRecipientDownload counts a download made through the recipient's link.
+	RecordRecipientDownload(ctx context.Context, shareID, email string) error
+}
@@ -0,0 +1,213 @@
+// Package repository keeps SQL out of the HTTP handlers. TransferRepository,
+// EventRepository, WebhookRepository, ShareRepository, RecipientRepository,
+// SuppressionRepository, ScanRepository and APIKeyRepository have a Postgres implementation
+// for production and an in-memory one for tests and local development.
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when the requested row does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a conditional update lost a race with another change.
+	ErrConflict = errors.New("state changed concurrently")
+	// ErrLimitReached is returned by IncrementDownload once max_downloads is used up.
+	ErrLimitReached = errors.New("download limit reached")
+	// ErrLocked is returned by ClaimUnlockAttempt once the failed unlock attempts are used up.
+	ErrLocked = errors.New("too many failed unlock attempts")
+)
+
+// Transfer is one row of the transfers table.
+type Transfer struct {
+	ID                   string
+	OwnerID              *string
+	ShareToken           *string
+	Status               Status
+	ExpiresAt            time.Time
+	CreatedAt            time.Time
+	MaxDownloads         int
+	DownloadCount        int
+	Filename             *string
+	FileType             *string
+	FileSize             *int64
+	UploadedAt           *time.Time
+	PasswordHash         *string
+	FailedUnlockAttempts int
+	// ExpiredFrom is the status the transfer had when it last expired; only a transfer
+	// that expired while READY may be revived.
+	ExpiredFrom *Status
+}
+
+// File is one row of the transfer_files manifest.
+type File struct {
+	ID          string
+	TransferID  string
+	Filename    string
+	ContentType string
+	ObjectKey   string
+	UploadID    *string
+	FileType    *string
+	// FileSize holds the size the client declared, if any, until the transfer is READY;
+	// MarkReady replaces it with the stored object's size.
+	FileSize   *int64
+	UploadedAt *time.Time
+	// ChecksumSHA256 and ChecksumCRC32C are base64 encoded. Until the transfer is READY they
+	// hold what the client declared; MarkReady replaces them with the verified values.
+	ChecksumSHA256 *string
+	ChecksumCRC32C *string
+	// ScanVerdict is ScanClean or ScanInfected once the malware scan looked at the file;
+	// ScanDetail names the signature that matched.
+	ScanVerdict *string
+	ScanDetail  *string
+	ScannedAt   *time.Time
+	// UploadLength and UploadOffset track a resumable (tus) upload: the size the client
+	// declared and the bytes received so far. Both are nil for files uploaded any other way.
+	UploadLength *int64
+	UploadOffset *int64
+}
+
+// ListFilter selects and orders transfers for List.
+type ListFilter struct {
+	// OwnerID restricts the result to one owner; nil lists every transfer.
+	OwnerID *string
+	Status  Status
+	// SortBy is created_at (default), expires_at, max_downloads or file_size.
+	SortBy string
+	// Ascending sorts oldest/smallest first; the default is descending.
+	Ascending bool
+	Limit     int
+	Offset    int
+}
+
+// TransferUpdate holds the fields PATCH may change; nil fields are left untouched.
+// The update only applies while the transfer is still in From, the status the caller read.
+type TransferUpdate struct {
+	From         Status
+	ExpiresAt    *time.Time
+	MaxDownloads *int
+	Status       *Status
+}
+
+// ReadyUpdate is what MarkReady records once every file of a transfer is verified.
+// Filename, FileType and FileSize summarise the manifest on the transfers row.
+type ReadyUpdate struct {
+	// Status is StatusReady, or StatusScanning while the files still await the malware
+	// scan; empty means StatusReady.
+	Status     Status
+	Filename   string
+	FileType   *string
+	FileSize   int64
+	UploadedAt time.Time
+	Files      []File
+}
+
+func (u ReadyUpdate) status() Status {
+	if u.Status == "" {
+		return StatusReady
+	}
+	return u.Status
+}
+
+// TransferRepository stores transfers and their file manifests. Every method that changes
+// a transfer also appends an Event, attributed to the Actor in ctx, in the same transaction.
+type TransferRepository interface {
+	// Create inserts a new transfer; CreatedAt is filled in.
+	Create(ctx context.Context, t *Transfer) error
+	Get(ctx context.Context, id string) (*Transfer, error)
+	GetByShareToken(ctx context.Context, token string) (*Transfer, error)
+	// List returns one page of transfers and the total number matching the filter.
+	List(ctx context.Context, f ListFilter) ([]Transfer, int, error)
+	// Update applies u if the transfer is still in u.From, or returns ErrConflict.
+	// A status change must be allowed by the transition table (*TransitionError otherwise).
+	Update(ctx context.Context, id string, u TransferUpdate) error
+	// MarkReady moves an INIT transfer to READY (or u.Status) and records the file metadata
+	// in one transaction. It returns ErrConflict if the transfer is no longer INIT.
+	MarkReady(ctx context.Context, id string, u ReadyUpdate) error
+	// IncrementDownload counts one download of a READY transfer and returns the new
+	// download_count. It returns ErrLimitReached once max_downloads is used up and ErrConflict
+	// if the transfer is no longer READY. object names what was downloaded (a filename or
+	// "archive") for the audit log.
+	IncrementDownload(ctx context.Context, id, object string) (int, error)
+	// Expire marks a transfer EXPIRED if its current status allows it and reports whether
+	// it did; otherwise it does nothing.
+	Expire(ctx context.Context, id string) (bool, error)
+	// MarkDeleted records that the objects of an EXPIRED or QUARANTINED transfer were
+	// removed by cleanup. It returns ErrConflict if the transfer is in neither status any more.
+	MarkDeleted(ctx context.Context, id string) error
+	// Delete removes a transfer and its manifest.
+	Delete(ctx context.Context, id string) error
+	// CleanupCandidates returns EXPIRED transfers, and QUARANTINED ones past expires_at,
+	// that still have files to remove.
+	CleanupCandidates(ctx context.Context) ([]string, error)
+
+	// ClaimUnlockAttempt counts an unlock attempt as failed before the password is checked,
+	// so parallel guesses can't exceed max, and returns the new total. It returns ErrLocked
+	// once max attempts have failed.
+	ClaimUnlockAttempt(ctx context.Context, id string, max int) (int, error)
+	// RecordUnlockFailure records a wrong password, already counted by ClaimUnlockAttempt,
+	// in the audit log.
+	RecordUnlockFailure(ctx context.Context, id string, attempts int) error
+	// ResetUnlockFailures clears the count, including the attempt claimed for the right password.
+	ResetUnlockFailures(ctx context.Context, id string) error
+
+	// ListFiles returns the manifest of a transfer ordered by filename.
+	ListFiles(ctx context.Context, transferID string) ([]File, error)
+	// PutFiles inserts files into the manifest, or updates content_type, upload_id and the
+	// resumable upload state of files already present under the same filename, in one
+	// transaction. IDs are filled in.
+	PutFiles(ctx context.Context, transferID string, files []File) error
+	// DeleteFile removes a file from the manifest. When uploadID is set, the row is only
+	// removed while that multipart upload is still the file's current one.
+	DeleteFile(ctx context.Context, fileID string, uploadID *string) error
+	// ClaimUpload gives the request identified by lease the right to write the next bytes of
+	// a resumable upload at offset `from` for the lease duration; claiming again with the same
+	// lease extends it. It returns ErrConflict if the offset has moved and ErrLocked while
+	// another lease is held.
+	ClaimUpload(ctx context.Context, fileID, lease string, from int64, d time.Duration) error
+	// AdvanceUpload moves the offset of a resumable upload from `from` to `to` and releases
+	// lease, or returns ErrConflict if the lease was lost or another request moved the offset
+	// first. done also clears upload_id once the object has been assembled.
+	AdvanceUpload(ctx context.Context, fileID, lease string, from, to int64, done bool) error
+	// ReleaseUpload gives up lease without moving the offset, if it is still held.
+	ReleaseUpload(ctx context.Context, fileID, lease string) error
+	// MarkAssembled clears upload_id once multipart upload uploadID has been assembled into
+	// the file's object, unless the file has been restarted under another upload since.
+	MarkAssembled(ctx context.Context, fileID, uploadID string) error
+}
+
+// APIKey is one row of the api_keys table. Only the hash of the key is stored.
+type APIKey struct {
+	ID         string
+	OwnerID    string
+	Name       string
+	KeyHash    string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+// APIKeyRepository stores API keys.
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, k *APIKey) error
+	// ListAPIKeys lists the keys of one owner, or all keys when ownerID is empty.
+	ListAPIKeys(ctx context.Context, ownerID string) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string) error
+	// LookupAPIKey returns the active key with the given hash and records its use.
+	LookupAPIKey(ctx context.Context, keyHash string) (*APIKey, error)
+}
+
+// Repository is everything the API server persists.
+type Repository interface {
+	TransferRepository
+	EventRepository
+	WebhookRepository
+	ShareRepository
+	RecipientRepository
+	SuppressionRepository
+	ScanRepository
+	APIKeyRepository
+}
@@ -0,0 +1,19 @@
+package repository
+
+import "time"
+
+const (
+	retryBaseBackoff = 30 * time.Second
+	retryMaxBackoff  = time.Hour
+)
+
+// RetryBackoff returns the wait before retry number attempt (1-based): 30s, 1m, 2m, ... up
+// to 1h. Webhook deliveries, the share relay and the email worker schedule their next
+// attempt with it.
+func RetryBackoff(attempt int) time.Duration {
+	d := retryBaseBackoff
+	for i := 1; i < attempt && d < retryMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, retryMaxBackoff)
+}
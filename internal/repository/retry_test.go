@@ -0,0 +1,21 @@
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	for attempt, want := range map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		7:  32 * time.Minute,
+		8:  time.Hour,
+		20: time.Hour,
+	} {
+		if got := RetryBackoff(attempt); got != want {
+			t.Errorf("RetryBackoff(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+// Scan verdicts recorded per file.
+const (
+	ScanClean    = "clean"
+	ScanInfected = "infected"
+)
+
+// ScanRepository tracks the malware scan of SCANNING transfers.
+type ScanRepository interface {
+	// ClaimScans returns up to limit SCANNING transfers no other scanner holds and hides
+	// them from other claimers for lease.
+	ClaimScans(ctx context.Context, limit int, lease time.Duration) ([]Transfer, error)
+	// RecordScanVerdict stores the verdict of one file. detail names the matched signature.
+	RecordScanVerdict(ctx context.Context, fileID, verdict string, detail *string) error
+	// FinishScan moves a SCANNING transfer to READY or QUARANTINED and records the verdict
+	// with infected naming the infected files. It returns ErrConflict if the transfer is
+	// no longer SCANNING.
+	FinishScan(ctx context.Context, id string, to Status, infected []string) error
+}
+
+func scannedEvent(ctx context.Context, id string, to Status, infected []string) *Event {
+	oldValue, newValue := statusChange(StatusScanning, to)
+	newValue["verdict"] = ScanClean
+	if len(infected) > 0 {
+		newValue["verdict"] = ScanInfected
+		newValue["infected"] = infected
+	}
+	return newEvent(ctx, id, EventScanned, oldValue, newValue)
+}
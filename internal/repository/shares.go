@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+// Share states.
+const (
+	SharePending = "pending"
+	ShareSent    = "sent"
+	ShareFailed  = "failed"
+)
+
+// Share is one row of the share_outbox table: a request to email a transfer's download
+// links, published to SNS by the relay.
+type Share struct {
+	ID         string
+	TransferID string
+	Emails     []string
+	// Locale selects the email templates, e.g. "de" or "pt-BR"; empty uses the default.
+	Locale string
+	// Tokens maps each email to the token of its personal download link. CreateShare
+	// writes the recipients with their tokens; it is not loaded back.
+	Tokens        map[string]string
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     *string
+	CreatedAt     time.Time
+	SentAt        *time.Time
+}
+
+// ShareRepository is the outbox for share-download requests.
+type ShareRepository interface {
+	// CreateShare writes a pending share, its recipients and its "shared" event in one transaction.
+	CreateShare(ctx context.Context, sh *Share) error
+	// ListShares returns the shares of a transfer, newest first.
+	ListShares(ctx context.Context, transferID string) ([]Share, error)
+	// ClaimShares returns up to limit pending shares that are due and hides them from
+	// other claimers for lease.
+	ClaimShares(ctx context.Context, limit int, lease time.Duration) ([]Share, error)
+	MarkShareSent(ctx context.Context, id string) error
+	// RecordShareFailure counts a failed attempt. nextAttemptAt schedules a retry; nil
+	// gives up and marks the share failed.
+	RecordShareFailure(ctx context.Context, id, errMsg string, nextAttemptAt *time.Time) error
+}
+
+func shareEvent(ctx context.Context, sh *Share) *Event {
+	return newEvent(ctx, sh.TransferID, EventShared, nil, map[string]interface{}{"share_id": sh.ID, "emails": sh.Emails})
+}
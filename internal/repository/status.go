@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Status is the lifecycle state of a transfer.
+type Status string
+
+const (
+	StatusInit        Status = "INIT"
+	StatusScanning    Status = "SCANNING"
+	StatusReady       Status = "READY"
+	StatusQuarantined Status = "QUARANTINED"
+	StatusExpired     Status = "EXPIRED"
+	StatusDeleted     Status = "DELETED"
+)
+
+// transitions lists, for each status, the statuses a transfer may move to.
+// Every write that changes transfers.status is checked against this table.
+//
+//	INIT        → READY        upload completed (malware scanning disabled)
+//	INIT        → SCANNING     upload completed, files wait for the malware scan
+//	INIT        → EXPIRED      expires_at passed before the upload finished
+//	SCANNING    → READY        every file scanned clean
+//	SCANNING    → QUARANTINED  a file is infected; kept for inspection until it expires
+//	SCANNING    → EXPIRED      expires_at passed before the scan finished
+//	READY       → EXPIRED      expires_at passed, or expired by the owner
+//	QUARANTINED → DELETED      objects removed by cleanup once expires_at passed
+//	EXPIRED     → READY        revived with a new expires_at (only if it expired while READY)
+//	EXPIRED     → DELETED      objects removed by cleanup
+var transitions = map[Status][]Status{
+	StatusInit:        {StatusReady, StatusScanning, StatusExpired},
+	StatusScanning:    {StatusReady, StatusQuarantined, StatusExpired},
+	StatusReady:       {StatusExpired},
+	StatusQuarantined: {StatusDeleted},
+	StatusExpired:     {StatusReady, StatusDeleted},
+	StatusDeleted:     {},
+}
+
+// Machine-readable reasons carried by TransitionError.
+const (
+	ReasonNotAllowed    = "transition_not_allowed"
+	ReasonExpiresInPast = "expires_at_in_past"
+	ReasonNeverUploaded = "never_uploaded"
+	ReasonNotReady      = "expired_before_ready"
+	ReasonStateChanged  = "state_changed"
+)
+
+// TransitionError reports a status change that the lifecycle does not allow.
+type TransitionError struct {
+	From   Status
+	To     Status
+	Reason string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("cannot move transfer from %s to %s: %s", e.From, e.To, e.Reason)
+}
+
+// Valid reports whether s is a known status.
+func (s Status) Valid() bool {
+	_, ok := transitions[s]
+	return ok
+}
+
+// CanTransition reports whether the table allows moving from one status to another.
+func CanTransition(from, to Status) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckTransition returns a *TransitionError if from → to is not in the table.
+func CheckTransition(from, to Status) error {
+	if !CanTransition(from, to) {
+		return &TransitionError{From: from, To: to, Reason: ReasonNotAllowed}
+	}
+	return nil
+}
+
+// sourcesOf returns every status that may move to s, for use in conditional writes.
+func sourcesOf(to Status) []string {
+	var out []string
+	for from := range transitions {
+		if CanTransition(from, to) {
+			out = append(out, string(from))
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ValidateTransition checks moving t to status to, including the guards that depend on
+// the transfer itself. expiresAt is the expires_at the transfer will have afterwards.
+func ValidateTransition(t *Transfer, to Status, expiresAt time.Time) error {
+	if err := CheckTransition(t.Status, to); err != nil {
+		return err
+	}
+	if t.Status == StatusExpired && to == StatusReady {
+		// reviving only makes sense for a transfer that was uploaded and gets a future expiry;
+		// one that expired while SCANNING never got a clean verdict and stays expired
+		if t.UploadedAt == nil {
+			return &TransitionError{From: t.Status, To: to, Reason: ReasonNeverUploaded}
+		}
+		if t.ExpiredFrom == nil || *t.ExpiredFrom != StatusReady {
+			return &TransitionError{From: t.Status, To: to, Reason: ReasonNotReady}
+		}
+		if !expiresAt.After(time.Now().UTC()) {
+			return &TransitionError{From: t.Status, To: to, Reason: ReasonExpiresInPast}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckTransition(t *testing.T) {
+	all := []Status{StatusInit, StatusScanning, StatusReady, StatusQuarantined, StatusExpired, StatusDeleted}
+	allowed := map[[2]Status]bool{
+		{StatusInit, StatusReady}:           true,
+		{StatusInit, StatusScanning}:        true,
+		{StatusInit, StatusExpired}:         true,
+		{StatusScanning, StatusReady}:       true,
+		{StatusScanning, StatusQuarantined}: true,
+		{StatusScanning, StatusExpired}:     true,
+		{StatusReady, StatusExpired}:        true,
+		{StatusQuarantined, StatusDeleted}:  true,
+		{StatusExpired, StatusReady}:        true,
+		{StatusExpired, StatusDeleted}:      true,
+	}
+
+	for _, from := range all {
+		for _, to := range all {
+			err := CheckTransition(from, to)
+			if allowed[[2]Status{from, to}] {
+				if err != nil {
+					t.Errorf("CheckTransition(%s, %s) = %v, want nil", from, to, err)
+				}
+				continue
+			}
+			var te *TransitionError
+			if !errors.As(err, &te) {
+				t.Errorf("CheckTransition(%s, %s) = %v, want a *TransitionError", from, to, err)
+				continue
+			}
+			if te.From != from || te.To != to || te.Reason != ReasonNotAllowed {
+				t.Errorf("CheckTransition(%s, %s) = %+v", from, to, te)
+			}
+		}
+	}
+}
+
+func TestStatusValid(t *testing.T) {
+	if !StatusQuarantined.Valid() {
+		t.Error("QUARANTINED is not valid")
+	}
+	if Status("PENDING").Valid() {
+		t.Error("PENDING is valid")
+	}
+}
+
+func TestSourcesOf(t *testing.T) {
+	got := sourcesOf(StatusExpired)
+	want := []string{"INIT", "READY", "SCANNING"}
+	if len(got) != len(want) {
+		t.Fatalf("sourcesOf(EXPIRED) = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("sourcesOf(EXPIRED) = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestValidateTransition(t *testing.T) {
+	uploaded := time.Now().UTC().Add(-time.Hour)
+	future := time.Now().UTC().Add(time.Hour)
+	past := time.Now().UTC().Add(-time.Minute)
+	ready, scanning := StatusReady, StatusScanning
+
+	tests := []struct {
+		name      string
+		t         Transfer
+		to        Status
+		expiresAt time.Time
+		reason    string // empty if allowed
+	}{
+		{"revive", Transfer{Status: StatusExpired, UploadedAt: &uploaded, ExpiredFrom: &ready}, StatusReady, future, ""},
+		{"revive never uploaded", Transfer{Status: StatusExpired}, StatusReady, future, ReasonNeverUploaded},
+		{"revive expired while scanning", Transfer{Status: StatusExpired, UploadedAt: &uploaded, ExpiredFrom: &scanning}, StatusReady, future, ReasonNotReady},
+		{"revive into the past", Transfer{Status: StatusExpired, UploadedAt: &uploaded, ExpiredFrom: &ready}, StatusReady, past, ReasonExpiresInPast},
+		{"expire", Transfer{Status: StatusReady, UploadedAt: &uploaded}, StatusExpired, future, ""},
+		{"same status", Transfer{Status: StatusReady, UploadedAt: &uploaded}, StatusReady, future, ReasonNotAllowed},
+		{"leave deleted", Transfer{Status: StatusDeleted}, StatusExpired, future, ReasonNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTransition(&tt.t, tt.to, tt.expiresAt)
+			if tt.reason == "" {
+				if err != nil {
+					t.Fatalf("ValidateTransition = %v, want nil", err)
+				}
+				return
+			}
+			var te *TransitionError
+			if !errors.As(err, &te) || te.Reason != tt.reason {
+				t.Fatalf("ValidateTransition = %v, want reason %s", err, tt.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Suppression reasons.
+const (
+	SuppressionBounce    = "bounce"
+	SuppressionComplaint = "complaint"
+)
+
+// Suppression is one row of the email_suppressions table: an address share emails are no
+// longer sent to, after a permanent bounce or a complaint.
+type Suppression struct {
+	// Email is stored lower-cased.
+	Email  string
+	Reason string
+	// Detail is the bounce type or complaint feedback type reported by SES.
+	Detail    *string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// SuppressionRepository stores the email suppression list. Addresses match case-insensitively.
+type SuppressionRepository interface {
+	// Suppress adds email to the list, or updates the reason if it is already on it.
+	Suppress(ctx context.Context, email, reason string, detail *string) error
+	// Suppressed returns those of emails that are on the list, as given.
+	Suppressed(ctx context.Context, emails []string) ([]string, error)
+	// ListSuppressions returns one page of the list, newest first, and its total size.
+	ListSuppressions(ctx context.Context, limit, offset int) ([]Suppression, int, error)
+	// DeleteSuppression removes email from the list, or returns ErrNotFound.
+	DeleteSuppression(ctx context.Context, email string) error
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
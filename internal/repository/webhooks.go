@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+// Delivery states.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is one row of the webhooks table: an endpoint subscribed to transfer events.
+type Webhook struct {
+	ID string
+	// OwnerID limits the webhook to transfers of one owner; nil receives every transfer.
+	OwnerID *string
+	URL     string
+	// Secret is the HMAC-SHA256 key payloads are signed with.
+	Secret string
+	// Events lists the event types to deliver; empty subscribes to all of them.
+	Events    []string
+	CreatedAt time.Time
+}
+
+// Delivery is one row of the webhook_deliveries log: one event for one webhook.
+type Delivery struct {
+	ID             string
+	WebhookID      string
+	EventType      string
+	TransferID     string
+	Payload        []byte
+	Status         string
+	Attempts       int
+	NextAttemptAt  time.Time
+	LastStatusCode *int
+	LastError      *string
+	CreatedAt      time.Time
+	DeliveredAt    *time.Time
+
+	// URL and Secret of the webhook, filled in by ClaimDeliveries.
+	URL    string
+	Secret string
+}
+
+// DeliveryResult is the outcome of one delivery attempt.
+type DeliveryResult struct {
+	// StatusCode is the receiver's HTTP status, 0 if there was no response.
+	StatusCode int
+	Error      string
+	Succeeded  bool
+	// NextAttemptAt schedules a retry; nil gives up and marks the delivery failed.
+	NextAttemptAt *time.Time
+}
+
+// WebhookRepository stores webhook subscriptions and their delivery log.
+type WebhookRepository interface {
+	CreateWebhook(ctx context.Context, w *Webhook) error
+	GetWebhook(ctx context.Context, id string) (*Webhook, error)
+	// ListWebhooks lists the webhooks of one owner, or all of them when ownerID is nil.
+	ListWebhooks(ctx context.Context, ownerID *string) ([]Webhook, error)
+	// DeleteWebhook removes a webhook and its delivery log.
+	DeleteWebhook(ctx context.Context, id string) error
+
+	// EnqueueDeliveries queues payload for every webhook subscribed to eventType that
+	// receives transfers of ownerID, and returns how many deliveries were queued.
+	EnqueueDeliveries(ctx context.Context, ownerID *string, eventType, transferID string, payload []byte) (int, error)
+	// ClaimDeliveries returns up to limit pending deliveries that are due and hides them
+	// from other claimers for lease, so several instances can send concurrently.
+	ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]Delivery, error)
+	// RecordDeliveryAttempt counts one attempt and stores its outcome.
+	RecordDeliveryAttempt(ctx context.Context, id string, r DeliveryResult) error
+	// ListDeliveries returns one page of a webhook's deliveries, newest first, and the total count.
+	ListDeliveries(ctx context.Context, webhookID string, limit, offset int) ([]Delivery, int, error)
+	// ReplayDelivery queues a failed delivery again with a fresh set of attempts. It returns
+	// ErrNotFound for an unknown delivery and ErrConflict if the delivery has not failed.
+	ReplayDelivery(ctx context.Context, webhookID, id string) error
+}
+
+func subscribed(w *Webhook, ownerID *string, eventType string) bool {
+	if w.OwnerID != nil && (ownerID == nil || *w.OwnerID != *ownerID) {
+		return false
+	}
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// deliveryOutcome maps an attempt result to the delivery's new status, next attempt time
+// and delivered_at.
+func deliveryOutcome(r DeliveryResult) (string, *time.Time, *time.Time) {
+	switch {
+	case r.Succeeded:
+		now := time.Now().UTC()
+		return DeliverySucceeded, nil, &now
+	case r.NextAttemptAt != nil:
+		return DeliveryPending, r.NextAttemptAt, nil
+	default:
+		return DeliveryFailed, nil, nil
+	}
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pavithrankb/weTransfer/internal/repository"
+)
+
+// archiveKey is where the cached ZIP of a transfer is stored, outside the uploads/ prefix.
+func archiveKey(transferID string) string {
+	return fmt.Sprintf("archives/%s.zip", transferID)
+}
+
+// archiveCacheEnabled reports whether built archives are written back to the bucket (ARCHIVE_CACHE=true).
+func archiveCacheEnabled() bool {
+	return os.Getenv("ARCHIVE_CACHE") == "true"
+}
+
+// archiveHandler streams every file of a READY transfer as a single ZIP.
+// The archive counts as one download against max_downloads.
+// GET /transfers/{id}/archive
+func (s *Server) archiveHandler(w http.ResponseWriter, r *http.Request, id string) {
+	ctx := r.Context()
+
+	t := s.loadTransfer(w, r, id, "archive")
+	if t == nil {
+		return
+	}
+
+	if !s.requireReady(w, t, "archive") {
+		return
+	}
+
+	if !s.requireDownloadToken(w, r, id, t.PasswordHash) {
+		return
+	}
+
+	files, err := s.repo.ListFiles(ctx, id)
+	if err != nil {
+		s.logger.Printf("archive: failed to load files id=%s: %v", id, err)
+		http.Error(w, "failed to fetch transfer", http.StatusInternalServerError)
+		return
+	}
+	if len(files) == 0 {
+		s.logger.Printf("archive: no files id=%s", id)
+		http.Error(w, "object not available", http.StatusBadRequest)
+		return
+	}
+
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		s.logger.Printf("archive: s3 bucket not configured")
+		http.Error(w, "s3 bucket not configured", http.StatusInternalServerError)
+		return
+	}
+
+	// atomic increment download_count, same rule as download-url
+	count, err := s.repo.IncrementDownload(ctx, id, "archive")
+	if err != nil {
+		if errors.Is(err, repository.ErrLimitReached) {
+			s.logger.Printf("archive: limit reached id=%s", id)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusGone)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "transfer_limit_reached"})
+			return
+		}
+		if errors.Is(err, repository.ErrConflict) {
+			// expired or deleted since it was loaded
+			s.logger.Printf("archive: transfer no longer READY id=%s", id)
+			s.writeTransitionError(w, err)
+			return
+		}
+		s.logger.Printf("archive: failed to increment count id=%s: %v", id, err)
+		http.Error(w, "failed to update transfer", http.StatusInternalServerError)
+		return
+	}
+	s.notifyDownload(ctx, t, count)
+
+	s.serveArchive(w, r, id, bucket, files)
+}
+
+// serveArchive sends the ZIP of a transfer whose download has already been counted:
+// a redirect to the cached archive if there is one, otherwise the archive is streamed.
+func (s *Server) serveArchive(w http.ResponseWriter, r *http.Request, id, bucket string, files []repository.File) {
+	ctx := r.Context()
+
+	// a previously cached archive is served straight from storage
+	if archiveCacheEnabled() {
+		if _, _, err := s.store.HeadObject(ctx, bucket, archiveKey(id)); err == nil {
+			url, err := s.store.PresignGetURL(ctx, bucket, archiveKey(id), 5*time.Minute)
+			if err == nil {
+				s.logger.Printf("archive: redirecting to cached archive id=%s", id)
+				http.Redirect(w, r, url, http.StatusFound)
+				return
+			}
+			s.logger.Printf("archive: failed to presign cached archive id=%s: %v", id, err)
+		}
+	}
+
+	// large archives take longer than the server's WriteTimeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil && s.debug {
+		s.logger.Printf("archive: unable to clear write deadline: %v", err)
+	}
+
+	var out io.Writer = w
+	var cache *os.File
+	if archiveCacheEnabled() {
+		var err error
+		cache, err = os.CreateTemp("", "transfer-archive-*.zip")
+		if err != nil {
+			s.logger.Printf("archive: failed to create cache file id=%s: %v", id, err)
+		} else {
+			defer os.Remove(cache.Name())
+			defer cache.Close()
+			out = io.MultiWriter(w, cache)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("transfer-%s.zip", id)))
+	w.WriteHeader(http.StatusOK)
+
+	if err := s.writeArchive(ctx, out, bucket, files); err != nil {
+		// headers are already sent; the client sees a truncated archive
+		s.logger.Printf("archive: failed to stream archive id=%s: %v", id, err)
+		return
+	}
+
+	s.logger.Printf("archive streamed id=%s files=%d", id, len(files))
+
+	if cache != nil {
+		s.storeArchive(bucket, id, cache)
+	}
+}
+
+// writeArchive writes files as a ZIP to out. Files are stored uncompressed since
+// typical transfers (video, images, archives) are already compressed.
+func (s *Server) writeArchive(ctx context.Context, out io.Writer, bucket string, files []repository.File) error {
+	zw := zip.NewWriter(out)
+	for _, f := range files {
+		hdr := &zip.FileHeader{Name: f.Filename, Method: zip.Store}
+		if f.UploadedAt != nil {
+			hdr.Modified = *f.UploadedAt
+		}
+		entry, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+
+		body, err := s.store.GetObject(ctx, bucket, f.ObjectKey)
+		if err != nil {
+			return fmt.Errorf("get %s: %w", f.ObjectKey, err)
+		}
+		_, err = io.Copy(entry, body)
+		body.Close()
+		if err != nil {
+			return fmt.Errorf("copy %s: %w", f.ObjectKey, err)
+		}
+	}
+	return zw.Close()
+}
+
+// storeArchive uploads a fully written archive so later requests can be redirected to it (best effort).
+func (s *Server) storeArchive(bucket, id string, cache *os.File) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	if _, err := cache.Seek(0, io.SeekStart); err != nil {
+		s.logger.Printf("archive: failed to rewind cache file id=%s: %v", id, err)
+		return
+	}
+	if err := s.store.PutObject(ctx, bucket, archiveKey(id), "application/zip", cache); err != nil {
+		s.logger.Printf("archive: failed to cache archive id=%s: %v", id, err)
+		return
+	}
+	s.logger.Printf("archive: cached archive id=%s key=%s", id, archiveKey(id))
+}
@@ -0,0 +1,359 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pavithrankb/weTransfer/internal/repository"
+)
+
+// apiKeyPrefix marks our keys so they are easy to spot in logs and secret scanners.
+const apiKeyPrefix = "wt_"
+
+// principal is the authenticated caller of a request.
+type principal struct {
+	OwnerID string
+	KeyID   string
+	Admin   bool
+}
+
+type principalCtxKey struct{}
+
+func principalFrom(ctx context.Context) principal {
+	p, _ := ctx.Value(principalCtxKey{}).(principal)
+	return p
+}
+
+// publicPath reports whether a path is reachable without credentials: the health check,
+// the local store's signed URLs and recipient access through share tokens and personal links.
+func publicPath(path string) bool {
+	return path == "/health" ||
+		strings.HasPrefix(path, "/local-objects/") ||
+		strings.HasPrefix(path, "/shared/") ||
+		strings.HasPrefix(path, "/s/")
+}
+
+// authMiddleware resolves the caller from an API key ("Authorization: Bearer wt_..." or
+// "X-API-Key") or the ADMIN_TOKEN, and rejects unauthenticated requests to private paths.
+// AUTH_DISABLED=true treats every caller as admin for local development.
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	disabled := os.Getenv("AUTH_DISABLED") == "true"
+	if disabled {
+		s.logger.Println("warning: AUTH_DISABLED=true, every request is treated as admin")
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPath(r.URL.Path) {
+			next.ServeHTTP(w, withActor(r, recipientActor))
+			return
+		}
+
+		if disabled {
+			p := principal{Admin: true}
+			next.ServeHTTP(w, withActor(r.WithContext(context.WithValue(r.Context(), principalCtxKey{}, p)), actorID(p)))
+			return
+		}
+
+		key := r.Header.Get("X-API-Key")
+		if key == "" {
+			if v, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+				key = strings.TrimSpace(v)
+			}
+		}
+		if key == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "missing api key", http.StatusUnauthorized)
+			return
+		}
+
+		var p principal
+		if adminToken != "" && subtle.ConstantTimeCompare([]byte(key), []byte(adminToken)) == 1 {
+			p = principal{Admin: true}
+		} else {
+			var err error
+			p, err = s.lookupAPIKey(r.Context(), key)
+			if err != nil {
+				if !errors.Is(err, repository.ErrNotFound) {
+					s.logger.Printf("auth: failed to look up api key: %v", err)
+					http.Error(w, "failed to authenticate", http.StatusInternalServerError)
+					return
+				}
+				if s.debug {
+					s.logger.Printf("auth: rejected api key for %s %s", r.Method, r.URL.Path)
+				}
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "invalid api key", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, withActor(r.WithContext(context.WithValue(r.Context(), principalCtxKey{}, p)), actorID(p)))
+	})
+}
+
+func (s *Server) lookupAPIKey(ctx context.Context, key string) (principal, error) {
+	k, err := s.repo.LookupAPIKey(ctx, hashAPIKey(key))
+	if err != nil {
+		return principal{}, err
+	}
+	return principal{OwnerID: k.OwnerID, KeyID: k.ID}, nil
+}
+
+// hashAPIKey returns the stored form of a key. Keys carry 256 bits of entropy, so a fast hash is sufficient.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomToken returns n random bytes encoded for use in URLs.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// requireAdmin writes 403 and returns false unless the caller used the ADMIN_TOKEN.
+func (s *Server) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if !principalFrom(r.Context()).Admin {
+		http.Error(w, "admin access required", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// authorizeTransfer checks that the caller owns transfer id. Transfers of other owners are
+// reported as 404 so their IDs can't be probed. It writes the error response itself.
+func (s *Server) authorizeTransfer(w http.ResponseWriter, r *http.Request, id string) bool {
+	p := principalFrom(r.Context())
+	if p.Admin {
+		return true
+	}
+
+	t, err := s.repo.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			http.Error(w, "transfer not found", http.StatusNotFound)
+			return false
+		}
+		s.logger.Printf("auth: failed to fetch transfer owner id=%s: %v", id, err)
+		http.Error(w, "failed to fetch transfer", http.StatusInternalServerError)
+		return false
+	}
+	if t.OwnerID == nil || *t.OwnerID != p.OwnerID {
+		if s.debug {
+			s.logger.Printf("auth: owner mismatch id=%s caller=%s", id, p.OwnerID)
+		}
+		http.Error(w, "transfer not found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+type createAPIKeyRequest struct {
+	OwnerID string `json:"owner_id"`
+	Name    string `json:"name"`
+}
+
+type apiKeyResponse struct {
+	ID         string     `json:"id"`
+	OwnerID    string     `json:"owner_id"`
+	Name       string     `json:"name"`
+	Key        string     `json:"key,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+func newAPIKeyResponse(k repository.APIKey) apiKeyResponse {
+	return apiKeyResponse{
+		ID:         k.ID,
+		OwnerID:    k.OwnerID,
+		Name:       k.Name,
+		CreatedAt:  k.CreatedAt,
+		LastUsedAt: k.LastUsedAt,
+		RevokedAt:  k.RevokedAt,
+	}
+}
+
+// apiKeysRootHandler handles /api-keys (admin only).
+func (s *Server) apiKeysRootHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		s.listAPIKeysHandler(w, r)
+	case http.MethodPost:
+		s.createAPIKeyHandler(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// apiKeysSubHandler handles DELETE /api-keys/{id} (admin only).
+func (s *Server) apiKeysSubHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", http.MethodDelete)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api-keys/")
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := s.repo.RevokeAPIKey(r.Context(), id); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		s.logger.Printf("api-keys: failed to revoke key id=%s: %v", id, err)
+		http.Error(w, "failed to revoke api key", http.StatusInternalServerError)
+		return
+	}
+
+	s.logger.Printf("api key revoked id=%s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var req createAPIKeyRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.OwnerID) == "" {
+		http.Error(w, "owner_id is required", http.StatusBadRequest)
+		return
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		s.logger.Printf("api-keys: failed to generate key: %v", err)
+		http.Error(w, "failed to generate api key", http.StatusInternalServerError)
+		return
+	}
+
+	key := apiKeyPrefix + secret
+	k := repository.APIKey{
+		ID:      uuid.New().String(),
+		OwnerID: req.OwnerID,
+		Name:    req.Name,
+		KeyHash: hashAPIKey(key),
+	}
+	if err := s.repo.CreateAPIKey(r.Context(), &k); err != nil {
+		s.logger.Printf("api-keys: failed to insert key: %v", err)
+		http.Error(w, "failed to create api key", http.StatusInternalServerError)
+		return
+	}
+
+	s.logger.Printf("api key created id=%s owner_id=%s", k.ID, k.OwnerID)
+
+	// the plaintext key is only ever returned here
+	resp := newAPIKeyResponse(k)
+	resp.Key = key
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.repo.ListAPIKeys(r.Context(), r.URL.Query().Get("owner_id"))
+	if err != nil {
+		s.logger.Printf("api-keys: failed to list keys: %v", err)
+		http.Error(w, "failed to list api keys", http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]apiKeyResponse, 0, len(keys))
+	for _, k := range keys {
+		items = append(items, newAPIKeyResponse(k))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+}
+
+// protectedTransferResponse is what /shared/{token} reveals of a password-protected
+// transfer until a download token is presented.
+type protectedTransferResponse struct {
+	Protected bool      `json:"password_protected"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// sharedHandler gives recipients access to a transfer through its unguessable share token.
+// expected pattern: /shared/{token}[/download-url|/download|/archive|/unlock]
+func (s *Server) sharedHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/shared/")
+	token, action, _ := strings.Cut(path, "/")
+	if token == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	t, err := s.repo.GetByShareToken(r.Context(), token)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		s.logger.Printf("shared: failed to resolve share token: %v", err)
+		http.Error(w, "failed to fetch transfer", http.StatusInternalServerError)
+		return
+	}
+
+	id := t.ID
+
+	method := http.MethodGet
+	if action == "unlock" {
+		method = http.MethodPost
+	}
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch action {
+	case "":
+		// the share token alone only tells a recipient that a password is needed
+		if t.PasswordHash != nil && s.verifyDownloadToken(id, downloadTokenFrom(r)) != nil {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(protectedTransferResponse{Protected: true, ExpiresAt: t.ExpiresAt})
+			return
+		}
+		s.getTransferHandler(w, r, id)
+	case "download-url":
+		s.downloadURLHandler(w, r, id)
+	case "download":
+		s.serveDownload(w, r, id, "shared-download", nil)
+	case "archive":
+		s.archiveHandler(w, r, id)
+	case "unlock":
+		s.unlockHandler(w, r, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
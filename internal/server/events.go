@@ -0,0 +1,118 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pavithrankb/weTransfer/internal/repository"
+)
+
+// recipientActor is recorded for requests made through a share token, which carry no api key.
+const recipientActor = "recipient"
+
+// withActor attributes the repository writes of r to the caller, for the audit log.
+func withActor(r *http.Request, id string) *http.Request {
+	a := repository.Actor{ID: id, IP: clientIP(r), UserAgent: r.UserAgent()}
+	return r.WithContext(repository.WithActor(r.Context(), a))
+}
+
+// actorID names a principal in the audit log: the owner of the api key, or "admin".
+func actorID(p principal) string {
+	if p.Admin {
+		return "admin"
+	}
+	return p.OwnerID
+}
+
+// clientIP returns the caller's address. X-Forwarded-For is only trusted when
+// TRUST_PROXY_HEADERS=true, i.e. when a load balancer sets it.
+func clientIP(r *http.Request) string {
+	if os.Getenv("TRUST_PROXY_HEADERS") == "true" {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			first, _, _ := strings.Cut(fwd, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type eventResponse struct {
+	ID        int64                  `json:"id"`
+	Type      string                 `json:"type"`
+	Actor     string                 `json:"actor"`
+	ClientIP  string                 `json:"client_ip"`
+	UserAgent string                 `json:"user_agent"`
+	OldValue  map[string]interface{} `json:"old_value"`
+	NewValue  map[string]interface{} `json:"new_value"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+type listEventsResponse struct {
+	Items      []eventResponse `json:"items"`
+	Limit      int             `json:"limit"`
+	Offset     int             `json:"offset"`
+	TotalCount int             `json:"total_count"`
+}
+
+// eventsHandler returns the audit log of a transfer, oldest first.
+// GET /transfers/{id}/events?limit=&offset=
+func (s *Server) eventsHandler(w http.ResponseWriter, r *http.Request, id string) {
+	query := r.URL.Query()
+	limitVal := 50
+	offsetVal := 0
+
+	if l := query.Get("limit"); l != "" {
+		var err error
+		limitVal, err = strconv.Atoi(l)
+		if err != nil || limitVal < 1 || limitVal > 100 {
+			http.Error(w, "invalid limit (1-100)", http.StatusBadRequest)
+			return
+		}
+	}
+	if o := query.Get("offset"); o != "" {
+		var err error
+		offsetVal, err = strconv.Atoi(o)
+		if err != nil || offsetVal < 0 {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, totalCount, err := s.repo.ListEvents(r.Context(), id, limitVal, offsetVal)
+	if err != nil {
+		s.logger.Printf("events: failed to list events id=%s: %v", id, err)
+		http.Error(w, "failed to list events", http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]eventResponse, 0, len(events))
+	for _, e := range events {
+		items = append(items, eventResponse{
+			ID:        e.ID,
+			Type:      e.Type,
+			Actor:     e.Actor,
+			ClientIP:  e.ClientIP,
+			UserAgent: e.UserAgent,
+			OldValue:  e.OldValue,
+			NewValue:  e.NewValue,
+			CreatedAt: e.CreatedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(listEventsResponse{
+		Items:      items,
+		Limit:      limitVal,
+		Offset:     offsetVal,
+		TotalCount: totalCount,
+	})
+}
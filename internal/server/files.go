@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pavithrankb/weTransfer/internal/repository"
+	"github.com/pavithrankb/weTransfer/internal/storage"
+)
+
+// maxFilesPerTransfer caps the manifest size so a single transfer can't fan out unbounded presigns/HEADs.
+const maxFilesPerTransfer = 500
+
+type transferFileResponse struct {
+	ID             string     `json:"id"`
+	Filename       string     `json:"filename"`
+	FileType       *string    `json:"file_type"`
+	FileSize       *int64     `json:"file_size"`
+	UploadedAt     *time.Time `json:"uploaded_at"`
+	ChecksumSHA256 *string    `json:"checksum_sha256"`
+	ChecksumCRC32C *string    `json:"checksum_crc32c"`
+	ScanVerdict    *string    `json:"scan_verdict"`
+	ScanDetail     *string    `json:"scan_detail"`
+	ScannedAt      *time.Time `json:"scanned_at"`
+}
+
+func fileResponse(f repository.File) transferFileResponse {
+	return transferFileResponse{
+		ID:             f.ID,
+		Filename:       f.Filename,
+		FileType:       f.FileType,
+		FileSize:       f.FileSize,
+		UploadedAt:     f.UploadedAt,
+		ChecksumSHA256: f.ChecksumSHA256,
+		ChecksumCRC32C: f.ChecksumCRC32C,
+		ScanVerdict:    f.ScanVerdict,
+		ScanDetail:     f.ScanDetail,
+		ScannedAt:      f.ScannedAt,
+	}
+}
+
+func fileResponses(files []repository.File) []transferFileResponse {
+	out := make([]transferFileResponse, 0, len(files))
+	for _, f := range files {
+		out = append(out, fileResponse(f))
+	}
+	return out
+}
+
+// findFile picks the file a download refers to. fileID may be empty only for single-file transfers.
+func findFile(files []repository.File, fileID string) (repository.File, error) {
+	if fileID == "" {
+		if len(files) == 1 {
+			return files[0], nil
+		}
+		return repository.File{}, fmt.Errorf("file_id is required for transfers with %d files", len(files))
+	}
+	for _, f := range files {
+		if f.ID == fileID {
+			return f, nil
+		}
+	}
+	return repository.File{}, fmt.Errorf("file %s not found in transfer", fileID)
+}
+
+// checksumRequired reports whether REQUIRE_UPLOAD_CHECKSUM=true, so that every uploaded
+// byte must be covered by a checksum the client declared.
+func checksumRequired() bool {
+	return os.Getenv("REQUIRE_UPLOAD_CHECKSUM") == "true"
+}
+
+// validChecksums checks the base64 checksums a client declares for a file. With
+// REQUIRE_UPLOAD_CHECKSUM=true every file must declare its SHA-256.
+func validChecksums(sha256, crc32c string) error {
+	if sha256 == "" && checksumRequired() {
+		return errors.New("checksum_sha256 is required")
+	}
+	if sha256 != "" {
+		if b, err := base64.StdEncoding.DecodeString(sha256); err != nil || len(b) != 32 {
+			return errors.New("checksum_sha256 must be the base64 encoding of 32 bytes")
+		}
+	}
+	if crc32c != "" {
+		if b, err := base64.StdEncoding.DecodeString(crc32c); err != nil || len(b) != 4 {
+			return errors.New("checksum_crc32c must be the base64 encoding of 4 bytes")
+		}
+	}
+	return nil
+}
+
+// verifyChecksums compares the checksums declared for f with those storage holds for the
+// uploaded object. The one signed into the upload URL (SHA-256, else CRC32C) must have been
+// stored; the other is only compared if the backend computed it too.
+func verifyChecksums(f repository.File, stored storage.Checksums) error {
+	declared := storage.Checksums{}
+	if f.ChecksumSHA256 != nil {
+		declared.SHA256 = *f.ChecksumSHA256
+	}
+	if f.ChecksumCRC32C != nil {
+		declared.CRC32C = *f.ChecksumCRC32C
+	}
+
+	switch name, _ := declared.Header(); {
+	case name == "x-amz-checksum-sha256" && stored.SHA256 == "",
+		name == "x-amz-checksum-crc32c" && stored.CRC32C == "":
+		return errors.New("object was stored without its declared checksum")
+	}
+	if declared.SHA256 != "" && stored.SHA256 != "" && declared.SHA256 != stored.SHA256 {
+		return fmt.Errorf("sha256 is %s, declared %s", stored.SHA256, declared.SHA256)
+	}
+	if declared.CRC32C != "" && stored.CRC32C != "" && declared.CRC32C != stored.CRC32C {
+		return fmt.Errorf("crc32c is %s, declared %s", stored.CRC32C, declared.CRC32C)
+	}
+	return nil
+}
+
+// optionalString maps "" to nil for nullable columns.
+func optionalString(v string) *string {
+	if v == "" {
+		return nil
+	}
+	return &v
+}
+
+// validFilename rejects empty names and anything that could escape the uploads/{id}/ prefix.
+func validFilename(name string) bool {
+	return strings.TrimSpace(name) != "" && !strings.Contains(name, "/") && !strings.Contains(name, "..")
+}
+
+// objectKeyFor builds the storage key for a file of a transfer.
+func objectKeyFor(transferID, filename string) string {
+	return fmt.Sprintf("uploads/%s/%s", transferID, filename)
+}
+
+// displayName summarises a manifest for places that show a single name, such as share emails.
+func displayName(files []repository.File) string {
+	if len(files) == 1 {
+		return files[0].Filename
+	}
+	return fmt.Sprintf("%d files", len(files))
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pavithrankb/weTransfer/internal/repository"
+)
+
+// linkURLExpiry is how long the presigned URL behind an emailed link is valid. The link
+// itself lives as long as the transfer; a fresh URL is signed on every click.
+const linkURLExpiry = 5 * time.Minute
+
+// publicBaseURL is where recipients reach the API (PUBLIC_BASE_URL), used to build the
+// links in share emails.
+func publicBaseURL() string {
+	if v := os.Getenv("PUBLIC_BASE_URL"); v != "" {
+		return strings.TrimRight(v, "/")
+	}
+	return "http://localhost:8080"
+}
+
+// recipientLinkURL is the personal download link of a recipient.
+func recipientLinkURL(token string) string {
+	return publicBaseURL() + "/s/" + url.PathEscape(token)
+}
+
+// sharedUnlockURL is where recipients without a personal link unlock a password-protected
+// transfer.
+func sharedUnlockURL(shareToken string) string {
+	return publicBaseURL() + "/shared/" + url.PathEscape(shareToken) + "/unlock"
+}
+
+// sharedDownloadURL is the stable download link of a transfer, or of one of its files if
+// fileID is not empty.
+func sharedDownloadURL(shareToken, fileID string) string {
+	u := publicBaseURL() + "/shared/" + url.PathEscape(shareToken) + "/download"
+	if fileID != "" {
+		u += "?file_id=" + url.QueryEscape(fileID)
+	}
+	return u
+}
+
+// recipientLinkHandler resolves the personal link a recipient got by email and serves the
+// download like /shared/{token}/download, counting it for that recipient as well. Recipients
+// of a password-protected transfer get their download token from /s/{token}/unlock.
+// expected pattern: /s/{token}[/unlock]
+func (s *Server) recipientLinkHandler(w http.ResponseWriter, r *http.Request) {
+	token, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/s/"), "/")
+	if token == "" || (action != "" && action != "unlock") {
+		http.NotFound(w, r)
+		return
+	}
+
+	method := http.MethodGet
+	if action == "unlock" {
+		method = http.MethodPost
+	}
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+
+	rc, err := s.repo.GetRecipientByToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		s.logger.Printf("link: failed to resolve link token: %v", err)
+		http.Error(w, "failed to fetch transfer", http.StatusInternalServerError)
+		return
+	}
+
+	// the audit log names the recipient instead of an anonymous share-token caller
+	r = withActor(r, recipientActor+":"+rc.Email)
+	if action == "unlock" {
+		s.unlockHandler(w, r, rc.TransferID)
+		return
+	}
+	s.serveDownload(w, r, rc.TransferID, "link", func(ctx context.Context) {
+		if err := s.repo.RecordRecipientDownload(ctx, rc.ShareID, rc.Email); err != nil {
+			// the transfer-level count is already taken; only the per-recipient figure is off
+			s.logger.Printf("link: failed to record download of %s id=%s: %v", rc.Email, rc.TransferID, err)
+		}
+	})
+}
+
+// serveDownload is the click-time side of an emailed link: it checks that the transfer is
+// READY, not expired and unlocked, counts the download against max_downloads and redirects
+// to a presigned URL valid for linkURLExpiry. ?file_id= picks a file; without it a
+// multi-file transfer is sent as a ZIP. counted, if not nil, runs once the download is counted.
+func (s *Server) serveDownload(w http.ResponseWriter, r *http.Request, id, op string, counted func(ctx context.Context)) {
+	ctx := r.Context()
+
+	t := s.loadTransfer(w, r, id, op)
+	if t == nil {
+		return
+	}
+
+	if !s.requireReady(w, t, op) {
+		return
+	}
+
+	if !s.requireDownloadToken(w, r, id, t.PasswordHash) {
+		return
+	}
+
+	files, err := s.repo.ListFiles(ctx, id)
+	if err != nil {
+		s.logger.Printf("%s: failed to load files id=%s: %v", op, id, err)
+		http.Error(w, "failed to fetch transfer", http.StatusInternalServerError)
+		return
+	}
+	if len(files) == 0 {
+		s.logger.Printf("%s: no files id=%s", op, id)
+		http.Error(w, "object not available", http.StatusBadRequest)
+		return
+	}
+
+	fileID := r.URL.Query().Get("file_id")
+	archive := fileID == "" && len(files) > 1
+	var file repository.File
+	object := "archive"
+	if !archive {
+		file, err = findFile(files, fileID)
+		if err != nil {
+			s.logger.Printf("%s: %v id=%s", op, err, id)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		object = file.Filename
+	}
+
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		s.logger.Printf("%s: s3 bucket not configured", op)
+		http.Error(w, "s3 bucket not configured", http.StatusInternalServerError)
+		return
+	}
+
+	// atomic increment download_count; the repository enforces max_downloads
+	count, err := s.repo.IncrementDownload(ctx, id, object)
+	if err != nil {
+		if errors.Is(err, repository.ErrLimitReached) {
+			s.logger.Printf("%s: limit reached id=%s", op, id)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusGone)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "transfer_limit_reached"})
+			return
+		}
+		if errors.Is(err, repository.ErrConflict) {
+			// expired or deleted since it was loaded
+			s.logger.Printf("%s: transfer no longer READY id=%s", op, id)
+			s.writeTransitionError(w, err)
+			return
+		}
+		s.logger.Printf("%s: failed to increment count id=%s: %v", op, id, err)
+		http.Error(w, "failed to update transfer", http.StatusInternalServerError)
+		return
+	}
+	s.notifyDownload(ctx, t, count)
+	if counted != nil {
+		counted(ctx)
+	}
+
+	if archive {
+		s.serveArchive(w, r, id, bucket, files)
+		return
+	}
+
+	downloadURL, err := s.store.PresignGetURL(ctx, bucket, file.ObjectKey, linkURLExpiry)
+	if err != nil {
+		s.logger.Printf("%s: failed to presign get url id=%s key=%s: %v", op, id, file.ObjectKey, err)
+		http.Error(w, "failed to presign download url", http.StatusInternalServerError)
+		return
+	}
+
+	s.logger.Printf("%s: redirecting download id=%s key=%s", op, id, file.ObjectKey)
+	http.Redirect(w, r, downloadURL, http.StatusFound)
+}
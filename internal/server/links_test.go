@@ -0,0 +1,131 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/pavithrankb/weTransfer/internal/repository"
+)
+
+// shareWithLink records a share of transfer id to email with the personal link token, as
+// share-download does.
+func (ts *testServer) shareWithLink(id, email, token string) {
+	ts.t.Helper()
+	sh := repository.Share{ID: "share-" + token, TransferID: id, Emails: []string{email}, Tokens: map[string]string{email: token}}
+	if err := ts.s.repo.CreateShare(ts.t.Context(), &sh); err != nil {
+		ts.t.Fatalf("CreateShare: %v", err)
+	}
+}
+
+func TestRecipientLinkDownload(t *testing.T) {
+	ts := newTestServer(t)
+	id, _ := ts.createTransfer()
+	ts.upload(id, "notes.txt", "hello")
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/complete", nil, nil); code != http.StatusOK {
+		t.Fatalf("complete = %d", code)
+	}
+	ts.shareWithLink(id, "anna@example.com", "anna-link")
+
+	resp, err := http.Get(ts.url + "/s/anna-link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(data) != "hello" {
+		t.Errorf("GET /s/{token} = %d %q, want 200 \"hello\"", resp.StatusCode, data)
+	}
+
+	rcs, err := ts.s.repo.ListRecipients(t.Context(), id)
+	if err != nil {
+		t.Fatalf("ListRecipients: %v", err)
+	}
+	if len(rcs) != 1 || rcs[0].DownloadCount != 1 {
+		t.Errorf("recipients = %+v, want anna with one download", rcs)
+	}
+
+	if code := ts.do(http.MethodGet, "/s/unknown", nil, nil); code != http.StatusNotFound {
+		t.Errorf("GET unknown link = %d, want 404", code)
+	}
+	if code := ts.do(http.MethodGet, "/s/anna-link/other", nil, nil); code != http.StatusNotFound {
+		t.Errorf("GET /s/{token}/other = %d, want 404", code)
+	}
+}
+
+func TestRecipientLinkUnlock(t *testing.T) {
+	ts := newTestServer(t)
+	t.Setenv("UNLOCK_MAX_ATTEMPTS", "2")
+	id, _ := ts.createProtectedTransfer("s3cret-pass")
+	ts.upload(id, "notes.txt", "hello")
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/complete", nil, nil); code != http.StatusOK {
+		t.Fatalf("complete = %d", code)
+	}
+	ts.shareWithLink(id, "anna@example.com", "anna-link")
+
+	var denied struct {
+		Error string `json:"error"`
+	}
+	if code := ts.do(http.MethodGet, "/s/anna-link", nil, &denied); code != http.StatusUnauthorized || denied.Error != "password_required" {
+		t.Errorf("GET without a token = %d %q, want 401 password_required", code, denied.Error)
+	}
+	if code := ts.do(http.MethodGet, "/s/anna-link/unlock", nil, nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /s/{token}/unlock = %d, want 405", code)
+	}
+	if code := ts.do(http.MethodPost, "/s/unknown/unlock", map[string]string{"password": "s3cret-pass"}, nil); code != http.StatusNotFound {
+		t.Errorf("unlock of an unknown link = %d, want 404", code)
+	}
+
+	var wrong struct {
+		Error             string `json:"error"`
+		AttemptsRemaining int    `json:"attempts_remaining"`
+	}
+	if code := ts.do(http.MethodPost, "/s/anna-link/unlock", map[string]string{"password": "wrong-pass"}, &wrong); code != http.StatusUnauthorized {
+		t.Fatalf("unlock with a wrong password = %d, want 401", code)
+	}
+	if wrong.Error != "invalid_password" || wrong.AttemptsRemaining != 1 {
+		t.Errorf("response = %+v, want invalid_password with 1 attempt remaining", wrong)
+	}
+
+	var unlocked unlockResponse
+	if code := ts.do(http.MethodPost, "/s/anna-link/unlock", map[string]string{"password": "s3cret-pass"}, &unlocked); code != http.StatusOK {
+		t.Fatalf("unlock = %d, want 200", code)
+	}
+	resp, err := http.Get(ts.url + "/s/anna-link?token=" + unlocked.DownloadToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(data) != "hello" {
+		t.Errorf("GET with the token = %d %q, want 200 \"hello\"", resp.StatusCode, data)
+	}
+
+	// the recipient's attempts count towards the same lockout as /unlock
+	for range 2 {
+		ts.do(http.MethodPost, "/s/anna-link/unlock", map[string]string{"password": "wrong-pass"}, nil)
+	}
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/unlock", map[string]string{"password": "s3cret-pass"}, nil); code != http.StatusLocked {
+		t.Errorf("unlock after the recipient's failures = %d, want 423", code)
+	}
+
+	events, _, err := ts.s.repo.ListEvents(t.Context(), id, 100, 0)
+	if err != nil {
+		t.Fatalf("ListEvents: %v", err)
+	}
+	var actor string
+	for _, e := range events {
+		if e.Type == repository.EventUnlocked {
+			actor = e.Actor
+		}
+	}
+	if actor != recipientActor+":anna@example.com" {
+		t.Errorf("unlocked event actor = %q, want the recipient", actor)
+	}
+}
@@ -0,0 +1,389 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pavithrankb/weTransfer/internal/repository"
+	"github.com/pavithrankb/weTransfer/internal/storage"
+)
+
+const (
+	// minPartSize is the smallest part S3 accepts, except for the last one.
+	minPartSize = 5 << 20
+	// maxParts is the highest part number S3 accepts.
+	maxParts = 10000
+)
+
+// partSizeFor is the part size of an upload of length bytes: S3's minimum, grown for large
+// files so they fit in maxParts. It depends only on the length, so a resumable upload's
+// parts follow from its offset and a multipart upload's from its declared size.
+func partSizeFor(length int64) int64 {
+	size := int64(minPartSize)
+	if n := (length + maxParts - 1) / maxParts; n > size {
+		size = n
+	}
+	return size
+}
+
+// partCount is the number of parts an upload of length bytes is cut into.
+func partCount(length int64) int32 {
+	size := partSizeFor(length)
+	return int32((length + size - 1) / size)
+}
+
+// multipartRouter dispatches /transfers/{id}/multipart[/...] requests.
+// sub is the remainder of the path after "multipart".
+func (s *Server) multipartRouter(w http.ResponseWriter, r *http.Request, id, sub string) {
+	switch sub {
+	case "":
+		switch r.Method {
+		case http.MethodPost:
+			s.startMultipartHandler(w, r, id)
+		case http.MethodDelete:
+			s.abortMultipartHandler(w, r, id)
+		default:
+			w.Header().Set("Allow", "POST, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "/part-url":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.multipartPartURLHandler(w, r, id)
+	case "/parts":
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.listMultipartPartsHandler(w, r, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+type startMultipartRequest struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	// Size in bytes fixes the part layout, and each part URL is signed for its exact size.
+	// Required when MAX_UPLOAD_SIZE is set.
+	Size *int64 `json:"size"`
+}
+
+type startMultipartResponse struct {
+	FileID    string `json:"file_id"`
+	UploadID  string `json:"upload_id"`
+	ObjectKey string `json:"object_key"`
+	// PartSize and PartCount are the layout the parts must follow when a size was declared;
+	// every part but the last is PartSize bytes.
+	PartSize  int64 `json:"part_size,omitempty"`
+	PartCount int32 `json:"part_count,omitempty"`
+}
+
+type multipartPartURLRequest struct {
+	FileID     string `json:"file_id"`
+	PartNumber int32  `json:"part_number"`
+	// ChecksumSHA256 is the base64 SHA-256 of the part, signed into its URL. Required, and
+	// only accepted, when REQUIRE_UPLOAD_CHECKSUM=true.
+	ChecksumSHA256 string `json:"checksum_sha256"`
+}
+
+type multipartPartURLResponse struct {
+	PartNumber int32  `json:"part_number"`
+	UploadURL  string `json:"upload_url"`
+	// Size is the exact length the part must have, if the file's size was declared.
+	Size int64 `json:"size,omitempty"`
+}
+
+type listMultipartPartsResponse struct {
+	FileID   string         `json:"file_id"`
+	UploadID string         `json:"upload_id"`
+	Parts    []storage.Part `json:"parts"`
+}
+
+// startMultipartHandler adds a file to the manifest of an INIT transfer and begins a
+// multipart upload for it. Restarting a file discards its previous upload.
+// POST /transfers/{id}/multipart
+func (s *Server) startMultipartHandler(w http.ResponseWriter, r *http.Request, id string) {
+	mp, ok := s.store.(storage.MultipartStore)
+	if !ok {
+		http.Error(w, "multipart uploads are not supported by this storage backend", http.StatusNotImplemented)
+		return
+	}
+
+	var req startMultipartRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		s.logger.Printf("multipart: invalid start body: %v", err)
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !validFilename(req.Filename) {
+		s.logger.Printf("multipart: invalid filename: %q", req.Filename)
+		http.Error(w, "invalid filename", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+
+	t := s.loadTransfer(w, r, id, "multipart")
+	if t == nil {
+		return
+	}
+
+	if t.Status != repository.StatusInit {
+		s.logger.Printf("multipart: transfer not in INIT state id=%s status=%s", id, t.Status)
+		http.Error(w, "transfer not in INIT state", http.StatusBadRequest)
+		return
+	}
+
+	policy := s.uploadPolicy(t)
+	if err := policy.checkContentType(req.ContentType); err != nil {
+		s.logger.Printf("multipart: rejected by upload policy id=%s filename=%q: %v", id, req.Filename, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// with a limit the declared size bounds every part URL, so parts beyond it can't be stored
+	if req.Size == nil && policy.MaxFileSize > 0 {
+		http.Error(w, "size is required", http.StatusBadRequest)
+		return
+	}
+	if req.Size != nil {
+		if *req.Size < 1 {
+			http.Error(w, "size must be >= 1", http.StatusBadRequest)
+			return
+		}
+		if err := policy.checkSize(*req.Size); err != nil {
+			s.logger.Printf("multipart: rejected by upload policy id=%s filename=%q: %v", id, req.Filename, err)
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		s.logger.Printf("multipart: s3 bucket not configured")
+		http.Error(w, "s3 bucket not configured", http.StatusInternalServerError)
+		return
+	}
+
+	files, err := s.repo.ListFiles(ctx, id)
+	if err != nil {
+		s.logger.Printf("multipart: failed to load files id=%s: %v", id, err)
+		http.Error(w, "failed to fetch transfer", http.StatusInternalServerError)
+		return
+	}
+	var prev *repository.File
+	for i := range files {
+		if files[i].Filename == req.Filename {
+			prev = &files[i]
+		}
+	}
+	if prev == nil && len(files) >= maxFilesPerTransfer {
+		http.Error(w, fmt.Sprintf("at most %d files per transfer", maxFilesPerTransfer), http.StatusBadRequest)
+		return
+	}
+
+	objectKey := objectKeyFor(id, req.Filename)
+	// with checksums required every part carries its own, as the whole object's SHA-256
+	// can't be computed from the parts
+	uploadID, err := mp.CreateMultipartUpload(ctx, bucket, objectKey, req.ContentType, checksumRequired())
+	if err != nil {
+		s.logger.Printf("multipart: failed to create upload id=%s key=%s: %v", id, objectKey, err)
+		http.Error(w, "failed to start multipart upload", http.StatusBadGateway)
+		return
+	}
+
+	rows := []repository.File{{Filename: req.Filename, ContentType: req.ContentType, ObjectKey: objectKey, UploadID: &uploadID, FileSize: req.Size}}
+	if err := s.repo.PutFiles(ctx, id, rows); err != nil {
+		s.logger.Printf("multipart: failed to upsert file id=%s filename=%q: %v", id, req.Filename, err)
+		http.Error(w, "failed to update transfer", http.StatusInternalServerError)
+		return
+	}
+
+	// a restarted upload replaces the previous one; don't leave its parts behind
+	if prev != nil {
+		s.abortMultipart(ctx, bucket, *prev)
+	}
+
+	fileID := rows[0].ID
+	s.logger.Printf("multipart upload started id=%s file_id=%s key=%s upload_id=%s", id, fileID, objectKey, uploadID)
+
+	resp := startMultipartResponse{FileID: fileID, UploadID: uploadID, ObjectKey: objectKey}
+	if req.Size != nil {
+		resp.PartSize = partSizeFor(*req.Size)
+		resp.PartCount = partCount(*req.Size)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// multipartPartURLHandler presigns a PUT URL for one part of a file's multipart upload.
+// POST /transfers/{id}/multipart/part-url
+func (s *Server) multipartPartURLHandler(w http.ResponseWriter, r *http.Request, id string) {
+	var req multipartPartURLRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		s.logger.Printf("multipart: invalid part-url body: %v", err)
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.PartNumber < 1 || req.PartNumber > maxParts {
+		http.Error(w, fmt.Sprintf("part_number must be between 1 and %d", maxParts), http.StatusBadRequest)
+		return
+	}
+	// storage only takes part checksums for uploads started with them, i.e. while checksums
+	// are required
+	if req.ChecksumSHA256 != "" && !checksumRequired() {
+		http.Error(w, "checksum_sha256 is only accepted with REQUIRE_UPLOAD_CHECKSUM=true", http.StatusBadRequest)
+		return
+	}
+	if err := validChecksums(req.ChecksumSHA256, ""); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mp, bucket, file, ok := s.loadActiveMultipart(w, r, id, req.FileID)
+	if !ok {
+		return
+	}
+
+	// a declared size fixes how many parts there are and how big each one is
+	var size int64
+	if file.FileSize != nil {
+		count := partCount(*file.FileSize)
+		if req.PartNumber > count {
+			http.Error(w, fmt.Sprintf("part_number must be between 1 and %d", count), http.StatusBadRequest)
+			return
+		}
+		size = partSizeFor(*file.FileSize)
+		if req.PartNumber == count {
+			size = *file.FileSize - int64(count-1)*size
+		}
+	}
+
+	uploadURL, err := mp.PresignUploadPartURL(r.Context(), bucket, file.ObjectKey, *file.UploadID, req.PartNumber, size, req.ChecksumSHA256, 60*time.Minute)
+	if err != nil {
+		s.logger.Printf("multipart: failed to presign part id=%s file_id=%s part=%d: %v", id, file.ID, req.PartNumber, err)
+		http.Error(w, "failed to presign part url", http.StatusInternalServerError)
+		return
+	}
+
+	if s.debug {
+		s.logger.Printf("multipart: presigned part id=%s file_id=%s part=%d", id, file.ID, req.PartNumber)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(multipartPartURLResponse{PartNumber: req.PartNumber, UploadURL: uploadURL, Size: size})
+}
+
+// listMultipartPartsHandler reports the parts uploaded so far, so clients can resume.
+// GET /transfers/{id}/multipart/parts?file_id=
+func (s *Server) listMultipartPartsHandler(w http.ResponseWriter, r *http.Request, id string) {
+	mp, bucket, file, ok := s.loadActiveMultipart(w, r, id, r.URL.Query().Get("file_id"))
+	if !ok {
+		return
+	}
+
+	parts, err := mp.ListParts(r.Context(), bucket, file.ObjectKey, *file.UploadID)
+	if err != nil {
+		s.logger.Printf("multipart: failed to list parts id=%s file_id=%s: %v", id, file.ID, err)
+		http.Error(w, "failed to list parts", http.StatusBadGateway)
+		return
+	}
+	if parts == nil {
+		parts = []storage.Part{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(listMultipartPartsResponse{FileID: file.ID, UploadID: *file.UploadID, Parts: parts})
+}
+
+// abortMultipartHandler discards a file's multipart upload and removes the file from the manifest.
+// DELETE /transfers/{id}/multipart?file_id=
+func (s *Server) abortMultipartHandler(w http.ResponseWriter, r *http.Request, id string) {
+	mp, bucket, file, ok := s.loadActiveMultipart(w, r, id, r.URL.Query().Get("file_id"))
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	if err := mp.AbortMultipartUpload(ctx, bucket, file.ObjectKey, *file.UploadID); err != nil {
+		s.logger.Printf("multipart: failed to abort id=%s upload_id=%s: %v", id, *file.UploadID, err)
+		http.Error(w, "failed to abort multipart upload", http.StatusBadGateway)
+		return
+	}
+
+	if err := s.repo.DeleteFile(ctx, file.ID, file.UploadID); err != nil {
+		s.logger.Printf("multipart: failed to remove file id=%s file_id=%s: %v", id, file.ID, err)
+		http.Error(w, "failed to update transfer", http.StatusInternalServerError)
+		return
+	}
+
+	s.logger.Printf("multipart upload aborted id=%s file_id=%s upload_id=%s", id, file.ID, *file.UploadID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadActiveMultipart fetches a file of an INIT transfer that has a multipart upload in progress.
+// It writes the error response itself and returns ok=false when the request cannot proceed.
+func (s *Server) loadActiveMultipart(w http.ResponseWriter, r *http.Request, id, fileID string) (storage.MultipartStore, string, repository.File, bool) {
+	mp, ok := s.store.(storage.MultipartStore)
+	if !ok {
+		http.Error(w, "multipart uploads are not supported by this storage backend", http.StatusNotImplemented)
+		return nil, "", repository.File{}, false
+	}
+
+	if fileID == "" {
+		http.Error(w, "file_id is required", http.StatusBadRequest)
+		return nil, "", repository.File{}, false
+	}
+
+	ctx := r.Context()
+
+	t := s.loadTransfer(w, r, id, "multipart")
+	if t == nil {
+		return nil, "", repository.File{}, false
+	}
+
+	if t.Status != repository.StatusInit {
+		s.logger.Printf("multipart: transfer not in INIT state id=%s status=%s", id, t.Status)
+		http.Error(w, "transfer not in INIT state", http.StatusBadRequest)
+		return nil, "", repository.File{}, false
+	}
+
+	files, err := s.repo.ListFiles(ctx, id)
+	if err != nil {
+		s.logger.Printf("multipart: failed to load files id=%s: %v", id, err)
+		http.Error(w, "failed to fetch transfer", http.StatusInternalServerError)
+		return nil, "", repository.File{}, false
+	}
+	file, err := findFile(files, fileID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, "", repository.File{}, false
+	}
+	if file.UploadID == nil {
+		http.Error(w, "no multipart upload in progress", http.StatusBadRequest)
+		return nil, "", repository.File{}, false
+	}
+
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		s.logger.Printf("multipart: s3 bucket not configured")
+		http.Error(w, "s3 bucket not configured", http.StatusInternalServerError)
+		return nil, "", repository.File{}, false
+	}
+
+	return mp, bucket, file, true
+}
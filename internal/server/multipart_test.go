@@ -0,0 +1,157 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	"github.com/pavithrankb/weTransfer/internal/repository"
+	"github.com/pavithrankb/weTransfer/internal/storage"
+)
+
+// uploadMultipart starts a multipart upload of filename, uploads content as its only part
+// and returns the file ID and the part list for complete.
+func (ts *testServer) uploadMultipart(id, filename, content string) (string, []storage.Part) {
+	ts.t.Helper()
+	var start startMultipartResponse
+	req := map[string]interface{}{"filename": filename, "content_type": "text/plain", "size": len(content)}
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/multipart", req, &start); code != http.StatusCreated {
+		ts.t.Fatalf("POST multipart = %d", code)
+	}
+	var part multipartPartURLResponse
+	req = map[string]interface{}{"file_id": start.FileID, "part_number": 1}
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/multipart/part-url", req, &part); code != http.StatusOK {
+		ts.t.Fatalf("POST part-url = %d", code)
+	}
+	code, header := ts.put(part.UploadURL, "", content, nil)
+	if code != http.StatusOK {
+		ts.t.Fatalf("PUT part = %d", code)
+	}
+	return start.FileID, []storage.Part{{PartNumber: 1, ETag: header.Get("ETag")}}
+}
+
+func TestCompleteMultipart(t *testing.T) {
+	ts := newTestServer(t)
+	id, _ := ts.createTransfer()
+	fileID, parts := ts.uploadMultipart(id, "notes.txt", "hello")
+
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/complete", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("complete without parts = %d, want 400", code)
+	}
+	wrong := map[string]interface{}{"files": []map[string]interface{}{{"file_id": fileID, "parts": []storage.Part{{PartNumber: 1, ETag: "other"}}}}}
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/complete", wrong, nil); code != http.StatusBadRequest {
+		t.Errorf("complete with a wrong etag = %d, want 400", code)
+	}
+
+	body := map[string]interface{}{"files": []map[string]interface{}{{"file_id": fileID, "parts": parts}}}
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/complete", body, nil); code != http.StatusOK {
+		t.Fatalf("complete = %d, want 200", code)
+	}
+	size, _, err := ts.store.HeadObject(t.Context(), testBucket, objectKeyFor(id, "notes.txt"))
+	if err != nil || size != 5 {
+		t.Errorf("assembled object = %d bytes, %v; want 5", size, err)
+	}
+}
+
+// A file rejected at complete must not leave the others assembled under an upload_id that
+// no longer exists, or every retry fails.
+func TestCompleteRejectedFileKeepsMultipartRetryable(t *testing.T) {
+	ts := newTestServer(t)
+	id, _ := ts.createTransfer()
+	fileID, parts := ts.uploadMultipart(id, "a.txt", "hello")
+
+	var up uploadURLResponse
+	req := map[string]interface{}{"filename": "b.html", "content_type": "text/html", "size": 5}
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/upload-url", req, &up); code != http.StatusOK {
+		t.Fatalf("upload-url = %d", code)
+	}
+	if code, _ := ts.put(up.Files[0].UploadURL, "text/html", "world", up.Files[0].Headers); code != http.StatusOK {
+		t.Fatalf("PUT b.html = %d", code)
+	}
+
+	// b.html is checked, and rejected, before a.txt is assembled
+	t.Setenv("DENIED_CONTENT_TYPES", "text/html")
+	body := map[string]interface{}{"files": []map[string]interface{}{{"file_id": fileID, "parts": parts}}}
+	var rejected struct {
+		Error string `json:"error"`
+		File  string `json:"file"`
+	}
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/complete", body, &rejected); code != http.StatusUnprocessableEntity {
+		t.Fatalf("complete = %d, want 422", code)
+	}
+	if rejected.Error != "upload_policy_violation" || rejected.File != "b.html" {
+		t.Errorf("response = %+v, want b.html rejected", rejected)
+	}
+	if _, _, err := ts.store.HeadObject(t.Context(), testBucket, objectKeyFor(id, "a.txt")); err == nil {
+		t.Error("a.txt was assembled although complete failed")
+	}
+
+	ts.upload(id, "b.txt", "world")
+	var resp struct {
+		Status   repository.Status `json:"status"`
+		FileSize int64             `json:"file_size"`
+	}
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/complete", body, &resp); code != http.StatusOK {
+		t.Fatalf("retried complete = %d, want 200", code)
+	}
+	if resp.Status != repository.StatusReady || resp.FileSize != 10 {
+		t.Errorf("response = %+v, want READY with 10 bytes", resp)
+	}
+}
+
+// With REQUIRE_UPLOAD_CHECKSUM=true every part is signed with its SHA-256 and named with it
+// on complete.
+func TestMultipartPartChecksums(t *testing.T) {
+	ts := newTestServer(t)
+	id, _ := ts.createTransfer()
+
+	var start startMultipartResponse
+	req := map[string]interface{}{"filename": "notes.txt", "content_type": "text/plain", "size": 5}
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/multipart/part-url", map[string]interface{}{"file_id": "x", "part_number": 1, "checksum_sha256": "x"}, nil); code != http.StatusBadRequest {
+		t.Errorf("part-url with a checksum while none are required = %d, want 400", code)
+	}
+	t.Setenv("REQUIRE_UPLOAD_CHECKSUM", "true")
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/multipart", req, &start); code != http.StatusCreated {
+		t.Fatalf("POST multipart = %d, want 201", code)
+	}
+
+	partURL := "/transfers/" + id + "/multipart/part-url"
+	if code := ts.do(http.MethodPost, partURL, map[string]interface{}{"file_id": start.FileID, "part_number": 1}, nil); code != http.StatusBadRequest {
+		t.Errorf("part-url without checksum_sha256 = %d, want 400", code)
+	}
+	sum := sha256.Sum256([]byte("hello"))
+	checksum := base64.StdEncoding.EncodeToString(sum[:])
+	var part multipartPartURLResponse
+	req = map[string]interface{}{"file_id": start.FileID, "part_number": 1, "checksum_sha256": checksum}
+	if code := ts.do(http.MethodPost, partURL, req, &part); code != http.StatusOK {
+		t.Fatalf("part-url = %d, want 200", code)
+	}
+
+	// storage holds the part to the signed checksum
+	if code, _ := ts.put(part.UploadURL, "", "hello", nil); code != http.StatusForbidden {
+		t.Errorf("PUT part without its checksum header = %d, want 403", code)
+	}
+	withSum := map[string]string{"x-amz-checksum-sha256": checksum}
+	if code, _ := ts.put(part.UploadURL, "", "world", withSum); code != http.StatusBadRequest {
+		t.Errorf("PUT of another body = %d, want 400", code)
+	}
+	code, header := ts.put(part.UploadURL, "", "hello", withSum)
+	if code != http.StatusOK {
+		t.Fatalf("PUT part = %d, want 200", code)
+	}
+
+	complete := func(p storage.Part) int {
+		body := map[string]interface{}{"files": []map[string]interface{}{{"file_id": start.FileID, "parts": []storage.Part{p}}}}
+		return ts.do(http.MethodPost, "/transfers/"+id+"/complete", body, nil)
+	}
+	if code := complete(storage.Part{PartNumber: 1, ETag: header.Get("ETag")}); code != http.StatusBadRequest {
+		t.Errorf("complete without the part's checksum = %d, want 400", code)
+	}
+	if code := complete(storage.Part{PartNumber: 1, ETag: header.Get("ETag"), ChecksumSHA256: checksum}); code != http.StatusOK {
+		t.Fatalf("complete = %d, want 200", code)
+	}
+	if got := ts.status(id); got != repository.StatusReady {
+		t.Errorf("status = %s, want READY", got)
+	}
+}
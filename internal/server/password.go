@@ -0,0 +1,194 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pavithrankb/weTransfer/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// bcrypt ignores everything after 72 bytes, so longer passwords are rejected up front
+	minPasswordLength = 6
+	maxPasswordLength = 72
+
+	downloadTokenTTL       = 15 * time.Minute
+	defaultMaxUnlockFailed = 5
+)
+
+type unlockRequest struct {
+	Password string `json:"password"`
+}
+
+type unlockResponse struct {
+	DownloadToken string    `json:"download_token"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+func validatePassword(p string) error {
+	if len(p) < minPasswordLength || len(p) > maxPasswordLength {
+		return fmt.Errorf("password must be between %d and %d bytes", minPasswordLength, maxPasswordLength)
+	}
+	return nil
+}
+
+func hashPassword(p string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(p), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(h), nil
+}
+
+// maxUnlockFailures is the number of wrong passwords after which a transfer is locked (UNLOCK_MAX_ATTEMPTS).
+func maxUnlockFailures() int {
+	if v, err := strconv.Atoi(os.Getenv("UNLOCK_MAX_ATTEMPTS")); err == nil && v > 0 {
+		return v
+	}
+	return defaultMaxUnlockFailed
+}
+
+// unlockHandler exchanges the transfer password for a short-lived download token.
+// POST /transfers/{id}/unlock
+func (s *Server) unlockHandler(w http.ResponseWriter, r *http.Request, id string) {
+	var req unlockRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		s.logger.Printf("unlock: invalid body: %v", err)
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	t := s.loadTransfer(w, r, id, "unlock")
+	if t == nil {
+		return
+	}
+	passwordHash := t.PasswordHash
+
+	if passwordHash == nil {
+		http.Error(w, "transfer is not password protected", http.StatusBadRequest)
+		return
+	}
+
+	// claim the attempt before comparing so parallel guesses can't slip past the limit
+	attempts, err := s.repo.ClaimUnlockAttempt(ctx, id, maxUnlockFailures())
+	if errors.Is(err, repository.ErrLocked) {
+		s.logger.Printf("unlock: transfer locked id=%s failed_attempts=%d", id, attempts)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusLocked)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "transfer_locked"})
+		return
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		s.logger.Printf("unlock: failed to claim attempt id=%s: %v", id, err)
+		http.Error(w, "failed to unlock transfer", http.StatusInternalServerError)
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(*passwordHash), []byte(req.Password)); err != nil {
+		if err := s.repo.RecordUnlockFailure(ctx, id, attempts); err != nil {
+			s.logger.Printf("unlock: failed to record failed attempt id=%s: %v", id, err)
+		}
+		s.logger.Printf("unlock: wrong password id=%s failed_attempts=%d", id, attempts)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":              "invalid_password",
+			"attempts_remaining": max(maxUnlockFailures()-attempts, 0),
+		})
+		return
+	}
+
+	// also records the successful unlock in the audit log
+	if err := s.repo.ResetUnlockFailures(ctx, id); err != nil {
+		s.logger.Printf("unlock: failed to reset failed attempts id=%s: %v", id, err)
+	}
+
+	tokenExpiresAt := time.Now().UTC().Add(downloadTokenTTL).Truncate(time.Second)
+	token := s.issueDownloadToken(id, tokenExpiresAt)
+
+	s.logger.Printf("unlock: download token issued id=%s", id)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(unlockResponse{DownloadToken: token, ExpiresAt: tokenExpiresAt})
+}
+
+// issueDownloadToken returns "<expiry>.<signature>" where the signature is an HMAC over the transfer ID and expiry.
+func (s *Server) issueDownloadToken(transferID string, expiresAt time.Time) string {
+	exp := strconv.FormatInt(expiresAt.Unix(), 10)
+	return exp + "." + s.signDownloadToken(transferID, exp)
+}
+
+func (s *Server) verifyDownloadToken(transferID, token string) error {
+	exp, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return errors.New("malformed download token")
+	}
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return errors.New("malformed download token")
+	}
+	if time.Now().Unix() > expUnix {
+		return errors.New("download token expired")
+	}
+	if !hmac.Equal([]byte(sig), []byte(s.signDownloadToken(transferID, exp))) {
+		return errors.New("invalid download token")
+	}
+	return nil
+}
+
+func (s *Server) signDownloadToken(transferID, exp string) string {
+	mac := hmac.New(sha256.New, s.tokenSecret)
+	fmt.Fprintf(mac, "download\n%s\n%s", transferID, exp)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// downloadTokenFrom returns the download token r carries, if any.
+func downloadTokenFrom(r *http.Request) string {
+	if token := r.Header.Get("X-Download-Token"); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("token")
+}
+
+// requireDownloadToken enforces the password on protected transfers. The token from
+// /unlock is accepted from the X-Download-Token header or the ?token= query parameter.
+// It writes the error response itself and returns false when access is denied.
+func (s *Server) requireDownloadToken(w http.ResponseWriter, r *http.Request, id string, passwordHash *string) bool {
+	if passwordHash == nil {
+		return true
+	}
+
+	token := downloadTokenFrom(r)
+	if token == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "password_required"})
+		return false
+	}
+	if err := s.verifyDownloadToken(id, token); err != nil {
+		s.logger.Printf("rejected download token id=%s: %v", id, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_download_token"})
+		return false
+	}
+	return true
+}
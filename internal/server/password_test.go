@@ -0,0 +1,163 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type unlockErrorBody struct {
+	Error             string `json:"error"`
+	AttemptsRemaining *int   `json:"attempts_remaining"`
+}
+
+// unlock posts password to /unlock of transfer id and returns the status and error body.
+func (ts *testServer) unlock(id, password string) (int, unlockErrorBody) {
+	ts.t.Helper()
+	var body unlockErrorBody
+	code := ts.do(http.MethodPost, "/transfers/"+id+"/unlock", map[string]string{"password": password}, &body)
+	return code, body
+}
+
+func TestUnlock(t *testing.T) {
+	t.Setenv("UNLOCK_MAX_ATTEMPTS", "3")
+	ts := newTestServer(t)
+	id, _ := ts.createProtectedTransfer("s3cret-pass")
+	ts.upload(id, "notes.txt", "hello")
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/complete", nil, nil); code != http.StatusOK {
+		t.Fatalf("complete = %d", code)
+	}
+
+	var denied unlockErrorBody
+	if code := ts.do(http.MethodGet, "/transfers/"+id+"/download-url", nil, &denied); code != http.StatusUnauthorized || denied.Error != "password_required" {
+		t.Errorf("download-url without a token = %d %q, want 401 password_required", code, denied.Error)
+	}
+	if code := ts.do(http.MethodGet, "/transfers/"+id+"/download-url?token=1.forged", nil, &denied); code != http.StatusUnauthorized || denied.Error != "invalid_download_token" {
+		t.Errorf("download-url with a forged token = %d %q, want 401 invalid_download_token", code, denied.Error)
+	}
+
+	code, wrong := ts.unlock(id, "wrong-pass")
+	if code != http.StatusUnauthorized || wrong.Error != "invalid_password" || wrong.AttemptsRemaining == nil || *wrong.AttemptsRemaining != 2 {
+		t.Fatalf("unlock with a wrong password = %d %+v, want 401 with 2 attempts remaining", code, wrong)
+	}
+
+	var unlocked unlockResponse
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/unlock", map[string]string{"password": "s3cret-pass"}, &unlocked); code != http.StatusOK {
+		t.Fatalf("unlock = %d, want 200", code)
+	}
+	if code := ts.do(http.MethodGet, "/transfers/"+id+"/download-url?token="+unlocked.DownloadToken, nil, nil); code != http.StatusOK {
+		t.Errorf("download-url with the token = %d, want 200", code)
+	}
+
+	// a token is bound to its transfer
+	other, _ := ts.createProtectedTransfer("s3cret-pass")
+	ts.upload(other, "notes.txt", "hello")
+	if code := ts.do(http.MethodPost, "/transfers/"+other+"/complete", nil, nil); code != http.StatusOK {
+		t.Fatalf("complete = %d", code)
+	}
+	if code := ts.do(http.MethodGet, "/transfers/"+other+"/download-url?token="+unlocked.DownloadToken, nil, &denied); code != http.StatusUnauthorized || denied.Error != "invalid_download_token" {
+		t.Errorf("download-url of another transfer = %d %q, want 401 invalid_download_token", code, denied.Error)
+	}
+
+	// the right password resets the count
+	if code, wrong := ts.unlock(id, "wrong-pass"); code != http.StatusUnauthorized || wrong.AttemptsRemaining == nil || *wrong.AttemptsRemaining != 2 {
+		t.Errorf("unlock after a successful one = %d %+v, want 2 attempts remaining", code, wrong)
+	}
+}
+
+func TestUnlockLockout(t *testing.T) {
+	t.Setenv("UNLOCK_MAX_ATTEMPTS", "3")
+	ts := newTestServer(t)
+	id, _ := ts.createProtectedTransfer("s3cret-pass")
+
+	for want := 2; want >= 0; want-- {
+		code, wrong := ts.unlock(id, "wrong-pass")
+		if code != http.StatusUnauthorized || wrong.AttemptsRemaining == nil || *wrong.AttemptsRemaining != want {
+			t.Fatalf("wrong password = %d %+v, want 401 with %d attempts remaining", code, wrong, want)
+		}
+	}
+	// once locked even the right password is refused
+	code, locked := ts.unlock(id, "s3cret-pass")
+	if code != http.StatusLocked || locked.Error != "transfer_locked" {
+		t.Errorf("unlock of a locked transfer = %d %q, want 423 transfer_locked", code, locked.Error)
+	}
+}
+
+// TestUnlockConcurrentGuesses sends more parallel guesses than the limit allows. Each attempt
+// is claimed before the password is compared, so only the allowed number reach the compare.
+func TestUnlockConcurrentGuesses(t *testing.T) {
+	t.Setenv("UNLOCK_MAX_ATTEMPTS", "3")
+	ts := newTestServer(t)
+	id, _ := ts.createProtectedTransfer("s3cret-pass")
+
+	const guesses = 12
+	codes := make(chan int, guesses)
+	var wg sync.WaitGroup
+	for range guesses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, err := http.NewRequest(http.MethodPost, ts.url+"/transfers/"+id+"/unlock", strings.NewReader(`{"password": "wrong-pass"}`))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			req.Header.Set("Authorization", "Bearer "+ts.key)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			codes <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	if counts[http.StatusUnauthorized] != 3 || counts[http.StatusLocked] != guesses-3 {
+		t.Errorf("responses = %v, want 3 x 401 and %d x 423", counts, guesses-3)
+	}
+}
+
+func TestSharedProtectedTransfer(t *testing.T) {
+	ts := newTestServer(t)
+	id, share := ts.createProtectedTransfer("s3cret-pass")
+	ts.upload(id, "notes.txt", "hello")
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/complete", nil, nil); code != http.StatusOK {
+		t.Fatalf("complete = %d", code)
+	}
+
+	// only the password requirement and the expiry until a download token is presented
+	for _, path := range []string{"/shared/" + share, "/shared/" + share + "?token=1.forged"} {
+		var summary map[string]interface{}
+		if code := ts.do(http.MethodGet, path, nil, &summary); code != http.StatusOK {
+			t.Fatalf("GET %s = %d, want 200", path, code)
+		}
+		if len(summary) != 2 || summary["password_protected"] != true || summary["expires_at"] == nil {
+			t.Errorf("GET %s = %v, want only password_protected and expires_at", path, summary)
+		}
+	}
+
+	var unlocked unlockResponse
+	if code := ts.do(http.MethodPost, "/shared/"+share+"/unlock", map[string]string{"password": "s3cret-pass"}, &unlocked); code != http.StatusOK {
+		t.Fatalf("unlock = %d, want 200", code)
+	}
+	var full transferResponse
+	if code := ts.do(http.MethodGet, "/shared/"+share+"?token="+unlocked.DownloadToken, nil, &full); code != http.StatusOK {
+		t.Fatalf("GET with a download token = %d, want 200", code)
+	}
+	if full.ID != id || len(full.Files) != 1 || full.Files[0].Filename != "notes.txt" {
+		t.Errorf("GET with a download token = %+v, want the transfer and its files", full)
+	}
+
+	open, openShare := ts.createTransfer()
+	if code := ts.do(http.MethodGet, "/shared/"+openShare, nil, &full); code != http.StatusOK || full.ID != open {
+		t.Errorf("GET of an unprotected transfer = %d %+v, want it in full", code, full)
+	}
+}
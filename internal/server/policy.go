@@ -0,0 +1,99 @@
+package server
+
+import (
+	"fmt"
+	"mime"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pavithrankb/weTransfer/internal/repository"
+)
+
+// uploadPolicy limits what may be uploaded into a transfer. The zero value allows anything.
+type uploadPolicy struct {
+	// MaxFileSize is the largest file in bytes; 0 means no limit.
+	MaxFileSize int64
+	// Allowed, if not empty, lists the only content types accepted. Denied content types
+	// are refused even if allowed. Entries are media types, or "type/*" for a whole family.
+	Allowed []string
+	Denied  []string
+}
+
+// deploymentUploadPolicy reads the policy of the deployment from MAX_UPLOAD_SIZE,
+// ALLOWED_CONTENT_TYPES and DENIED_CONTENT_TYPES.
+func deploymentUploadPolicy() uploadPolicy {
+	var p uploadPolicy
+	if v, err := strconv.ParseInt(os.Getenv("MAX_UPLOAD_SIZE"), 10, 64); err == nil && v > 0 {
+		p.MaxFileSize = v
+	}
+	p.Allowed = contentTypeList(os.Getenv("ALLOWED_CONTENT_TYPES"))
+	p.Denied = contentTypeList(os.Getenv("DENIED_CONTENT_TYPES"))
+	return p
+}
+
+// uploadPolicy is the policy uploads into t are held to. Every owner currently gets the
+// deployment policy; this is where per-owner limits would be looked up.
+func (s *Server) uploadPolicy(t *repository.Transfer) uploadPolicy {
+	return deploymentUploadPolicy()
+}
+
+func contentTypeList(v string) []string {
+	var out []string
+	for _, ct := range strings.Split(v, ",") {
+		if ct = strings.ToLower(strings.TrimSpace(ct)); ct != "" {
+			out = append(out, ct)
+		}
+	}
+	return out
+}
+
+// checkContentType reports why a file of the given content type may not be uploaded, or
+// nil if it may. Parameters such as charset are ignored.
+func (p uploadPolicy) checkContentType(contentType string) error {
+	if len(p.Allowed) == 0 && len(p.Denied) == 0 {
+		return nil
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		if contentType == "" {
+			return fmt.Errorf("content_type is required")
+		}
+		return fmt.Errorf("invalid content type %q", contentType)
+	}
+	if matchContentType(p.Denied, mt) {
+		return fmt.Errorf("content type %s is not allowed", mt)
+	}
+	if len(p.Allowed) > 0 && !matchContentType(p.Allowed, mt) {
+		return fmt.Errorf("content type %s is not allowed", mt)
+	}
+	return nil
+}
+
+// checkSize reports why a file of size bytes may not be uploaded, or nil if it may.
+func (p uploadPolicy) checkSize(size int64) error {
+	if p.MaxFileSize > 0 && size > p.MaxFileSize {
+		return fmt.Errorf("file is %d bytes, the limit is %d", size, p.MaxFileSize)
+	}
+	return nil
+}
+
+// check reports why a stored object of the given size and content type violates the policy.
+func (p uploadPolicy) check(size int64, contentType string) error {
+	if err := p.checkSize(size); err != nil {
+		return err
+	}
+	return p.checkContentType(contentType)
+}
+
+func matchContentType(list []string, mediaType string) bool {
+	for _, ct := range list {
+		if ct == mediaType {
+			return true
+		}
+		if family, ok := strings.CutSuffix(ct, "/*"); ok && strings.HasPrefix(mediaType, family+"/") {
+			return true
+		}
+	}
+	return false
+}
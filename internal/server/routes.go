@@ -3,6 +3,8 @@ package server
 import (
 	"encoding/json"
 	"net/http"
+
+	"github.com/pavithrankb/weTransfer/internal/storage"
 )
 
 func (s *Server) RegisterRoutes() http.Handler {
@@ -12,7 +14,20 @@ func (s *Server) RegisterRoutes() http.Handler {
 	mux.HandleFunc("/transfers", s.transfersRootHandler)
 	mux.HandleFunc("/trigger-delete", s.triggerDeleteHandler)
 	mux.HandleFunc("/transfers/", s.transfersSubHandler)
-	return mux
+	mux.HandleFunc("/shared/", s.sharedHandler)
+	mux.HandleFunc("/s/", s.recipientLinkHandler)
+	mux.HandleFunc("/api-keys", s.apiKeysRootHandler)
+	mux.HandleFunc("/api-keys/", s.apiKeysSubHandler)
+	mux.HandleFunc("/webhooks", s.webhooksRootHandler)
+	mux.HandleFunc("/webhooks/", s.webhooksSubHandler)
+	mux.HandleFunc("/suppressions", s.suppressionsRootHandler)
+	mux.HandleFunc("/suppressions/", s.suppressionsSubHandler)
+
+	// the local backend serves its own presigned URLs from this process
+	if ls, ok := s.store.(*storage.LocalStore); ok {
+		mux.Handle(storage.LocalObjectsPath, ls)
+	}
+	return s.authMiddleware(mux)
 }
 
 func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pavithrankb/weTransfer/internal/repository"
+)
+
+const (
+	scanBatchSize = 5
+	// scanLease is how long a claimed transfer is left to one scanner; a scan that
+	// fails or dies is picked up again once it runs out
+	scanLease = 10 * time.Minute
+)
+
+// RunScans scans the files of SCANNING transfers and moves each to READY or QUARANTINED.
+// A file whose scan fails is retried after scanLease; verdicts already recorded are kept.
+// A transfer still SCANNING when its expires_at passes is expired instead, so one whose
+// scan keeps failing doesn't stay SCANNING for good.
+func (s *Server) RunScans() {
+	if s.scanner == nil {
+		return
+	}
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), scanLease)
+	defer cancel()
+
+	for {
+		transfers, err := s.repo.ClaimScans(ctx, scanBatchSize, scanLease)
+		if err != nil {
+			s.logger.Printf("scan: failed to claim transfers: %v", err)
+			return
+		}
+		for i := range transfers {
+			s.scanTransfer(ctx, bucket, &transfers[i])
+		}
+		if len(transfers) < scanBatchSize {
+			return
+		}
+	}
+}
+
+func (s *Server) scanTransfer(ctx context.Context, bucket string, t *repository.Transfer) {
+	if isExpired(t.ExpiresAt) {
+		s.logger.Printf("scan: transfer expired before its scan finished id=%s", t.ID)
+		s.expireIfDue(ctx, t)
+		return
+	}
+
+	files, err := s.repo.ListFiles(ctx, t.ID)
+	if err != nil {
+		s.logger.Printf("scan: failed to load files id=%s: %v", t.ID, err)
+		return
+	}
+
+	var infected []string
+	for _, f := range files {
+		if f.ScanVerdict != nil {
+			// scanned before an earlier run was cut short
+			if *f.ScanVerdict == repository.ScanInfected {
+				infected = append(infected, f.Filename)
+			}
+			continue
+		}
+
+		body, err := s.store.GetObject(ctx, bucket, f.ObjectKey)
+		if err != nil {
+			s.logger.Printf("scan: failed to open object id=%s key=%s: %v", t.ID, f.ObjectKey, err)
+			return
+		}
+		res, err := s.scanner.Scan(ctx, body)
+		body.Close()
+		if err != nil {
+			s.logger.Printf("scan: failed to scan id=%s key=%s: %v", t.ID, f.ObjectKey, err)
+			return
+		}
+
+		verdict, detail := repository.ScanClean, (*string)(nil)
+		if res.Infected {
+			verdict, detail = repository.ScanInfected, &res.Signature
+			infected = append(infected, f.Filename)
+			s.logger.Printf("scan: infected file id=%s key=%s signature=%s", t.ID, f.ObjectKey, res.Signature)
+		}
+		if err := s.repo.RecordScanVerdict(ctx, f.ID, verdict, detail); err != nil {
+			s.logger.Printf("scan: failed to record verdict id=%s key=%s: %v", t.ID, f.ObjectKey, err)
+			return
+		}
+	}
+
+	to, event := repository.StatusReady, webhookTransferReady
+	if len(infected) > 0 {
+		to, event = repository.StatusQuarantined, webhookTransferQuarantined
+	}
+	if err := s.repo.FinishScan(ctx, t.ID, to, infected); err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			// deleted or expired while it was being scanned
+			s.logger.Printf("scan: transfer no longer SCANNING id=%s", t.ID)
+			return
+		}
+		s.logger.Printf("scan: failed to finish scan id=%s: %v", t.ID, err)
+		return
+	}
+
+	t.Status = to
+	s.notifyWebhooks(ctx, event, t)
+	s.logger.Printf("scan: transfer %s id=%s files=%d", to, t.ID, len(files))
+}
+
+// requireReady writes an error and returns false unless t is READY. Files that still await
+// the malware scan or were found infected are never handed out.
+func (s *Server) requireReady(w http.ResponseWriter, t *repository.Transfer, op string) bool {
+	if t.Status == repository.StatusReady {
+		return true
+	}
+	s.logger.Printf("%s: transfer not READY id=%s status=%s", op, t.ID, t.Status)
+	switch t.Status {
+	case repository.StatusScanning:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "scan_pending"})
+	case repository.StatusQuarantined:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "quarantined"})
+	default:
+		http.Error(w, "transfer not ready", http.StatusBadRequest)
+	}
+	return false
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pavithrankb/weTransfer/internal/repository"
+	"github.com/pavithrankb/weTransfer/internal/storage"
+)
+
+// fakeScanner reports bodies containing "EICAR" infected and fails on bodies containing
+// "broken" while failing is set. It counts the bodies it was given.
+type fakeScanner struct {
+	mu      sync.Mutex
+	scanned []string
+	failing bool
+}
+
+func (f *fakeScanner) Scan(ctx context.Context, body io.Reader) (storage.ScanResult, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return storage.ScanResult{}, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.scanned = append(f.scanned, string(data))
+	switch {
+	case f.failing && strings.Contains(string(data), "broken"):
+		return storage.ScanResult{}, errors.New("scanner unavailable")
+	case strings.Contains(string(data), "EICAR"):
+		return storage.ScanResult{Infected: true, Signature: "Eicar-Test-Signature"}, nil
+	}
+	return storage.ScanResult{}, nil
+}
+
+func (f *fakeScanner) calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.scanned...)
+}
+
+// newScanningServer is newTestServer with scanner and a broker for share emails.
+func newScanningServer(t *testing.T, scanner storage.Scanner) *testServer {
+	t.Helper()
+	ts := newTestServer(t)
+	ts.s.scanner = scanner
+	ts.s.publisher, ts.s.shareTopic = storage.NewMemoryBroker(0), "shares"
+	return ts
+}
+
+// completeScanning uploads files (by filename) to a new transfer and completes it, which
+// leaves it SCANNING. It returns the transfer ID and share token.
+func (ts *testServer) completeScanning(files map[string]string) (string, string) {
+	ts.t.Helper()
+	id, token := ts.createTransfer()
+	for name, content := range files {
+		ts.upload(id, name, content)
+	}
+	var resp struct {
+		Status repository.Status `json:"status"`
+	}
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/complete", nil, &resp); code != http.StatusOK {
+		ts.t.Fatalf("complete = %d", code)
+	}
+	if resp.Status != repository.StatusScanning {
+		ts.t.Fatalf("complete status = %s, want SCANNING", resp.Status)
+	}
+	return id, token
+}
+
+// expectNotReady checks that downloads and shares of transfer id fail with code and
+// errorCode, as returned by requireReady.
+func (ts *testServer) expectNotReady(id, token string, code int, errorCode string) {
+	ts.t.Helper()
+	for _, req := range []struct {
+		method, path string
+		body         interface{}
+	}{
+		{http.MethodGet, "/transfers/" + id + "/download-url", nil},
+		{http.MethodGet, "/transfers/" + id + "/archive", nil},
+		{http.MethodGet, "/shared/" + token + "/download-url", nil},
+		{http.MethodPost, "/transfers/" + id + "/share-download", map[string]interface{}{"emails": []string{"anna@example.com"}}},
+	} {
+		var resp struct {
+			Error string `json:"error"`
+		}
+		if got := ts.do(req.method, req.path, req.body, &resp); got != code || resp.Error != errorCode {
+			ts.t.Errorf("%s %s = %d %q, want %d %s", req.method, req.path, got, resp.Error, code, errorCode)
+		}
+	}
+}
+
+// verdicts returns the scan verdicts recorded for the files of transfer id, by filename.
+func (ts *testServer) verdicts(id string) map[string]string {
+	ts.t.Helper()
+	files, err := ts.s.repo.ListFiles(ts.t.Context(), id)
+	if err != nil {
+		ts.t.Fatalf("ListFiles: %v", err)
+	}
+	out := make(map[string]string, len(files))
+	for _, f := range files {
+		if f.ScanVerdict != nil {
+			out[f.Filename] = *f.ScanVerdict
+		}
+	}
+	return out
+}
+
+func TestScanClean(t *testing.T) {
+	ts := newScanningServer(t, &fakeScanner{})
+	id, token := ts.completeScanning(map[string]string{"a.txt": "hello", "b.txt": "world"})
+
+	ts.expectNotReady(id, token, http.StatusConflict, "scan_pending")
+
+	ts.s.RunScans()
+	if got := ts.status(id); got != repository.StatusReady {
+		t.Fatalf("status = %s, want READY", got)
+	}
+	if v := ts.verdicts(id); v["a.txt"] != repository.ScanClean || v["b.txt"] != repository.ScanClean {
+		t.Errorf("verdicts = %v, want both clean", v)
+	}
+	files, err := ts.s.repo.ListFiles(t.Context(), id)
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+	if code := ts.do(http.MethodGet, "/transfers/"+id+"/download-url?file_id="+files[0].ID, nil, nil); code != http.StatusOK {
+		t.Errorf("download-url = %d, want 200", code)
+	}
+}
+
+func TestScanQuarantined(t *testing.T) {
+	ts := newScanningServer(t, &fakeScanner{})
+	id, token := ts.completeScanning(map[string]string{"a.txt": "hello", "b.txt": "EICAR"})
+
+	ts.s.RunScans()
+	if got := ts.status(id); got != repository.StatusQuarantined {
+		t.Fatalf("status = %s, want QUARANTINED", got)
+	}
+	if v := ts.verdicts(id); v["a.txt"] != repository.ScanClean || v["b.txt"] != repository.ScanInfected {
+		t.Errorf("verdicts = %v, want b.txt infected", v)
+	}
+	ts.expectNotReady(id, token, http.StatusForbidden, "quarantined")
+}
+
+func TestScanResumesWithRecordedVerdicts(t *testing.T) {
+	scanner := &fakeScanner{failing: true}
+	ts := newScanningServer(t, scanner)
+	id, _ := ts.completeScanning(map[string]string{"a.txt": "hello", "b.txt": "broken"})
+
+	// files are scanned by name, so a.txt has its verdict when b.txt fails
+	ts.s.RunScans()
+	if got := ts.status(id); got != repository.StatusScanning {
+		t.Fatalf("status after a failed scan = %s, want SCANNING", got)
+	}
+	if v := ts.verdicts(id); len(v) != 1 || v["a.txt"] != repository.ScanClean {
+		t.Fatalf("verdicts after a failed scan = %v, want a.txt clean only", v)
+	}
+	scanned := len(scanner.calls())
+
+	// the transfer stays claimed until its lease runs out; scan it as the next run would
+	scanner.mu.Lock()
+	scanner.failing = false
+	scanner.mu.Unlock()
+	tr, err := ts.s.repo.Get(t.Context(), id)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	ts.s.scanTransfer(t.Context(), testBucket, tr)
+	if got := scanner.calls()[scanned:]; len(got) != 1 || got[0] != "broken" {
+		t.Errorf("rescanned %q, want only b.txt", got)
+	}
+	if got := ts.status(id); got != repository.StatusReady {
+		t.Errorf("status = %s, want READY", got)
+	}
+}
+
+func TestScanKeepsRecordedInfection(t *testing.T) {
+	ts := newScanningServer(t, &fakeScanner{})
+	id, _ := ts.completeScanning(map[string]string{"a.txt": "hello"})
+
+	// an earlier run found the file infected before it was cut short
+	files, err := ts.s.repo.ListFiles(t.Context(), id)
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+	sig := "Eicar-Test-Signature"
+	if err := ts.s.repo.RecordScanVerdict(t.Context(), files[0].ID, repository.ScanInfected, &sig); err != nil {
+		t.Fatalf("RecordScanVerdict: %v", err)
+	}
+
+	ts.s.RunScans()
+	if got := ts.status(id); got != repository.StatusQuarantined {
+		t.Errorf("status = %s, want QUARANTINED", got)
+	}
+}
+
+func TestReviveExpiredWhileScanning(t *testing.T) {
+	ts := newScanningServer(t, &fakeScanner{})
+	id, _ := ts.completeScanning(map[string]string{"a.txt": "hello"})
+
+	// the transfer runs out of time before the scanner gets to it
+	if ok, err := ts.s.repo.Expire(t.Context(), id); err != nil || !ok {
+		t.Fatalf("Expire = %v, %v", ok, err)
+	}
+
+	var body transitionErrorBody
+	future := map[string]interface{}{"expires_at": time.Now().Add(2 * time.Hour)}
+	if code := ts.do(http.MethodPatch, "/transfers/"+id+"/", future, &body); code != http.StatusConflict {
+		t.Errorf("PATCH expires_at = %d, want 409", code)
+	}
+	if body.Error != repository.ReasonNotReady || body.From != "EXPIRED" || body.To != "READY" {
+		t.Errorf("PATCH expires_at = %+v, want %s", body, repository.ReasonNotReady)
+	}
+	if got := ts.status(id); got != repository.StatusExpired {
+		t.Errorf("status = %s, want EXPIRED", got)
+	}
+	if len(ts.verdicts(id)) != 0 {
+		t.Errorf("verdicts = %v, want none", ts.verdicts(id))
+	}
+}
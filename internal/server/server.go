@@ -1,33 +1,68 @@
 package server
 
 import (
+	"crypto/rand"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
 	"time"
 
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pavithrankb/weTransfer/internal/repository"
 	"github.com/pavithrankb/weTransfer/internal/storage"
 )
 
 type Server struct {
-	port   int
-	db     *pgxpool.Pool
-	s3     *storage.S3
-	sns    *storage.SNS
-	logger *log.Logger
-	debug  bool
+	port        int
+	repo        repository.Repository
+	store       storage.ObjectStore
+	publisher   storage.Publisher
+	shareTopic  string
+	scanner     storage.Scanner
+	logger      *log.Logger
+	debug       bool
+	tokenSecret []byte
+
+	webhookClient *http.Client
 }
 
-func NewServer(db *pgxpool.Pool, s3h *storage.S3, snsh *storage.SNS, logger *log.Logger, debug bool) *Server {
+// NewServer returns the API server. Share emails are published to shareTopic; a nil
+// publisher or an empty topic disables them. scanner may be nil, which makes completed
+// transfers READY without a malware scan.
+func NewServer(repo repository.Repository, store storage.ObjectStore, publisher storage.Publisher, shareTopic string, scanner storage.Scanner, logger *log.Logger, debug bool) *Server {
 	port := 8080
 	s := &Server{
-		port:   port,
-		db:     db,
-		s3:     s3h,
-		sns:    snsh,
-		logger: logger,
-		debug:  debug,
+		port:       port,
+		repo:       repo,
+		store:      store,
+		publisher:  publisher,
+		shareTopic: shareTopic,
+		scanner:    scanner,
+		logger:     logger,
+		debug:      debug,
+		webhookClient: &http.Client{
+			Timeout: 10 * time.Second,
+			// receivers are dialled directly, so webhookControl sees their address
+			Transport: &http.Transport{
+				DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: webhookControl}).DialContext,
+				TLSHandshakeTimeout: 5 * time.Second,
+				MaxIdleConnsPerHost: 4,
+				IdleConnTimeout:     90 * time.Second,
+			},
+			// a redirect is reported as the delivery's status instead of being followed
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}
+
+	// download tokens for password-protected transfers are signed with this key
+	s.tokenSecret = []byte(os.Getenv("DOWNLOAD_TOKEN_SECRET"))
+	if len(s.tokenSecret) == 0 {
+		logger.Println("warning: DOWNLOAD_TOKEN_SECRET not set, download tokens will not survive a restart")
+		s.tokenSecret = make([]byte, 32)
+		if _, err := rand.Read(s.tokenSecret); err != nil {
+			logger.Panicf("unable to generate download token secret: %s", err)
+		}
 	}
 
 	return s
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pavithrankb/weTransfer/internal/repository"
+	"github.com/pavithrankb/weTransfer/internal/storage"
+)
+
+const testBucket = "test-bucket"
+
+// testServer runs the API on an in-memory repository, with a LocalStore serving the
+// presigned URLs from the same listener, and calls it as one API key owner.
+type testServer struct {
+	t     *testing.T
+	s     *Server
+	store *storage.LocalStore
+	url   string
+	key   string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	t.Setenv("S3_BUCKET", testBucket)
+	t.Setenv("ADMIN_TOKEN", "test-admin")
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	store, err := storage.NewLocalStore(t.TempDir(), srv.URL, []byte("test-secret"))
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	s := NewServer(repository.NewMemory(), store, nil, "", nil, log.New(io.Discard, "", 0), false)
+	mux.Handle("/", s.RegisterRoutes())
+
+	ts := &testServer{t: t, s: s, store: store, url: srv.URL, key: "test-admin"}
+	var k struct {
+		Key string `json:"key"`
+	}
+	if code := ts.do(http.MethodPost, "/api-keys", map[string]string{"owner_id": "alice"}, &k); code != http.StatusCreated {
+		t.Fatalf("POST /api-keys = %d", code)
+	}
+	ts.key = k.Key
+	return ts
+}
+
+// do sends body as JSON to path (relative to the server, or an absolute URL) and decodes
+// the response into out, if given. It returns the status code.
+func (ts *testServer) do(method, path string, body, out interface{}) int {
+	ts.t.Helper()
+	var rd io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			ts.t.Fatalf("marshal body: %v", err)
+		}
+		rd = bytes.NewReader(b)
+	}
+	url := path
+	if strings.HasPrefix(path, "/") {
+		url = ts.url + path
+	}
+	req, err := http.NewRequest(method, url, rd)
+	if err != nil {
+		ts.t.Fatalf("new request: %v", err)
+	}
+	if ts.key != "" {
+		req.Header.Set("Authorization", "Bearer "+ts.key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		ts.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		ts.t.Fatalf("%s %s: read body: %v", method, path, err)
+	}
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			ts.t.Fatalf("%s %s: decode %q: %v", method, path, data, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// createTransfer creates a transfer expiring in an hour and returns its ID and share token.
+func (ts *testServer) createTransfer() (string, string) {
+	ts.t.Helper()
+	return ts.createProtectedTransfer("")
+}
+
+// createProtectedTransfer is createTransfer for a transfer protected by password, unless
+// password is empty.
+func (ts *testServer) createProtectedTransfer(password string) (string, string) {
+	ts.t.Helper()
+	var tr struct {
+		ID         string `json:"id"`
+		ShareToken string `json:"share_token"`
+	}
+	body := map[string]interface{}{"expires_at": time.Now().Add(time.Hour), "max_downloads": 3}
+	if password != "" {
+		body["password"] = password
+	}
+	if code := ts.do(http.MethodPost, "/transfers", body, &tr); code != http.StatusCreated {
+		ts.t.Fatalf("POST /transfers = %d", code)
+	}
+	return tr.ID, tr.ShareToken
+}
+
+// requestUpload adds filename to the manifest of transfer id and returns its upload URL.
+func (ts *testServer) requestUpload(id, filename, content string) uploadFileURL {
+	ts.t.Helper()
+	var up uploadURLResponse
+	req := map[string]interface{}{"filename": filename, "content_type": "text/plain", "size": len(content)}
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/upload-url", req, &up); code != http.StatusOK {
+		ts.t.Fatalf("POST upload-url = %d", code)
+	}
+	if len(up.Files) != 1 {
+		ts.t.Fatalf("upload-url returned %d files, want 1", len(up.Files))
+	}
+	return up.Files[0]
+}
+
+// upload requests an upload URL for filename and PUTs content to it.
+func (ts *testServer) upload(id, filename, content string) uploadFileURL {
+	ts.t.Helper()
+	f := ts.requestUpload(id, filename, content)
+	if code, _ := ts.put(f.UploadURL, "text/plain", content, f.Headers); code != http.StatusOK {
+		ts.t.Fatalf("PUT upload = %d", code)
+	}
+	return f
+}
+
+// put sends body to a presigned URL with the given content type (if any) and extra
+// headers, and returns the status code and response headers.
+func (ts *testServer) put(url, contentType, body string, header map[string]string) (int, http.Header) {
+	ts.t.Helper()
+	req, err := http.NewRequest(http.MethodPut, url, strings.NewReader(body))
+	if err != nil {
+		ts.t.Fatalf("new request: %v", err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		ts.t.Fatalf("PUT: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode, resp.Header
+}
+
+// status returns the stored status of transfer id.
+func (ts *testServer) status(id string) repository.Status {
+	ts.t.Helper()
+	tr, err := ts.s.repo.Get(ts.t.Context(), id)
+	if err != nil {
+		ts.t.Fatalf("Get(%s): %v", id, err)
+	}
+	return tr.Status
+}
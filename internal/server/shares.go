@@ -0,0 +1,311 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pavithrankb/weTransfer/internal/repository"
+	"github.com/pavithrankb/weTransfer/internal/storage"
+)
+
+const (
+	shareBatchSize          = 20
+	shareLease              = time.Minute
+	defaultShareMaxAttempts = 8
+)
+
+func shareMaxAttempts() int {
+	if v, err := strconv.Atoi(os.Getenv("SHARE_MAX_ATTEMPTS")); err == nil && v > 0 {
+		return v
+	}
+	return defaultShareMaxAttempts
+}
+
+// errSharePermanent marks a share that can never be sent, so it is not retried.
+type errSharePermanent struct{ reason string }
+
+func (e errSharePermanent) Error() string { return e.reason }
+
+// RunShareRelay publishes the pending shares of the outbox to SNS. A share whose publish
+// fails is retried with exponential backoff until SHARE_MAX_ATTEMPTS, then marked failed.
+func (s *Server) RunShareRelay() {
+	if s.publisher == nil || s.shareTopic == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	for {
+		shares, err := s.repo.ClaimShares(ctx, shareBatchSize, shareLease)
+		if err != nil {
+			s.logger.Printf("share-relay: failed to claim shares: %v", err)
+			return
+		}
+
+		var wg sync.WaitGroup
+		for _, sh := range shares {
+			wg.Add(1)
+			go func(sh repository.Share) {
+				defer wg.Done()
+				s.relayShare(ctx, s.shareTopic, sh)
+			}(sh)
+		}
+		wg.Wait()
+
+		if len(shares) < shareBatchSize {
+			return
+		}
+	}
+}
+
+func (s *Server) relayShare(ctx context.Context, topicARN string, sh repository.Share) {
+	err := s.publishShare(ctx, topicARN, sh)
+	if err == nil {
+		if err := s.repo.MarkShareSent(ctx, sh.ID); err != nil {
+			// the message is out; a retry would email the recipients twice
+			s.logger.Printf("share-relay: failed to mark share sent share_id=%s: %v", sh.ID, err)
+		}
+		s.logger.Printf("share-relay: published id=%s share_id=%s emails=%v", sh.TransferID, sh.ID, sh.Emails)
+		return
+	}
+
+	attempt := sh.Attempts + 1
+	var next *time.Time
+	var permanent errSharePermanent
+	if !errors.As(err, &permanent) && attempt < shareMaxAttempts() {
+		t := time.Now().UTC().Add(repository.RetryBackoff(attempt))
+		next = &t
+	}
+	if rerr := s.repo.RecordShareFailure(ctx, sh.ID, err.Error(), next); rerr != nil {
+		s.logger.Printf("share-relay: failed to record failure share_id=%s: %v", sh.ID, rerr)
+	}
+	if next != nil {
+		s.logger.Printf("share-relay: attempt %d failed share_id=%s: %v (retry at %s)", attempt, sh.ID, err, next.Format(time.RFC3339))
+	} else {
+		s.logger.Printf("share-relay: giving up share_id=%s after %d attempts: %v", sh.ID, attempt, err)
+	}
+}
+
+// publishShare builds the share message and publishes it. The message only carries API
+// links, so the relay and the email worker need no S3 access and nothing expires before
+// the transfer does.
+func (s *Server) publishShare(ctx context.Context, topicARN string, sh repository.Share) error {
+	t, err := s.repo.Get(ctx, sh.TransferID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return errSharePermanent{"transfer deleted"}
+		}
+		return err
+	}
+	if t.Status != repository.StatusReady || isExpired(t.ExpiresAt) {
+		return errSharePermanent{"transfer no longer available"}
+	}
+
+	files, err := s.repo.ListFiles(ctx, sh.TransferID)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errSharePermanent{"transfer has no files"}
+	}
+
+	// every file gets a stable link that presigns on click, so the email works for as
+	// long as the transfer does; transfers created before share tokens only have the
+	// recipients' personal links
+	sharedFiles := make([]storage.SharedFile, 0, len(files))
+	for _, f := range files {
+		size := int64(0)
+		if f.FileSize != nil {
+			size = *f.FileSize
+		}
+		sf := storage.SharedFile{ID: f.ID, Filename: f.Filename, FileSize: size}
+		if t.ShareToken != nil {
+			sf.DownloadURL = sharedDownloadURL(*t.ShareToken, f.ID)
+		}
+		sharedFiles = append(sharedFiles, sf)
+	}
+
+	fileSizeVal := int64(0)
+	if t.FileSize != nil {
+		fileSizeVal = *t.FileSize
+	}
+
+	msg := storage.ShareDownloadMessage{
+		EventType:  "TRANSFER_SHARED",
+		TransferID: sh.TransferID,
+		ShareID:    sh.ID,
+		Emails:     sh.Emails,
+		ExpiresAt:  t.ExpiresAt.UTC().Format(time.RFC3339),
+		Filename:   displayName(files),
+		FileSize:   fileSizeVal,
+		Files:      sharedFiles,
+		Locale:     sh.Locale,
+	}
+	if t.OwnerID != nil {
+		msg.OwnerID = *t.OwnerID
+	}
+	if len(sharedFiles) == 1 && t.ShareToken != nil {
+		msg.DownloadURL = sharedDownloadURL(*t.ShareToken, "")
+	}
+	if t.PasswordHash != nil {
+		msg.PasswordProtected = true
+		if t.ShareToken != nil {
+			msg.UnlockURL = sharedUnlockURL(*t.ShareToken)
+		}
+	}
+
+	// personal links were written with the share; shares accepted before they existed
+	// fall back to the links above
+	recipients, err := s.repo.EnsureRecipients(ctx, sh.ID, sh.TransferID, sh.Emails)
+	if err != nil {
+		return err
+	}
+	for _, rc := range recipients {
+		if rc.Token == nil {
+			continue
+		}
+		if msg.Links == nil {
+			msg.Links = make(map[string]string, len(recipients))
+		}
+		msg.Links[rc.Email] = recipientLinkURL(*rc.Token)
+	}
+	if t.ShareToken == nil && len(msg.Links) < len(recipients) {
+		return errSharePermanent{"transfer has no share link"}
+	}
+
+	pubCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	return storage.PublishShareDownload(pubCtx, s.publisher, topicARN, msg)
+}
+
+type shareResponse struct {
+	ID            string     `json:"id"`
+	Emails        []string   `json:"emails"`
+	Locale        string     `json:"locale,omitempty"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `json:"next_attempt_at"`
+	LastError     *string    `json:"last_error"`
+	CreatedAt     time.Time  `json:"created_at"`
+	SentAt        *time.Time `json:"sent_at"`
+}
+
+// listSharesHandler reports every share-download request of a transfer and whether it was sent.
+// GET /transfers/{id}/shares
+func (s *Server) listSharesHandler(w http.ResponseWriter, r *http.Request, id string) {
+	shares, err := s.repo.ListShares(r.Context(), id)
+	if err != nil {
+		s.logger.Printf("shares: failed to list shares id=%s: %v", id, err)
+		http.Error(w, "failed to list shares", http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]shareResponse, 0, len(shares))
+	for _, sh := range shares {
+		item := shareResponse{
+			ID:        sh.ID,
+			Emails:    sh.Emails,
+			Locale:    sh.Locale,
+			Status:    sh.Status,
+			Attempts:  sh.Attempts,
+			LastError: sh.LastError,
+			CreatedAt: sh.CreatedAt,
+			SentAt:    sh.SentAt,
+		}
+		if sh.Status == repository.SharePending {
+			next := sh.NextAttemptAt
+			item.NextAttemptAt = &next
+		}
+		items = append(items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+}
+
+type recipientResponse struct {
+	ShareID       string     `json:"share_id"`
+	Email         string     `json:"email"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `json:"next_attempt_at"`
+	LastError     *string    `json:"last_error"`
+	CreatedAt     time.Time  `json:"created_at"`
+	SentAt        *time.Time `json:"sent_at"`
+	// downloads made through the recipient's personal link
+	DownloadCount    int        `json:"download_count"`
+	LastDownloadedAt *time.Time `json:"last_downloaded_at"`
+}
+
+// listRecipientsHandler reports which recipients of a transfer's shares got the email
+// and how often each downloaded through their personal link.
+// GET /transfers/{id}/recipients
+func (s *Server) listRecipientsHandler(w http.ResponseWriter, r *http.Request, id string) {
+	recipients, err := s.repo.ListRecipients(r.Context(), id)
+	if err != nil {
+		s.logger.Printf("recipients: failed to list recipients id=%s: %v", id, err)
+		http.Error(w, "failed to list recipients", http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]recipientResponse, 0, len(recipients))
+	for _, rc := range recipients {
+		item := recipientResponse{
+			ShareID:   rc.ShareID,
+			Email:     rc.Email,
+			Status:    rc.Status,
+			Attempts:  rc.Attempts,
+			LastError: rc.LastError,
+			CreatedAt: rc.CreatedAt,
+			SentAt:    rc.SentAt,
+			// filled in by the /s/{token} endpoint
+			DownloadCount:    rc.DownloadCount,
+			LastDownloadedAt: rc.LastDownloadedAt,
+		}
+		if rc.Status == repository.RecipientPending {
+			next := rc.NextAttemptAt
+			item.NextAttemptAt = &next
+		}
+		items = append(items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+}
+
+// localePattern accepts BCP 47-style tags such as "en", "de-CH" or "pt_BR".
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(?:[-_][A-Za-z0-9]{2,8})*$`)
+
+// preferredLocale returns the language with the highest weight in an Accept-Language
+// header, or "" if there is none.
+func preferredLocale(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" || !localePattern.MatchString(tag) {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+	return best
+}
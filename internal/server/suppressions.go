@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pavithrankb/weTransfer/internal/repository"
+)
+
+type suppressionResponse struct {
+	Email     string    `json:"email"`
+	Reason    string    `json:"reason"`
+	Detail    *string   `json:"detail"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type listSuppressionsResponse struct {
+	Items      []suppressionResponse `json:"items"`
+	Limit      int                   `json:"limit"`
+	Offset     int                   `json:"offset"`
+	TotalCount int                   `json:"total_count"`
+}
+
+// suppressionsRootHandler lists the email suppression list (admin only).
+// GET /suppressions?limit=&offset=
+func (s *Server) suppressionsRootHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	limitVal := 50
+	offsetVal := 0
+
+	if l := query.Get("limit"); l != "" {
+		var err error
+		limitVal, err = strconv.Atoi(l)
+		if err != nil || limitVal < 1 || limitVal > 100 {
+			http.Error(w, "invalid limit (1-100)", http.StatusBadRequest)
+			return
+		}
+	}
+	if o := query.Get("offset"); o != "" {
+		var err error
+		offsetVal, err = strconv.Atoi(o)
+		if err != nil || offsetVal < 0 {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+
+	list, totalCount, err := s.repo.ListSuppressions(r.Context(), limitVal, offsetVal)
+	if err != nil {
+		s.logger.Printf("suppressions: failed to list suppressions: %v", err)
+		http.Error(w, "failed to list suppressions", http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]suppressionResponse, 0, len(list))
+	for _, sp := range list {
+		items = append(items, suppressionResponse{
+			Email:     sp.Email,
+			Reason:    sp.Reason,
+			Detail:    sp.Detail,
+			CreatedAt: sp.CreatedAt,
+			UpdatedAt: sp.UpdatedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(listSuppressionsResponse{
+		Items:      items,
+		Limit:      limitVal,
+		Offset:     offsetVal,
+		TotalCount: totalCount,
+	})
+}
+
+// suppressionsSubHandler handles DELETE /suppressions/{email} (admin only), which lets
+// share emails go to the address again.
+func (s *Server) suppressionsSubHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", http.MethodDelete)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/suppressions/"))
+	if err != nil || email == "" || strings.Contains(email, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := s.repo.DeleteSuppression(r.Context(), email); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		s.logger.Printf("suppressions: failed to delete suppression email=%s: %v", email, err)
+		http.Error(w, "failed to delete suppression", http.StatusInternalServerError)
+		return
+	}
+
+	s.logger.Printf("suppression cleared email=%s", email)
+	w.WriteHeader(http.StatusNoContent)
+}
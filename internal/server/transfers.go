@@ -3,6 +3,8 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"os"
 	"strconv"
@@ -12,7 +14,7 @@ import (
 	"fmt"
 
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5"
+	"github.com/pavithrankb/weTransfer/internal/repository"
 	"github.com/pavithrankb/weTransfer/internal/storage"
 )
 
@@ -32,25 +34,46 @@ func (s *Server) transfersRootHandler(w http.ResponseWriter, r *http.Request) {
 type createTransferRequest struct {
 	ExpiresAt    time.Time `json:"expires_at"`
 	MaxDownloads *int      `json:"max_downloads"`
+	Password     *string   `json:"password"`
 }
 
 type createTransferResponse struct {
-	ID     string `json:"id"`
-	Status string `json:"status"`
+	ID         string            `json:"id"`
+	Status     repository.Status `json:"status"`
+	ShareToken string            `json:"share_token"`
 }
 
 type transferResponse struct {
-	ID            string     `json:"id"`
-	Status        string     `json:"status"`
-	ExpiresAt     time.Time  `json:"expires_at"`
-	DownloadCount int        `json:"download_count"`
-	MaxDownloads  int        `json:"max_downloads"`
-	ObjectKey     *string    `json:"-"`
-	CreatedAt     time.Time  `json:"created_at"`
-	Filename      *string    `json:"filename"`
-	FileType      *string    `json:"file_type"`
-	FileSize      *int64     `json:"file_size"`
-	UploadedAt    *time.Time `json:"uploaded_at"`
+	ID            string                 `json:"id"`
+	Status        repository.Status      `json:"status"`
+	ExpiresAt     time.Time              `json:"expires_at"`
+	DownloadCount int                    `json:"download_count"`
+	MaxDownloads  int                    `json:"max_downloads"`
+	Protected     bool                   `json:"password_protected"`
+	ShareToken    *string                `json:"share_token"`
+	CreatedAt     time.Time              `json:"created_at"`
+	Filename      *string                `json:"filename"`
+	FileType      *string                `json:"file_type"`
+	FileSize      *int64                 `json:"file_size"`
+	UploadedAt    *time.Time             `json:"uploaded_at"`
+	Files         []transferFileResponse `json:"files,omitempty"`
+}
+
+func newTransferResponse(t *repository.Transfer) transferResponse {
+	return transferResponse{
+		ID:            t.ID,
+		Status:        t.Status,
+		ExpiresAt:     t.ExpiresAt,
+		DownloadCount: t.DownloadCount,
+		MaxDownloads:  t.MaxDownloads,
+		Protected:     t.PasswordHash != nil,
+		ShareToken:    t.ShareToken,
+		CreatedAt:     t.CreatedAt,
+		Filename:      t.Filename,
+		FileType:      t.FileType,
+		FileSize:      t.FileSize,
+		UploadedAt:    t.UploadedAt,
+	}
 }
 
 type updateTransferRequest struct {
@@ -92,38 +115,61 @@ func (s *Server) createTransferHandler(w http.ResponseWriter, r *http.Request) {
 		maxDownloads = *req.MaxDownloads
 	}
 
+	var passwordHash *string
+	if req.Password != nil {
+		if err := validatePassword(*req.Password); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h, err := hashPassword(*req.Password)
+		if err != nil {
+			s.logger.Printf("failed to hash password: %v", err)
+			http.Error(w, "failed to hash password", http.StatusInternalServerError)
+			return
+		}
+		passwordHash = &h
+	}
+
 	id := uuid.New().String()
 
+	// recipients reach the transfer through /shared/{share_token} without an api key
+	shareToken, err := randomToken(24)
+	if err != nil {
+		s.logger.Printf("failed to generate share token: %v", err)
+		http.Error(w, "failed to create transfer", http.StatusInternalServerError)
+		return
+	}
+
+	var ownerID *string
+	if p := principalFrom(r.Context()); p.OwnerID != "" {
+		ownerID = &p.OwnerID
+	}
+
 	ctx := r.Context()
 	if s.debug {
 		s.logger.Printf("creating transfer id=%s expires_at=%s", id, req.ExpiresAt)
 	}
-	tx, err := s.db.Begin(ctx)
-	if err != nil {
-		s.logger.Printf("failed to start transaction: %v", err)
-		http.Error(w, "failed to start transaction", http.StatusInternalServerError)
-		return
-	}
-	defer tx.Rollback(ctx)
 
-	_, err = tx.Exec(ctx, `INSERT INTO transfers(id, expires_at, status, created_at, max_downloads) VALUES ($1, $2, $3, now(), $4)`, id, req.ExpiresAt, "INIT", maxDownloads)
-	if err != nil {
+	t := &repository.Transfer{
+		ID:           id,
+		OwnerID:      ownerID,
+		ShareToken:   &shareToken,
+		Status:       repository.StatusInit,
+		ExpiresAt:    req.ExpiresAt,
+		MaxDownloads: maxDownloads,
+		PasswordHash: passwordHash,
+	}
+	if err := s.repo.Create(ctx, t); err != nil {
 		s.logger.Printf("failed to insert transfer: %v", err)
 		http.Error(w, "failed to insert transfer", http.StatusInternalServerError)
 		return
 	}
 
-	if err := tx.Commit(ctx); err != nil {
-		s.logger.Printf("failed to commit transaction: %v", err)
-		http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
-		return
-	}
-
 	s.logger.Printf("transfer created id=%s", id)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	_ = json.NewEncoder(w).Encode(createTransferResponse{ID: id, Status: "INIT"})
+	_ = json.NewEncoder(w).Encode(createTransferResponse{ID: id, Status: repository.StatusInit, ShareToken: shareToken})
 }
 
 // transfersSubHandler handles dynamic subroutes under /transfers/
@@ -145,6 +191,10 @@ func (s *Server) transfersSubHandler(w http.ResponseWriter, r *http.Request) {
 		action = parts[1]
 	}
 
+	if !s.authorizeTransfer(w, r, id) {
+		return
+	}
+
 	if action == "download-url" {
 		if r.Method != http.MethodGet {
 			w.Header().Set("Allow", http.MethodGet)
@@ -172,6 +222,57 @@ func (s *Server) transfersSubHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
+	} else if action == "unlock" {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			s.logger.Printf("method not allowed for unlock: %s %s", r.Method, r.URL.Path)
+			return
+		}
+		s.unlockHandler(w, r, id)
+		return
+	} else if action == "events" {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			s.logger.Printf("method not allowed for events: %s %s", r.Method, r.URL.Path)
+			return
+		}
+		s.eventsHandler(w, r, id)
+		return
+	} else if action == "shares" {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			s.logger.Printf("method not allowed for shares: %s %s", r.Method, r.URL.Path)
+			return
+		}
+		s.listSharesHandler(w, r, id)
+		return
+	} else if action == "recipients" {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			s.logger.Printf("method not allowed for recipients: %s %s", r.Method, r.URL.Path)
+			return
+		}
+		s.listRecipientsHandler(w, r, id)
+		return
+	} else if action == "archive" {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			s.logger.Printf("method not allowed for archive: %s %s", r.Method, r.URL.Path)
+			return
+		}
+		s.archiveHandler(w, r, id)
+		return
+	} else if action == "multipart" || strings.HasPrefix(action, "multipart/") {
+		s.multipartRouter(w, r, id, strings.TrimPrefix(action, "multipart"))
+		return
+	} else if action == "tus" || strings.HasPrefix(action, "tus/") {
+		s.tusRouter(w, r, id, strings.TrimPrefix(action, "tus"))
+		return
 	} else if action == "share-download" {
 		if r.Method != http.MethodPost {
 			w.Header().Set("Allow", http.MethodPost)
@@ -210,20 +311,58 @@ func (s *Server) transfersSubHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// Upload modes of upload-url.
+const (
+	uploadModePut  = "put"
+	uploadModePost = "post"
+)
+
 type uploadURLRequest struct {
+	// Mode is "put" (default) for presigned PUT URLs or "post" for presigned form uploads.
+	Mode           string              `json:"mode"`
+	Filename       string              `json:"filename"`
+	ContentType    string              `json:"content_type"`
+	ChecksumSHA256 string              `json:"checksum_sha256"`
+	ChecksumCRC32C string              `json:"checksum_crc32c"`
+	Size           *int64              `json:"size"`
+	Files          []uploadFileRequest `json:"files"`
+}
+
+type uploadFileRequest struct {
 	Filename    string `json:"filename"`
 	ContentType string `json:"content_type"`
+	// base64 checksums of the file body; the presigned PUT only accepts a matching body
+	ChecksumSHA256 string `json:"checksum_sha256"`
+	ChecksumCRC32C string `json:"checksum_crc32c"`
+	// Size in bytes, signed into the upload so storage refuses any other body. Required
+	// when MAX_UPLOAD_SIZE is set.
+	Size *int64 `json:"size"`
 }
 
 type uploadURLResponse struct {
-	UploadURL string `json:"upload_url"`
+	UploadURL string          `json:"upload_url,omitempty"`
+	ObjectKey string          `json:"object_key,omitempty"`
+	Files     []uploadFileURL `json:"files"`
+}
+
+type uploadFileURL struct {
+	ID        string `json:"id"`
+	Filename  string `json:"filename"`
 	ObjectKey string `json:"object_key"`
+	UploadURL string `json:"upload_url"`
+	// Headers the PUT must send with exactly these values
+	Headers map[string]string `json:"headers,omitempty"`
+	// Fields the POST form must carry ahead of the file
+	Fields map[string]string `json:"fields,omitempty"`
 }
 
 type downloadURLResponse struct {
 	DownloadURL string `json:"download_url"`
 }
 
+// uploadURLHandler adds files to the transfer manifest and returns one presigned PUT URL per
+// file, or with mode "post" one presigned form upload per file. Re-requesting a filename that
+// is already in the manifest issues a fresh URL for the same object.
 func (s *Server) uploadURLHandler(w http.ResponseWriter, r *http.Request, id string) {
 	var req uploadURLRequest
 	dec := json.NewDecoder(r.Body)
@@ -234,47 +373,106 @@ func (s *Server) uploadURLHandler(w http.ResponseWriter, r *http.Request, id str
 		return
 	}
 
-	// filename validation
-	if strings.TrimSpace(req.Filename) == "" || strings.Contains(req.Filename, "/") || strings.Contains(req.Filename, "..") {
-		s.logger.Printf("invalid filename: %q", req.Filename)
-		http.Error(w, "invalid filename", http.StatusBadRequest)
+	// the single filename/content_type form is kept for existing clients
+	legacy := len(req.Files) == 0
+	if legacy {
+		req.Files = []uploadFileRequest{{Filename: req.Filename, ContentType: req.ContentType, ChecksumSHA256: req.ChecksumSHA256, ChecksumCRC32C: req.ChecksumCRC32C, Size: req.Size}}
+	} else if req.Filename != "" || req.ContentType != "" || req.ChecksumSHA256 != "" || req.ChecksumCRC32C != "" || req.Size != nil {
+		http.Error(w, "use either filename/content_type or files, not both", http.StatusBadRequest)
 		return
 	}
 
-	ctx := r.Context()
+	if len(req.Files) > maxFilesPerTransfer {
+		http.Error(w, fmt.Sprintf("at most %d files per transfer", maxFilesPerTransfer), http.StatusBadRequest)
+		return
+	}
 
-	var status string
-	var expiresAt time.Time
-	err := s.db.QueryRow(ctx, `SELECT status, expires_at FROM transfers WHERE id=$1`, id).Scan(&status, &expiresAt)
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			s.logger.Printf("transfer not found id=%s", id)
-			http.Error(w, "transfer not found", http.StatusNotFound)
+	var poster storage.PostStore
+	switch req.Mode {
+	case "", uploadModePut:
+		req.Mode = uploadModePut
+	case uploadModePost:
+		var ok bool
+		if poster, ok = s.store.(storage.PostStore); !ok {
+			http.Error(w, "form uploads are not supported by this storage backend", http.StatusNotImplemented)
 			return
 		}
-		s.logger.Printf("failed to fetch transfer id=%s: %v", id, err)
-		http.Error(w, "failed to fetch transfer", http.StatusInternalServerError)
+	default:
+		http.Error(w, fmt.Sprintf("unknown mode %q", req.Mode), http.StatusBadRequest)
 		return
 	}
 
-	if isExpired(expiresAt) {
-		if status != "EXPIRED" {
-			_, _ = s.db.Exec(ctx, `UPDATE transfers SET status='EXPIRED' WHERE id=$1`, id)
+	// filename validation
+	seen := make(map[string]bool, len(req.Files))
+	for _, f := range req.Files {
+		if !validFilename(f.Filename) {
+			s.logger.Printf("invalid filename: %q", f.Filename)
+			http.Error(w, "invalid filename", http.StatusBadRequest)
+			return
 		}
-		s.logger.Printf("transfer expired id=%s expires_at=%s", id, expiresAt)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusGone)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "transfer_expired"})
+		if seen[f.Filename] {
+			http.Error(w, fmt.Sprintf("duplicate filename %q", f.Filename), http.StatusBadRequest)
+			return
+		}
+		seen[f.Filename] = true
+		if f.Size != nil && *f.Size < 0 {
+			http.Error(w, fmt.Sprintf("%q: size must be >= 0", f.Filename), http.StatusBadRequest)
+			return
+		}
+		if req.Mode == uploadModePost {
+			// a form upload can't carry the checksum headers a declared checksum is enforced with
+			if checksumRequired() {
+				http.Error(w, "mode post is unavailable: checksum_sha256 is required", http.StatusBadRequest)
+				return
+			}
+			if f.ChecksumSHA256 != "" || f.ChecksumCRC32C != "" {
+				http.Error(w, "checksums are not supported with mode post", http.StatusBadRequest)
+				return
+			}
+			continue
+		}
+		if err := validChecksums(f.ChecksumSHA256, f.ChecksumCRC32C); err != nil {
+			http.Error(w, fmt.Sprintf("%q: %v", f.Filename, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx := r.Context()
+
+	t := s.loadTransfer(w, r, id, "upload-url")
+	if t == nil {
 		return
 	}
 
-	if status != "INIT" {
-		s.logger.Printf("transfer not in INIT state id=%s status=%s", id, status)
+	if t.Status != repository.StatusInit {
+		s.logger.Printf("transfer not in INIT state id=%s status=%s", id, t.Status)
 		http.Error(w, "transfer not in INIT state", http.StatusBadRequest)
 		return
 	}
 
-	objectKey := fmt.Sprintf("uploads/%s/%s", id, req.Filename)
+	policy := s.uploadPolicy(t)
+	for _, f := range req.Files {
+		if err := policy.checkContentType(f.ContentType); err != nil {
+			s.logger.Printf("upload-url: rejected by upload policy id=%s filename=%q: %v", id, f.Filename, err)
+			http.Error(w, fmt.Sprintf("%q: %v", f.Filename, err), http.StatusBadRequest)
+			return
+		}
+		// with a limit the size is signed into the upload, so nothing bigger can be stored
+		// even if the client never calls complete. Storage signs no length for size 0, so an
+		// empty declared size would lift the limit.
+		if f.Size == nil || *f.Size < 1 {
+			if policy.MaxFileSize > 0 {
+				http.Error(w, fmt.Sprintf("%q: size must be >= 1", f.Filename), http.StatusBadRequest)
+				return
+			}
+			continue
+		}
+		if err := policy.checkSize(*f.Size); err != nil {
+			s.logger.Printf("upload-url: rejected by upload policy id=%s filename=%q: %v", id, f.Filename, err)
+			http.Error(w, fmt.Sprintf("%q: %v", f.Filename, err), http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
 
 	bucket := os.Getenv("S3_BUCKET")
 	if bucket == "" {
@@ -283,88 +481,165 @@ func (s *Server) uploadURLHandler(w http.ResponseWriter, r *http.Request, id str
 		return
 	}
 
-	uploadURL, err := s.s3.PresignPutURL(ctx, bucket, objectKey, req.ContentType, 5*time.Minute)
+	existing, err := s.repo.ListFiles(ctx, id)
 	if err != nil {
-		s.logger.Printf("failed to presign upload url id=%s key=%s: %v", id, objectKey, err)
-		http.Error(w, "failed to presign upload url", http.StatusInternalServerError)
+		s.logger.Printf("failed to load files id=%s: %v", id, err)
+		http.Error(w, "failed to fetch transfer", http.StatusInternalServerError)
+		return
+	}
+	byName := make(map[string]repository.File, len(existing))
+	newCount := 0
+	for _, f := range existing {
+		byName[f.Filename] = f
+	}
+	for _, f := range req.Files {
+		if _, ok := byName[f.Filename]; !ok {
+			newCount++
+		}
+	}
+	if len(existing)+newCount > maxFilesPerTransfer {
+		http.Error(w, fmt.Sprintf("at most %d files per transfer", maxFilesPerTransfer), http.StatusBadRequest)
 		return
 	}
 
-	// persist object_key
-	_, err = s.db.Exec(ctx, `UPDATE transfers SET object_key=$1 WHERE id=$2`, objectKey, id)
-	if err != nil {
-		s.logger.Printf("failed to update transfer id=%s: %v", id, err)
+	resp := uploadURLResponse{Files: make([]uploadFileURL, 0, len(req.Files))}
+	rows := make([]repository.File, 0, len(req.Files))
+	var abandoned []repository.File
+	for _, f := range req.Files {
+		objectKey := objectKeyFor(id, f.Filename)
+
+		out := uploadFileURL{Filename: f.Filename, ObjectKey: objectKey}
+		sums := storage.Checksums{SHA256: f.ChecksumSHA256, CRC32C: f.ChecksumCRC32C}
+		if poster != nil {
+			// storage enforces the declared size, or else the policy's limit, while the form is posted
+			cond := storage.PostConditions{ContentType: f.ContentType, MaxSize: policy.MaxFileSize}
+			// a MaxSize of 0 would mean no limit at all
+			if f.Size != nil && *f.Size > 0 {
+				cond.MinSize, cond.MaxSize = *f.Size, *f.Size
+			}
+			post, err := poster.PresignPost(ctx, bucket, objectKey, cond, 5*time.Minute)
+			if err != nil {
+				s.logger.Printf("failed to presign upload form id=%s key=%s: %v", id, objectKey, err)
+				http.Error(w, "failed to presign upload url", http.StatusInternalServerError)
+				return
+			}
+			out.UploadURL, out.Fields = post.URL, post.Fields
+		} else {
+			var size int64
+			if f.Size != nil {
+				size = *f.Size
+			}
+			uploadURL, err := s.store.PresignPutURL(ctx, bucket, objectKey, f.ContentType, size, sums, 5*time.Minute)
+			if err != nil {
+				s.logger.Printf("failed to presign upload url id=%s key=%s: %v", id, objectKey, err)
+				http.Error(w, "failed to presign upload url", http.StatusInternalServerError)
+				return
+			}
+			out.UploadURL = uploadURL
+			out.Headers = map[string]string{"Content-Type": f.ContentType}
+			if size > 0 {
+				out.Headers["Content-Length"] = strconv.FormatInt(size, 10)
+			}
+			if name, value := sums.Header(); name != "" {
+				out.Headers[name] = value
+			}
+		}
+
+		// switching to a single upload abandons any multipart upload started earlier
+		if prev, ok := byName[f.Filename]; ok && prev.UploadID != nil {
+			abandoned = append(abandoned, prev)
+		}
+
+		rows = append(rows, repository.File{
+			Filename: f.Filename, ContentType: f.ContentType, ObjectKey: objectKey, FileSize: f.Size,
+			ChecksumSHA256: optionalString(f.ChecksumSHA256), ChecksumCRC32C: optionalString(f.ChecksumCRC32C),
+		})
+		resp.Files = append(resp.Files, out)
+	}
+
+	if err := s.repo.PutFiles(ctx, id, rows); err != nil {
+		s.logger.Printf("failed to upsert files id=%s: %v", id, err)
 		http.Error(w, "failed to update transfer", http.StatusInternalServerError)
 		return
 	}
+	for i := range rows {
+		resp.Files[i].ID = rows[i].ID
+	}
 
-	s.logger.Printf("presigned upload url generated id=%s key=%s", id, objectKey)
+	for _, f := range abandoned {
+		s.abortMultipart(ctx, bucket, f)
+	}
+
+	if legacy {
+		resp.UploadURL = resp.Files[0].UploadURL
+		resp.ObjectKey = resp.Files[0].ObjectKey
+	}
+
+	s.logger.Printf("presigned upload urls generated id=%s files=%d mode=%s", id, len(resp.Files), req.Mode)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(uploadURLResponse{UploadURL: uploadURL, ObjectKey: objectKey})
+	_ = json.NewEncoder(w).Encode(resp)
 }
 
-// downloadURLHandler validates a READY transfer and returns a short-lived presigned GET URL.
+// downloadURLHandler validates a READY transfer and returns a short-lived presigned GET URL
+// for one of its files (?file_id=, optional when the transfer has a single file).
 func (s *Server) downloadURLHandler(w http.ResponseWriter, r *http.Request, id string) {
 	ctx := r.Context()
 
-	var status string
-	var expiresAt time.Time
-	var objectKey *string
-
-	// fetch status, expires_at, object_key
-	err := s.db.QueryRow(ctx, `SELECT status, expires_at, object_key FROM transfers WHERE id=$1`, id).Scan(&status, &expiresAt, &objectKey)
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			s.logger.Printf("download: transfer not found id=%s", id)
-			http.Error(w, "transfer not found", http.StatusNotFound)
-			return
-		}
-		s.logger.Printf("download: failed to fetch transfer id=%s: %v", id, err)
-		http.Error(w, "failed to fetch transfer", http.StatusInternalServerError)
+	t := s.loadTransfer(w, r, id, "download")
+	if t == nil {
 		return
 	}
 
-	if isExpired(expiresAt) {
-		if status != "EXPIRED" {
-			_, _ = s.db.Exec(ctx, `UPDATE transfers SET status='EXPIRED' WHERE id=$1`, id)
-		}
-		s.logger.Printf("download: transfer expired id=%s expires_at=%s", id, expiresAt)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusGone)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "transfer_expired"})
+	if !s.requireReady(w, t, "download") {
 		return
 	}
 
-	if status != "READY" {
-		s.logger.Printf("download: transfer not READY id=%s status=%s", id, status)
-		http.Error(w, "transfer not ready", http.StatusBadRequest)
+	if !s.requireDownloadToken(w, r, id, t.PasswordHash) {
 		return
 	}
 
-	if objectKey == nil || strings.TrimSpace(*objectKey) == "" {
-		s.logger.Printf("download: missing object_key id=%s", id)
+	files, err := s.repo.ListFiles(ctx, id)
+	if err != nil {
+		s.logger.Printf("download: failed to load files id=%s: %v", id, err)
+		http.Error(w, "failed to fetch transfer", http.StatusInternalServerError)
+		return
+	}
+	if len(files) == 0 {
+		s.logger.Printf("download: no files id=%s", id)
 		http.Error(w, "object not available", http.StatusBadRequest)
 		return
 	}
+	file, err := findFile(files, r.URL.Query().Get("file_id"))
+	if err != nil {
+		s.logger.Printf("download: %v id=%s", err, id)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	// atomic increment download_count
-	// we enforce max_downloads here by conditioning the update
-	tag, err := s.db.Exec(ctx, `UPDATE transfers SET download_count = download_count + 1 WHERE id=$1 AND download_count < max_downloads`, id)
+	// atomic increment download_count; the repository enforces max_downloads
+	count, err := s.repo.IncrementDownload(ctx, id, file.Filename)
 	if err != nil {
+		if errors.Is(err, repository.ErrLimitReached) {
+			s.logger.Printf("download: limit reached id=%s", id)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusGone)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "transfer_limit_reached"})
+			return
+		}
+		if errors.Is(err, repository.ErrConflict) {
+			// expired or deleted since it was loaded
+			s.logger.Printf("download: transfer no longer READY id=%s", id)
+			s.writeTransitionError(w, err)
+			return
+		}
 		s.logger.Printf("download: failed to increment count id=%s: %v", id, err)
 		http.Error(w, "failed to update transfer", http.StatusInternalServerError)
 		return
 	}
 
-	if tag.RowsAffected() == 0 {
-		s.logger.Printf("download: limit reached id=%s", id)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusGone)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "transfer_limit_reached"})
-		return
-	}
+	s.notifyDownload(ctx, t, count)
 
 	bucket := os.Getenv("S3_BUCKET")
 	if bucket == "" {
@@ -383,14 +658,14 @@ func (s *Server) downloadURLHandler(w http.ResponseWriter, r *http.Request, id s
 		}
 	}
 
-	downloadURL, err := s.s3.PresignGetURL(ctx, bucket, *objectKey, expiryDuration)
+	downloadURL, err := s.store.PresignGetURL(ctx, bucket, file.ObjectKey, expiryDuration)
 	if err != nil {
-		s.logger.Printf("download: failed to presign get url id=%s key=%s: %v", id, *objectKey, err)
+		s.logger.Printf("download: failed to presign get url id=%s key=%s: %v", id, file.ObjectKey, err)
 		http.Error(w, "failed to presign download url", http.StatusInternalServerError)
 		return
 	}
 
-	s.logger.Printf("download url generated id=%s key=%s expiry=%s", id, *objectKey, expiryDuration)
+	s.logger.Printf("download url generated id=%s key=%s expiry=%s", id, file.ObjectKey, expiryDuration)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -399,23 +674,24 @@ func (s *Server) downloadURLHandler(w http.ResponseWriter, r *http.Request, id s
 
 type shareDownloadRequest struct {
 	Emails []string `json:"emails"`
+	// Locale of the email, e.g. "de"; defaults to the request's Accept-Language
+	Locale string `json:"locale"`
 }
 
-// shareDownloadHandler shares the download link via email by publishing to SNS
+// shareDownloadHandler queues an email with the download links; RunShareRelay publishes it to SNS
 // POST /transfers/{id}/share-download
 func (s *Server) shareDownloadHandler(w http.ResponseWriter, r *http.Request, id string) {
 	ctx := r.Context()
 
-	// Check if SNS is configured
-	if s.sns == nil {
-		s.logger.Printf("share-download: SNS not configured")
+	// Check if a message broker is configured
+	if s.publisher == nil {
+		s.logger.Printf("share-download: message broker not configured")
 		http.Error(w, "email sharing is not configured", http.StatusServiceUnavailable)
 		return
 	}
 
-	snsTopicARN := os.Getenv("SNS_TOPIC_ARN")
-	if snsTopicARN == "" {
-		s.logger.Printf("share-download: SNS_TOPIC_ARN not set")
+	if s.shareTopic == "" {
+		s.logger.Printf("share-download: share topic not configured")
 		http.Error(w, "email sharing is not configured", http.StatusServiceUnavailable)
 		return
 	}
@@ -444,211 +720,487 @@ func (s *Server) shareDownloadHandler(w http.ResponseWriter, r *http.Request, id
 		}
 	}
 
-	// Fetch transfer details
-	var status string
-	var expiresAt time.Time
-	var objectKey *string
-	var filename *string
-	var fileSize *int64
-
-	err := s.db.QueryRow(ctx, `
-		SELECT status, expires_at, object_key, filename, file_size 
-		FROM transfers WHERE id=$1`, id).
-		Scan(&status, &expiresAt, &objectKey, &filename, &fileSize)
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			s.logger.Printf("share-download: transfer not found id=%s", id)
-			http.Error(w, "transfer not found", http.StatusNotFound)
-			return
-		}
-		s.logger.Printf("share-download: failed to fetch transfer id=%s: %v", id, err)
-		http.Error(w, "failed to fetch transfer", http.StatusInternalServerError)
-		return
+	locale := req.Locale
+	if locale == "" {
+		locale = preferredLocale(r.Header.Get("Accept-Language"))
 	}
-
-	// Validate transfer state
-	if isExpired(expiresAt) {
-		if status != "EXPIRED" {
-			_, _ = s.db.Exec(ctx, `UPDATE transfers SET status='EXPIRED' WHERE id=$1`, id)
-		}
-		s.logger.Printf("share-download: transfer expired id=%s", id)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusGone)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "transfer_expired"})
+	if locale != "" && !localePattern.MatchString(locale) {
+		http.Error(w, "invalid locale", http.StatusBadRequest)
 		return
 	}
 
-	if status != "READY" {
-		s.logger.Printf("share-download: transfer not READY id=%s status=%s", id, status)
-		http.Error(w, "transfer not ready", http.StatusBadRequest)
+	// Fetch transfer details and validate state
+	t := s.loadTransfer(w, r, id, "share-download")
+	if t == nil {
 		return
 	}
 
-	if objectKey == nil || strings.TrimSpace(*objectKey) == "" {
-		s.logger.Printf("share-download: missing object_key id=%s", id)
-		http.Error(w, "object not available", http.StatusBadRequest)
+	if !s.requireReady(w, t, "share-download") {
 		return
 	}
 
-	bucket := os.Getenv("S3_BUCKET")
-	if bucket == "" {
-		s.logger.Printf("share-download: s3 bucket not configured")
-		http.Error(w, "s3 bucket not configured", http.StatusInternalServerError)
+	if !s.requireDownloadToken(w, r, id, t.PasswordHash) {
 		return
 	}
 
-	// Generate a fresh presigned download URL (1 hour expiry)
-	expiryDuration := 60 * time.Minute
-	downloadURL, err := s.s3.PresignGetURL(ctx, bucket, *objectKey, expiryDuration)
+	files, err := s.repo.ListFiles(ctx, id)
 	if err != nil {
-		s.logger.Printf("share-download: failed to presign get url id=%s key=%s: %v", id, *objectKey, err)
-		http.Error(w, "failed to generate download url", http.StatusInternalServerError)
+		s.logger.Printf("share-download: failed to load files id=%s: %v", id, err)
+		http.Error(w, "failed to fetch transfer", http.StatusInternalServerError)
+		return
+	}
+	if len(files) == 0 {
+		s.logger.Printf("share-download: no files id=%s", id)
+		http.Error(w, "object not available", http.StatusBadRequest)
 		return
 	}
 
-	urlExpiresAt := time.Now().UTC().Add(expiryDuration)
-
-	// Prepare file info
-	filenameStr := "Unknown"
-	if filename != nil {
-		filenameStr = *filename
+	// addresses that bounced or complained would hurt the sender reputation
+	suppressed, err := s.repo.Suppressed(ctx, req.Emails)
+	if err != nil {
+		s.logger.Printf("share-download: failed to check suppressions id=%s: %v", id, err)
+		http.Error(w, "failed to share transfer", http.StatusInternalServerError)
+		return
 	}
-	fileSizeVal := int64(0)
-	if fileSize != nil {
-		fileSizeVal = *fileSize
+	if len(suppressed) > 0 {
+		s.logger.Printf("share-download: suppressed recipients id=%s emails=%v", id, suppressed)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": "recipients_suppressed", "emails": suppressed})
+		return
 	}
 
-	// Publish to SNS
-	msg := storage.ShareDownloadMessage{
-		EventType:   "TRANSFER_SHARED",
-		TransferID:  id,
-		Emails:      req.Emails,
-		DownloadURL: downloadURL,
-		ExpiresAt:   urlExpiresAt.Format(time.RFC3339),
-		Filename:    filenameStr,
-		FileSize:    fileSizeVal,
+	// every recipient gets a personal link, so downloads can be told apart
+	tokens := make(map[string]string, len(req.Emails))
+	for _, email := range req.Emails {
+		tok, err := randomToken(24)
+		if err != nil {
+			s.logger.Printf("share-download: failed to generate link token id=%s: %v", id, err)
+			http.Error(w, "failed to share transfer", http.StatusInternalServerError)
+			return
+		}
+		tokens[email] = tok
 	}
 
-	go func() {
-		pubCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-		if err := s.sns.PublishShareDownload(pubCtx, snsTopicARN, msg); err != nil {
-			s.logger.Printf("share-download: failed to publish to SNS id=%s: %v", id, err)
-		} else {
-			s.logger.Printf("share-download: published to SNS id=%s emails=%v", id, req.Emails)
-		}
-	}()
+	// the relay builds and publishes the message; the outbox row makes the share survive
+	// an SNS outage or a restart
+	sh := repository.Share{ID: uuid.New().String(), TransferID: id, Emails: req.Emails, Locale: locale, Tokens: tokens}
+	if err := s.repo.CreateShare(ctx, &sh); err != nil {
+		s.logger.Printf("share-download: failed to write outbox id=%s: %v", id, err)
+		http.Error(w, "failed to share transfer", http.StatusInternalServerError)
+		return
+	}
 
-	s.logger.Printf("share-download: accepted id=%s emails=%v", id, req.Emails)
+	s.logger.Printf("share-download: accepted id=%s share_id=%s emails=%v", id, sh.ID, req.Emails)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusAccepted)
-	_ = json.NewEncoder(w).Encode(map[string]string{"status": "accepted"})
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": "accepted", "share_id": sh.ID})
 }
 
-// completeHandler marks a transfer as READY after validating state and expiry.
+// completeHandler marks a transfer as READY after validating state and expiry
+// and confirming that every file in the manifest exists in storage. With a malware
+// scanner configured the transfer goes to SCANNING instead and RunScans decides.
 func (s *Server) completeHandler(w http.ResponseWriter, r *http.Request, id string) {
 	ctx := r.Context()
 
-	var status string
-	var expiresAt time.Time
-	var objectKey *string
-	err := s.db.QueryRow(ctx, `SELECT status, expires_at, object_key FROM transfers WHERE id=$1`, id).Scan(&status, &expiresAt, &objectKey)
+	var req completeRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil && err != io.EOF {
+		s.logger.Printf("complete: invalid body id=%s: %v", id, err)
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	parts := make(map[string][]storage.Part, len(req.Files))
+	for _, f := range req.Files {
+		parts[f.FileID] = f.Parts
+	}
+
+	t := s.loadTransfer(w, r, id, "complete")
+	if t == nil {
+		return
+	}
+
+	files, err := s.completeTransfer(ctx, t, "complete", parts)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			s.logger.Printf("complete: transfer not found id=%s", id)
-			http.Error(w, "transfer not found", http.StatusNotFound)
+		var ce *completeError
+		if errors.As(err, &ce) {
+			s.writeCompleteError(w, err)
 			return
 		}
-		s.logger.Printf("complete: failed to fetch transfer id=%s: %v", id, err)
-		http.Error(w, "failed to fetch transfer", http.StatusInternalServerError)
-		return
+		// a retried complete, or one that lost the race with the upload-events consumer,
+		// succeeds if the transfer was completed from the same manifest
+		done, doneFiles, derr := s.completedTransfer(ctx, id, parts)
+		if derr != nil {
+			s.writeCompleteError(w, derr)
+			return
+		}
+		if done == nil {
+			s.writeCompleteError(w, err)
+			return
+		}
+		s.logger.Printf("complete: already completed id=%s status=%s", id, done.Status)
+		t, files = done, doneFiles
 	}
 
-	if isExpired(expiresAt) {
-		if status != "EXPIRED" {
-			_, _ = s.db.Exec(ctx, `UPDATE transfers SET status='EXPIRED' WHERE id=$1`, id)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":        id,
+		"status":    t.Status,
+		"file_size": t.FileSize,
+		"file_type": t.FileType,
+		"filename":  t.Filename,
+		"files":     fileResponses(files),
+	})
+}
+
+// completedTransfer returns transfer id and its files if the transfer was already completed
+// (READY or SCANNING) and parts names no file outside its manifest. It returns a nil
+// transfer if the transfer is in any other state, and errors as a *completeError.
+func (s *Server) completedTransfer(ctx context.Context, id string, parts map[string][]storage.Part) (*repository.Transfer, []repository.File, error) {
+	t, err := s.repo.Get(ctx, id)
+	if err != nil {
+		s.logger.Printf("complete: failed to fetch transfer id=%s: %v", id, err)
+		return nil, nil, &completeError{Status: http.StatusInternalServerError, Detail: "failed to fetch transfer"}
+	}
+	if (t.Status != repository.StatusReady && t.Status != repository.StatusScanning) || t.UploadedAt == nil {
+		return nil, nil, nil
+	}
+	files, err := s.repo.ListFiles(ctx, id)
+	if err != nil {
+		s.logger.Printf("complete: failed to load files id=%s: %v", id, err)
+		return nil, nil, &completeError{Status: http.StatusInternalServerError, Detail: "failed to fetch transfer"}
+	}
+	for fileID := range parts {
+		if _, err := findFile(files, fileID); err != nil {
+			return nil, nil, nil
 		}
-		s.logger.Printf("complete: transfer expired id=%s expires_at=%s", id, expiresAt)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusGone)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "transfer_expired"})
-		return
 	}
+	return t, files, nil
+}
 
-	if status != "INIT" {
-		s.logger.Printf("complete: invalid state id=%s status=%s", id, status)
-		http.Error(w, "transfer not in INIT state", http.StatusBadRequest)
+// completeRequest names, for every file uploaded in parts, the parts to assemble in order.
+// Parts it doesn't list, e.g. a stray upload of an extra part number, are left out.
+type completeRequest struct {
+	Files []completeFileRequest `json:"files"`
+}
+
+type completeFileRequest struct {
+	FileID string         `json:"file_id"`
+	Parts  []storage.Part `json:"parts"`
+}
+
+// completeError is a completion refused because of what was (or wasn't) uploaded, or
+// because storage or the database failed. Code, if set, is sent as a JSON error naming File.
+type completeError struct {
+	Status int
+	Code   string
+	File   string
+	Detail string
+}
+
+func (e *completeError) Error() string {
+	return e.Detail
+}
+
+// writeCompleteError writes an error returned by completeTransfer.
+func (s *Server) writeCompleteError(w http.ResponseWriter, err error) {
+	var ce *completeError
+	if !errors.As(err, &ce) {
+		s.writeTransitionError(w, err)
 		return
 	}
-
-	if objectKey == nil || *objectKey == "" {
-		s.logger.Printf("complete: missing object_key id=%s", id)
-		http.Error(w, "upload not started", http.StatusBadRequest)
+	if ce.Code == "" {
+		http.Error(w, ce.Detail, ce.Status)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(ce.Status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": ce.Code, "file": ce.File, "detail": ce.Detail})
+}
+
+// completeTransfer validates every file of t against storage and moves t from INIT to
+// READY, or to SCANNING with a malware scanner configured. parts holds, by file ID, the
+// parts to assemble for files with a multipart upload in progress. t is updated in place
+// and the files are returned with their stored metadata. Errors are a *completeError, or a
+// transition error or repository.ErrConflict if t is not (or no longer) INIT. op
+// prefixes log lines, so the handlers and background paths sharing this can be told apart.
+func (s *Server) completeTransfer(ctx context.Context, t *repository.Transfer, op string, parts map[string][]storage.Part) ([]repository.File, error) {
+	id := t.ID
+
+	// with a scanner configured the files wait in SCANNING until RunScans clears them
+	to := repository.StatusReady
+	if s.scanner != nil {
+		to = repository.StatusScanning
+	}
+
+	// a second complete would be READY -> READY, which the lifecycle rejects
+	if err := repository.CheckTransition(t.Status, to); err != nil {
+		s.logger.Printf("%s: invalid state id=%s status=%s", op, id, t.Status)
+		return nil, err
+	}
+
+	files, err := s.repo.ListFiles(ctx, id)
+	if err != nil {
+		s.logger.Printf("%s: failed to load files id=%s: %v", op, id, err)
+		return nil, &completeError{Status: http.StatusInternalServerError, Detail: "failed to fetch transfer"}
+	}
+	if len(files) == 0 {
+		s.logger.Printf("%s: no files id=%s", op, id)
+		return nil, &completeError{Status: http.StatusBadRequest, Detail: "upload not started"}
+	}
 
 	bucket := os.Getenv("S3_BUCKET")
 	if bucket == "" {
-		s.logger.Printf("complete: s3 bucket not configured")
-		http.Error(w, "configuration error", http.StatusInternalServerError)
-		return
+		s.logger.Printf("%s: s3 bucket not configured", op)
+		return nil, &completeError{Status: http.StatusInternalServerError, Detail: "configuration error"}
+	}
+	for fileID := range parts {
+		if _, err := findFile(files, fileID); err != nil {
+			return nil, &completeError{Status: http.StatusBadRequest, Detail: fmt.Sprintf("unknown file_id %q", fileID)}
+		}
+	}
+
+	// Check every file before assembling any multipart upload. Assembly can't be undone,
+	// so a file rejected after another was assembled would leave that one behind with an
+	// upload_id that no longer exists, failing every retry.
+	policy := s.uploadPolicy(t)
+	chosen := make(map[string][]storage.Part)
+	for i := range files {
+		f := &files[i]
+		c, err := s.prepareUpload(ctx, bucket, *f, parts[f.ID])
+		if err != nil {
+			return nil, s.uploadError(op, id, *f, err)
+		}
+		if c != nil {
+			chosen[f.ID] = c
+			continue
+		}
+		if err := s.verifyUpload(ctx, op, bucket, policy, f); err != nil {
+			return nil, err
+		}
+	}
+
+	// assembled files are checked like the others; one that fails is rejected and
+	// uploaded again, the rest stay assembled with upload_id cleared
+	for i := range files {
+		f := &files[i]
+		c, ok := chosen[f.ID]
+		if !ok {
+			continue
+		}
+		if err := s.assembleUpload(ctx, bucket, *f, c); err != nil {
+			return nil, s.uploadError(op, id, *f, err)
+		}
+		f.UploadID = nil
+		if err := s.verifyUpload(ctx, op, bucket, policy, f); err != nil {
+			return nil, err
+		}
+	}
+
+	var totalSize int64
+	for _, f := range files {
+		totalSize += *f.FileSize
 	}
 
-	// Fetch S3 metadata
-	size, contentType, err := s.s3.HeadObject(ctx, bucket, *objectKey)
+	// transfers.filename/file_type/file_size summarise the manifest for listings and emails
+	filename := displayName(files)
+	var fileType *string
+	if len(files) == 1 {
+		fileType = files[0].FileType
+	}
+
+	// strict update: only set to READY (or SCANNING) and update metadata if currently INIT
+	now := time.Now().UTC()
+	err = s.repo.MarkReady(ctx, id, repository.ReadyUpdate{
+		Status:     to,
+		Filename:   filename,
+		FileType:   fileType,
+		FileSize:   totalSize,
+		UploadedAt: now,
+		Files:      files,
+	})
 	if err != nil {
-		s.logger.Printf("complete: failed to head object id=%s key=%s: %v", id, *objectKey, err)
-		// Do NOT mark as READY if S3 object is missing or inaccessible
-		http.Error(w, "upload validation failed", http.StatusBadGateway)
-		return
+		if errors.Is(err, repository.ErrConflict) {
+			// another concurrent change; be strict
+			s.logger.Printf("%s: no rows updated (concurrent) id=%s", op, id)
+			return nil, err
+		}
+		s.logger.Printf("%s: failed to update transfer id=%s: %v", op, id, err)
+		return nil, &completeError{Status: http.StatusInternalServerError, Detail: "failed to update transfer"}
+	}
+	for i := range files {
+		files[i].UploadedAt = &now
+	}
+
+	t.Status = to
+	t.Filename, t.FileType, t.FileSize, t.UploadedAt = &filename, fileType, &totalSize, &now
+	if to == repository.StatusReady {
+		s.notifyWebhooks(ctx, webhookTransferReady, t)
+	}
+
+	s.logger.Printf("transfer marked %s id=%s files=%d size=%d", to, id, len(files), totalSize)
+	return files, nil
+}
+
+var (
+	errNoParts          = errors.New("no parts uploaded")
+	errPartsRequired    = errors.New("parts are required")
+	errInvalidParts     = errors.New("invalid parts")
+	errUploadIncomplete = errors.New("upload incomplete")
+)
+
+// rejectUpload deletes the object of a file that violates the upload policy and drops the
+// file from the manifest. Failures are only logged; the transfer stays INIT either way.
+func (s *Server) rejectUpload(ctx context.Context, bucket string, f repository.File) {
+	if err := s.store.DeleteObject(ctx, bucket, f.ObjectKey); err != nil {
+		s.logger.Printf("complete: failed to delete rejected object key=%s: %v", f.ObjectKey, err)
+	}
+	if err := s.repo.DeleteFile(ctx, f.ID, nil); err != nil {
+		s.logger.Printf("complete: failed to drop rejected file file_id=%s: %v", f.ID, err)
 	}
+}
 
-	// Extract filename from object_key (uploads/{id}/{filename})
-	parts := strings.Split(*objectKey, "/")
-	filename := parts[len(parts)-1]
+// uploadError maps an error from prepareUpload or assembleUpload to a *completeError.
+func (s *Server) uploadError(op, id string, f repository.File, err error) error {
+	s.logger.Printf("%s: upload validation failed id=%s key=%s: %v", op, id, f.ObjectKey, err)
+	// Do NOT mark as READY if any object is missing or inaccessible
+	switch {
+	case err == errNoParts:
+		return &completeError{Status: http.StatusBadRequest, Detail: fmt.Sprintf("no parts uploaded for %q", f.Filename)}
+	case err == errPartsRequired:
+		return &completeError{Status: http.StatusBadRequest, Detail: fmt.Sprintf("parts are required for %q", f.Filename)}
+	case errors.Is(err, errInvalidParts):
+		return &completeError{Status: http.StatusBadRequest, Detail: fmt.Sprintf("%q: %v", f.Filename, err)}
+	case err == errUploadIncomplete:
+		return &completeError{Status: http.StatusBadRequest, Detail: fmt.Sprintf("upload of %q is incomplete", f.Filename)}
+	}
+	return &completeError{Status: http.StatusBadGateway, Detail: fmt.Sprintf("upload validation failed for %q", f.Filename)}
+}
 
-	// perform strict update: only set to READY and update metadata if currently INIT
-	tag, err := s.db.Exec(ctx, `
-		UPDATE transfers 
-		SET status=$1, filename=$2, file_type=$3, file_size=$4, uploaded_at=NOW() 
-		WHERE id=$5 AND status=$6`,
-		"READY", filename, contentType, size, id, "INIT")
+// prepareUpload checks that f is ready to be assembled without changing anything in
+// storage. For a pending multipart upload it returns the parts to assemble, picked from
+// the ones the client listed; for a file stored in one piece it returns nil.
+func (s *Server) prepareUpload(ctx context.Context, bucket string, f repository.File, want []storage.Part) ([]storage.Part, error) {
+	// a resumable upload is assembled by its last PATCH; until then its parts are partial
+	if f.UploadLength != nil && (f.UploadOffset == nil || *f.UploadOffset < *f.UploadLength) {
+		return nil, errUploadIncomplete
+	}
+	if f.UploadID == nil {
+		return nil, nil
+	}
+	mp, ok := s.store.(storage.MultipartStore)
+	if !ok {
+		return nil, errors.New("multipart uploads are not supported by this storage backend")
+	}
+	parts, err := mp.ListParts(ctx, bucket, f.ObjectKey, *f.UploadID)
 	if err != nil {
-		s.logger.Printf("complete: failed to update transfer id=%s: %v", id, err)
-		http.Error(w, "failed to update transfer", http.StatusInternalServerError)
-		return
+		return nil, fmt.Errorf("list parts: %w", err)
+	}
+	if len(parts) == 0 {
+		return nil, errNoParts
 	}
+	if len(want) == 0 {
+		return nil, errPartsRequired
+	}
+	return selectParts(parts, want)
+}
 
-	if tag.RowsAffected() == 0 {
-		// another concurrent change; be strict
-		s.logger.Printf("complete: no rows updated (concurrent) id=%s", id)
-		http.Error(w, "transfer state changed", http.StatusConflict)
-		return
+// assembleUpload completes the multipart upload of f from parts and clears its upload_id,
+// so a later complete finds the object instead of an upload that no longer exists.
+func (s *Server) assembleUpload(ctx context.Context, bucket string, f repository.File, parts []storage.Part) error {
+	mp := s.store.(storage.MultipartStore)
+	if err := mp.CompleteMultipartUpload(ctx, bucket, f.ObjectKey, *f.UploadID, parts); err != nil {
+		return fmt.Errorf("complete multipart upload: %w", err)
 	}
+	if err := s.repo.MarkAssembled(ctx, f.ID, *f.UploadID); err != nil {
+		return fmt.Errorf("clear upload_id: %w", err)
+	}
+	s.logger.Printf("complete: multipart upload assembled key=%s parts=%d", f.ObjectKey, len(parts))
+	return nil
+}
 
-	s.logger.Printf("transfer marked READY id=%s filename=%q size=%d type=%s", id, filename, size, contentType)
+// verifyUpload checks the stored object of f against the upload policy, its declared size
+// and its declared checksums, and fills in its size, type and checksums. A file that
+// violates the policy is rejected. Errors are a *completeError.
+func (s *Server) verifyUpload(ctx context.Context, op, bucket string, policy uploadPolicy, f *repository.File) error {
+	id := f.TransferID
+	size, contentType, err := s.store.HeadObject(ctx, bucket, f.ObjectKey)
+	if err != nil {
+		return s.uploadError(op, id, *f, err)
+	}
+	// the object is removed so a violating file can't linger in the bucket; the
+	// client uploads an acceptable one under a fresh upload URL
+	if err := policy.check(size, contentType); err != nil {
+		s.logger.Printf("%s: upload policy violated id=%s key=%s: %v", op, id, f.ObjectKey, err)
+		s.rejectUpload(ctx, bucket, *f)
+		return &completeError{Status: http.StatusUnprocessableEntity, Code: "upload_policy_violation", File: f.Filename, Detail: err.Error()}
+	}
+	// storage holds single uploads to the declared size; this catches multipart uploads
+	// assembled from other parts than the layout the size was declared with
+	if f.FileSize != nil && *f.FileSize != size {
+		err := fmt.Errorf("file is %d bytes, %d were declared", size, *f.FileSize)
+		s.logger.Printf("%s: upload policy violated id=%s key=%s: %v", op, id, f.ObjectKey, err)
+		s.rejectUpload(ctx, bucket, *f)
+		return &completeError{Status: http.StatusUnprocessableEntity, Code: "upload_policy_violation", File: f.Filename, Detail: err.Error()}
+	}
+	f.FileSize = &size
+	f.FileType = &contentType
+
+	// the declared checksums must match what storage computed from the body
+	sums, err := s.store.ObjectChecksums(ctx, bucket, f.ObjectKey)
+	if err != nil {
+		s.logger.Printf("%s: failed to read checksums id=%s key=%s: %v", op, id, f.ObjectKey, err)
+		return &completeError{Status: http.StatusBadGateway, Detail: fmt.Sprintf("upload validation failed for %q", f.Filename)}
+	}
+	if err := verifyChecksums(*f, sums); err != nil {
+		s.logger.Printf("%s: checksum verification failed id=%s key=%s: %v", op, id, f.ObjectKey, err)
+		return &completeError{Status: http.StatusUnprocessableEntity, Code: "checksum_mismatch", File: f.Filename, Detail: err.Error()}
+	}
+	f.ChecksumSHA256, f.ChecksumCRC32C = optionalString(sums.SHA256), optionalString(sums.CRC32C)
+	return nil
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(map[string]interface{}{
-		"id":        id,
-		"status":    "READY",
-		"file_size": size,
-		"file_type": contentType,
-		"filename":  filename,
-	})
+// selectParts returns the uploaded parts the client listed, checking that they are in
+// ascending order and that each ETag, and checksum if storage recorded one, matches what
+// storage holds for that part number. With REQUIRE_UPLOAD_CHECKSUM=true every part must
+// have been uploaded with a checksum.
+func selectParts(uploaded, want []storage.Part) ([]storage.Part, error) {
+	byNumber := make(map[int32]storage.Part, len(uploaded))
+	for _, p := range uploaded {
+		byNumber[p.PartNumber] = p
+	}
+	chosen := make([]storage.Part, 0, len(want))
+	for i, w := range want {
+		if i > 0 && w.PartNumber <= want[i-1].PartNumber {
+			return nil, fmt.Errorf("%w: part numbers must be ascending", errInvalidParts)
+		}
+		p, ok := byNumber[w.PartNumber]
+		if !ok {
+			return nil, fmt.Errorf("%w: part %d was not uploaded", errInvalidParts, w.PartNumber)
+		}
+		// S3 quotes ETags; accept them with or without the quotes
+		if strings.Trim(w.ETag, `"`) != strings.Trim(p.ETag, `"`) {
+			return nil, fmt.Errorf("%w: etag of part %d does not match", errInvalidParts, w.PartNumber)
+		}
+		if p.ChecksumSHA256 == "" && checksumRequired() {
+			return nil, fmt.Errorf("%w: part %d was uploaded without checksum_sha256", errInvalidParts, w.PartNumber)
+		}
+		if w.ChecksumSHA256 != p.ChecksumSHA256 {
+			return nil, fmt.Errorf("%w: checksum_sha256 of part %d does not match", errInvalidParts, w.PartNumber)
+		}
+		chosen = append(chosen, p)
+	}
+	return chosen, nil
 }
 
 func (s *Server) getTransferHandler(w http.ResponseWriter, r *http.Request, id string) {
 	ctx := r.Context()
-	var t transferResponse
-	err := s.db.QueryRow(ctx, `
-		SELECT id, status, expires_at, download_count, max_downloads, object_key, created_at, filename, file_type, file_size, uploaded_at 
-		FROM transfers WHERE id=$1`, id).
-		Scan(&t.ID, &t.Status, &t.ExpiresAt, &t.DownloadCount, &t.MaxDownloads, &t.ObjectKey, &t.CreatedAt, &t.Filename, &t.FileType, &t.FileSize, &t.UploadedAt)
+	tr, err := s.repo.Get(ctx, id)
 	if err != nil {
-		if err == pgx.ErrNoRows {
+		if errors.Is(err, repository.ErrNotFound) {
 			http.NotFound(w, r)
 			return
 		}
@@ -657,12 +1209,16 @@ func (s *Server) getTransferHandler(w http.ResponseWriter, r *http.Request, id s
 		return
 	}
 
-	if isExpired(t.ExpiresAt) {
-		if t.Status != "EXPIRED" && t.Status != "DELETED" {
-			_, _ = s.db.Exec(ctx, `UPDATE transfers SET status='EXPIRED' WHERE id=$1`, id)
-			t.Status = "EXPIRED"
-		}
+	s.expireIfDue(ctx, tr)
+
+	files, err := s.repo.ListFiles(ctx, id)
+	if err != nil {
+		s.logger.Printf("get: failed to load files id=%s: %v", id, err)
+		http.Error(w, "failed to fetch transfer", http.StatusInternalServerError)
+		return
 	}
+	t := newTransferResponse(tr)
+	t.Files = fileResponses(files)
 
 	w.Header().Set("Content-Type", "application/json")
 	s.logger.Printf("fetched transfer id=%s", t.ID)
@@ -673,19 +1229,22 @@ func (s *Server) updateTransferHandler(w http.ResponseWriter, r *http.Request, i
 	ctx := r.Context()
 
 	// 1. Check status
-	var status string
-	err := s.db.QueryRow(ctx, "SELECT status FROM transfers WHERE id=$1", id).Scan(&status)
+	t, err := s.repo.Get(ctx, id)
 	if err != nil {
-		if err == pgx.ErrNoRows {
+		if errors.Is(err, repository.ErrNotFound) {
 			http.NotFound(w, r)
 			return
 		}
 		http.Error(w, "failed to fetch transfer", http.StatusInternalServerError)
 		return
 	}
+	// the transition below is checked against the status the transfer really has
+	s.expireIfDue(ctx, t)
 
-	if status == "INIT" || status == "DELETED" {
-		http.Error(w, "transfer cannot be updated in current state", http.StatusConflict)
+	if t.Status != repository.StatusReady && t.Status != repository.StatusExpired {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "not_updatable", "status": string(t.Status)})
 		return
 	}
 
@@ -710,83 +1269,80 @@ func (s *Server) updateTransferHandler(w http.ResponseWriter, r *http.Request, i
 			return
 		}
 	}
+	var target *repository.Status
 	if req.Status != nil {
-		st := *req.Status
-		if st != "EXPIRED" && st != "READY" {
+		st := repository.Status(*req.Status)
+		if st != repository.StatusExpired && st != repository.StatusReady {
 			http.Error(w, "status can only be updated to 'EXPIRED' or 'READY'", http.StatusBadRequest)
 			return
 		}
+		target = &st
 	}
 
-	// Build query dynamically
-	var updates []string
-	var args []interface{}
-	idx := 1
-
-	if req.ExpiresAt != nil {
-		updates = append(updates, fmt.Sprintf("expires_at=$%d", idx))
-		args = append(args, *req.ExpiresAt)
-		idx++
-
-		// If currently EXPIRED and we are extending expiry, revive to READY
-		if status == "EXPIRED" {
-			updates = append(updates, "status='READY'")
-		}
+	// If currently EXPIRED and we are extending expiry, revive to READY
+	// (this takes precedence over a requested status)
+	if req.ExpiresAt != nil && t.Status == repository.StatusExpired {
+		ready := repository.StatusReady
+		target = &ready
 	}
-	if req.MaxDownloads != nil {
-		updates = append(updates, fmt.Sprintf("max_downloads=$%d", idx))
-		args = append(args, *req.MaxDownloads)
-		idx++
+
+	upd := repository.TransferUpdate{
+		From:         t.Status,
+		ExpiresAt:    req.ExpiresAt,
+		MaxDownloads: req.MaxDownloads,
 	}
-	if req.Status != nil {
-		// Only apply if not already overridden by revival logic (which sets READY)
-		statusTouched := false
-		for _, u := range updates {
-			if strings.HasPrefix(u, "status=") {
-				statusTouched = true
-				break
-			}
+	// requesting the status the transfer already has is no transition either
+	// (e.g. READY → READY), and is rejected like any other disallowed one
+	if target != nil {
+		expiresAt := t.ExpiresAt
+		if req.ExpiresAt != nil {
+			expiresAt = *req.ExpiresAt
 		}
-		if !statusTouched {
-			updates = append(updates, fmt.Sprintf("status=$%d", idx))
-			args = append(args, *req.Status)
-			idx++
+		if err := repository.ValidateTransition(t, *target, expiresAt); err != nil {
+			s.logger.Printf("update: rejected transition id=%s: %v", id, err)
+			s.writeTransitionError(w, err)
+			return
 		}
+		upd.Status = target
 	}
 
-	if len(updates) > 0 {
-		query := fmt.Sprintf("UPDATE transfers SET %s WHERE id=$%d", strings.Join(updates, ", "), idx)
-		args = append(args, id)
-		_, err := s.db.Exec(ctx, query, args...)
-		if err != nil {
-			s.logger.Printf("update: failed to update transfer id=%s: %v", id, err)
-			http.Error(w, "failed to update transfer", http.StatusInternalServerError)
+	if err := s.repo.Update(ctx, id, upd); err != nil {
+		var te *repository.TransitionError
+		if errors.Is(err, repository.ErrConflict) || errors.As(err, &te) {
+			s.logger.Printf("update: rejected transition id=%s: %v", id, err)
+			s.writeTransitionError(w, err)
 			return
 		}
+		s.logger.Printf("update: failed to update transfer id=%s: %v", id, err)
+		http.Error(w, "failed to update transfer", http.StatusInternalServerError)
+		return
 	}
 
 	// Return updated status (which might be READY now or EXPIRED)
-	newStatus := status
-	if status == "EXPIRED" && req.ExpiresAt != nil {
-		newStatus = "READY"
+	newStatus := t.Status
+	if upd.Status != nil {
+		newStatus = *upd.Status
 	}
-	if req.Status != nil {
-		newStatus = *req.Status
+
+	if newStatus == repository.StatusExpired && t.Status != repository.StatusExpired {
+		t.Status = newStatus
+		if req.MaxDownloads != nil {
+			t.MaxDownloads = *req.MaxDownloads
+		}
+		s.notifyWebhooks(ctx, webhookTransferExpired, t)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	s.logger.Printf("updated transfer id=%s status=%s", id, newStatus)
-	_ = json.NewEncoder(w).Encode(map[string]string{"id": id, "status": newStatus})
+	_ = json.NewEncoder(w).Encode(map[string]string{"id": id, "status": string(newStatus)})
 }
 
 func (s *Server) deleteTransferHandler(w http.ResponseWriter, r *http.Request, id string) {
 	ctx := r.Context()
 
-	var status string
-	var objectKey *string
-	err := s.db.QueryRow(ctx, "SELECT status, object_key FROM transfers WHERE id=$1", id).Scan(&status, &objectKey)
+	t, err := s.repo.Get(ctx, id)
 	if err != nil {
-		if err == pgx.ErrNoRows {
+		if errors.Is(err, repository.ErrNotFound) {
 			http.NotFound(w, r)
 			return
 		}
@@ -794,23 +1350,33 @@ func (s *Server) deleteTransferHandler(w http.ResponseWriter, r *http.Request, i
 		return
 	}
 
-	// If object exists: Delete S3 object (best effort)
-	if objectKey != nil && *objectKey != "" {
-		bucket := os.Getenv("S3_BUCKET")
-		if bucket != "" {
-			if err := s.s3.DeleteObject(ctx, bucket, *objectKey); err != nil {
-				s.logger.Printf("delete: failed to delete s3 object %s: %v", *objectKey, err)
+	files, err := s.repo.ListFiles(ctx, id)
+	if err != nil {
+		s.logger.Printf("delete: failed to load files id=%s: %v", id, err)
+		http.Error(w, "failed to fetch transfer", http.StatusInternalServerError)
+		return
+	}
+
+	// Delete every S3 object (best effort)
+	if bucket := os.Getenv("S3_BUCKET"); bucket != "" {
+		for _, f := range files {
+			s.abortMultipart(ctx, bucket, f)
+			if err := s.store.DeleteObject(ctx, bucket, f.ObjectKey); err != nil {
+				s.logger.Printf("delete: failed to delete s3 object %s: %v", f.ObjectKey, err)
 			}
 		}
+		if err := s.store.DeleteObject(ctx, bucket, archiveKey(id)); err != nil {
+			s.logger.Printf("delete: failed to delete cached archive %s: %v", archiveKey(id), err)
+		}
 	}
 
-	// Delete DB entry (Hard Delete)
-	_, err = s.db.Exec(ctx, "DELETE FROM transfers WHERE id=$1", id)
-	if err != nil {
+	// Delete DB entry (Hard Delete, transfer_files rows cascade)
+	if err := s.repo.Delete(ctx, id); err != nil && !errors.Is(err, repository.ErrNotFound) {
 		s.logger.Printf("delete: failed to delete transfer id=%s: %v", id, err)
 		http.Error(w, "failed to delete transfer", http.StatusInternalServerError)
 		return
 	}
+	s.notifyWebhooks(ctx, webhookTransferDeleted, t)
 
 	w.WriteHeader(http.StatusNoContent)
 	s.logger.Printf("deleted transfer id=%s", id)
@@ -822,6 +1388,10 @@ func (s *Server) triggerDeleteHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	// cleanup spans every owner's transfers
+	if !s.requireAdmin(w, r) {
+		return
+	}
 
 	// Trigger cleanup
 	s.RunCleanup()
@@ -844,7 +1414,11 @@ func (s *Server) listTransfersHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	query := r.URL.Query()
-	statusFilter := query.Get("status")
+	statusFilter := repository.Status(strings.ToUpper(query.Get("status")))
+	if statusFilter != "" && !statusFilter.Valid() {
+		http.Error(w, "invalid status", http.StatusBadRequest)
+		return
+	}
 	limitVal := 50
 	offsetVal := 0
 
@@ -868,91 +1442,34 @@ func (s *Server) listTransfersHandler(w http.ResponseWriter, r *http.Request) {
 
 	ctx := r.Context()
 
-	// Count total
-	var totalCount int
-	if statusFilter != "" {
-		err := s.db.QueryRow(ctx, "SELECT COUNT(*) FROM transfers WHERE status=$1", statusFilter).Scan(&totalCount)
-		if err != nil {
-			s.logger.Printf("list: failed to count transfers: %v", err)
-			http.Error(w, "failed to list transfers", http.StatusInternalServerError)
-			return
-		}
-	} else {
-		err := s.db.QueryRow(ctx, "SELECT COUNT(*) FROM transfers").Scan(&totalCount)
-		if err != nil {
-			s.logger.Printf("list: failed to count transfers: %v", err)
-			http.Error(w, "failed to list transfers", http.StatusInternalServerError)
-			return
-		}
-	}
-
-	// Build SELECT query
-	var sqlStr strings.Builder
-	var selectArgs []interface{}
-	selectIdx := 1
-
-	sqlStr.WriteString(`
-		SELECT id, status, expires_at, download_count, max_downloads, created_at, filename, file_type, file_size, uploaded_at 
-		FROM transfers`)
-
-	if statusFilter != "" {
-		sqlStr.WriteString(fmt.Sprintf(" WHERE status=$%d", selectIdx))
-		selectArgs = append(selectArgs, statusFilter)
-		selectIdx++
+	// callers only see their own transfers, admins see everything
+	filter := repository.ListFilter{
+		Status:    statusFilter,
+		SortBy:    query.Get("sort_by"),
+		Ascending: strings.ToUpper(query.Get("order")) == "ASC",
+		Limit:     limitVal,
+		Offset:    offsetVal,
 	}
-
-	// Sorting
-	sortBy := query.Get("sort_by")
-	sortOrder := strings.ToUpper(query.Get("order"))
-	if sortOrder != "ASC" {
-		sortOrder = "DESC"
-	}
-
-	switch sortBy {
-	case "expires_at":
-		sqlStr.WriteString(fmt.Sprintf(" ORDER BY expires_at %s", sortOrder))
-	case "max_downloads":
-		sqlStr.WriteString(fmt.Sprintf(" ORDER BY max_downloads %s", sortOrder))
-	case "file_size":
-		sqlStr.WriteString(fmt.Sprintf(" ORDER BY file_size %s", sortOrder))
-	default:
-		sqlStr.WriteString(fmt.Sprintf(" ORDER BY created_at %s", sortOrder))
+	if p := principalFrom(r.Context()); !p.Admin {
+		filter.OwnerID = &p.OwnerID
 	}
 
-	sqlStr.WriteString(fmt.Sprintf(" LIMIT $%d OFFSET $%d", selectIdx, selectIdx+1))
-	selectArgs = append(selectArgs, limitVal, offsetVal)
-
-	rows, err := s.db.Query(ctx, sqlStr.String(), selectArgs...)
+	transfers, totalCount, err := s.repo.List(ctx, filter)
 	if err != nil {
 		s.logger.Printf("list: failed to query transfers: %v", err)
 		http.Error(w, "failed to list transfers", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	items := []transferResponse{}
-	for rows.Next() {
-		var t transferResponse
-		if err := rows.Scan(&t.ID, &t.Status, &t.ExpiresAt, &t.DownloadCount, &t.MaxDownloads, &t.CreatedAt, &t.Filename, &t.FileType, &t.FileSize, &t.UploadedAt); err != nil {
-			s.logger.Printf("list: failed to scan row: %v", err)
-			continue
-		}
 
+	items := make([]transferResponse, 0, len(transfers))
+	for i := range transfers {
 		// Lazy expiry check
-		if isExpired(t.ExpiresAt) && t.Status != "EXPIRED" && t.Status != "DELETED" {
-			_, _ = s.db.Exec(ctx, `UPDATE transfers SET status='EXPIRED' WHERE id=$1`, t.ID)
-			t.Status = "EXPIRED"
-		}
-
-		items = append(items, t)
+		s.expireIfDue(ctx, &transfers[i])
+		items = append(items, newTransferResponse(&transfers[i]))
 	}
 
 	s.logger.Printf("list: returning %d items (total %d)", len(items), totalCount)
 
-	if items == nil {
-		items = []transferResponse{}
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(listTransfersResponse{
 		Items:      items,
@@ -973,47 +1490,152 @@ func (s *Server) RunCleanup() {
 	}
 
 	// 1. Find candidates
-	rows, err := s.db.Query(ctx, `SELECT id, object_key FROM transfers WHERE (status = 'EXPIRED') AND object_key IS NOT NULL`)
+	candidates, err := s.repo.CleanupCandidates(ctx)
 	if err != nil {
 		s.logger.Printf("cleanup: failed to query candidates: %v", err)
 		return
 	}
-	defer rows.Close()
-
-	type candidate struct {
-		ID  string
-		Key string
-	}
-	var candidates []candidate
-	for rows.Next() {
-		var c candidate
-		if err := rows.Scan(&c.ID, &c.Key); err != nil {
-			continue
-		}
-		candidates = append(candidates, c)
-	}
 
 	if len(candidates) > 0 {
 		s.logger.Printf("cleanup: found %d transfers to clean", len(candidates))
 	}
 
 	// 2. Process each
-	for _, c := range candidates {
-		s.logger.Printf("cleanup: deleting s3 object %s for transfer %s", c.Key, c.ID)
-		if err := s.s3.DeleteObject(ctx, bucket, c.Key); err != nil {
-			s.logger.Printf("cleanup: failed to delete s3 object %s: %v", c.Key, err)
-			// continue to next, don't clear DB key if failed (so we retry later)
+	for _, id := range candidates {
+		files, err := s.repo.ListFiles(ctx, id)
+		if err != nil {
+			s.logger.Printf("cleanup: failed to load files for %s: %v", id, err)
+			continue
+		}
+
+		failed := false
+		for _, f := range files {
+			s.logger.Printf("cleanup: deleting s3 object %s for transfer %s", f.ObjectKey, id)
+			s.abortMultipart(ctx, bucket, f)
+			if err := s.store.DeleteObject(ctx, bucket, f.ObjectKey); err != nil {
+				s.logger.Printf("cleanup: failed to delete s3 object %s: %v", f.ObjectKey, err)
+				// keep the row so we retry later
+				failed = true
+				continue
+			}
+			if err := s.repo.DeleteFile(ctx, f.ID, nil); err != nil {
+				s.logger.Printf("cleanup: failed to remove file row %s: %v", f.ID, err)
+				failed = true
+			}
+		}
+		if err := s.store.DeleteObject(ctx, bucket, archiveKey(id)); err != nil {
+			s.logger.Printf("cleanup: failed to delete cached archive %s: %v", archiveKey(id), err)
+			failed = true
+		}
+		if failed {
 			continue
 		}
 
 		// 3. Update DB
-		_, err := s.db.Exec(ctx, `UPDATE transfers SET status='DELETED', object_key=NULL WHERE id=$1`, c.ID)
-		if err != nil {
-			s.logger.Printf("cleanup: failed to update status to DELETED for %s: %v", c.ID, err)
+		if err := s.repo.MarkDeleted(ctx, id); err != nil {
+			if errors.Is(err, repository.ErrConflict) {
+				// revived while its objects were being removed
+				s.logger.Printf("cleanup: transfer %s is no longer EXPIRED or QUARANTINED, not marking DELETED", id)
+				continue
+			}
+			s.logger.Printf("cleanup: failed to update status to DELETED for %s: %v", id, err)
+			continue
+		}
+		if t, err := s.repo.Get(ctx, id); err == nil {
+			s.notifyWebhooks(ctx, webhookTransferDeleted, t)
+		} else {
+			s.logger.Printf("cleanup: failed to reload transfer %s for webhooks: %v", id, err)
 		}
 	}
 }
 
+// abortMultipart discards the unfinished multipart upload of f, and the staged tail of a
+// resumable upload (best effort).
+func (s *Server) abortMultipart(ctx context.Context, bucket string, f repository.File) {
+	if f.UploadID == nil {
+		return
+	}
+	mp, ok := s.store.(storage.MultipartStore)
+	if !ok {
+		return
+	}
+	if err := mp.AbortMultipartUpload(ctx, bucket, f.ObjectKey, *f.UploadID); err != nil {
+		s.logger.Printf("failed to abort multipart upload key=%s upload_id=%s: %v", f.ObjectKey, *f.UploadID, err)
+	}
+	if f.UploadLength != nil && f.UploadOffset != nil {
+		key := tusTailKey(f, *f.UploadOffset)
+		if err := s.store.DeleteObject(ctx, bucket, key); err != nil {
+			s.logger.Printf("failed to delete staged tus tail key=%s: %v", key, err)
+		}
+	}
+}
+
+// loadTransfer fetches a transfer for an action handler. A transfer past its expires_at
+// is marked EXPIRED on the way (lazy expiry) and answered with 410 Gone. It writes the
+// error response itself and returns nil when the request cannot proceed.
+func (s *Server) loadTransfer(w http.ResponseWriter, r *http.Request, id, op string) *repository.Transfer {
+	t, err := s.repo.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			s.logger.Printf("%s: transfer not found id=%s", op, id)
+			http.Error(w, "transfer not found", http.StatusNotFound)
+			return nil
+		}
+		s.logger.Printf("%s: failed to fetch transfer id=%s: %v", op, id, err)
+		http.Error(w, "failed to fetch transfer", http.StatusInternalServerError)
+		return nil
+	}
+
+	if isExpired(t.ExpiresAt) {
+		s.expireIfDue(r.Context(), t)
+		s.logger.Printf("%s: transfer expired id=%s expires_at=%s", op, id, t.ExpiresAt)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusGone)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "transfer_expired"})
+		return nil
+	}
+	return t
+}
+
+// expireIfDue applies lazy expiry to a transfer that is only being read.
+func (s *Server) expireIfDue(ctx context.Context, t *repository.Transfer) {
+	if !isExpired(t.ExpiresAt) || !repository.CanTransition(t.Status, repository.StatusExpired) {
+		return
+	}
+	expired, err := s.repo.Expire(repository.WithActor(ctx, repository.SystemActor), t.ID)
+	if err != nil {
+		s.logger.Printf("failed to mark transfer expired id=%s: %v", t.ID, err)
+		return
+	}
+	t.Status = repository.StatusExpired
+	// only the request that actually expired it notifies
+	if expired {
+		s.notifyWebhooks(ctx, webhookTransferExpired, t)
+	}
+}
+
+// notifyDownload reports a download, and the one that used up max_downloads, to webhooks.
+func (s *Server) notifyDownload(ctx context.Context, t *repository.Transfer, count int) {
+	t.DownloadCount = count
+	s.notifyWebhooks(ctx, webhookTransferDownloaded, t)
+	if count >= t.MaxDownloads {
+		s.notifyWebhooks(ctx, webhookTransferLimitReached, t)
+	}
+}
+
+// writeTransitionError answers a rejected status change with 409 and a machine-readable
+// reason, e.g. {"error":"transition_not_allowed","from":"READY","to":"READY"}.
+func (s *Server) writeTransitionError(w http.ResponseWriter, err error) {
+	body := map[string]string{"error": repository.ReasonStateChanged}
+	var te *repository.TransitionError
+	if errors.As(err, &te) {
+		body = map[string]string{"error": te.Reason, "from": string(te.From), "to": string(te.To)}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
 func isExpired(expiresAt time.Time) bool {
 	if expiresAt.IsZero() {
 		return false
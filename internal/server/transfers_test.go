@@ -0,0 +1,512 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pavithrankb/weTransfer/internal/repository"
+)
+
+type transitionErrorBody struct {
+	Error  string `json:"error"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Status string `json:"status"`
+}
+
+func TestCreateTransfer(t *testing.T) {
+	ts := newTestServer(t)
+
+	var tr createTransferResponse
+	body := map[string]interface{}{"expires_at": time.Now().Add(time.Hour), "max_downloads": 2}
+	if code := ts.do(http.MethodPost, "/transfers", body, &tr); code != http.StatusCreated {
+		t.Fatalf("POST /transfers = %d, want 201", code)
+	}
+	if tr.ID == "" || tr.ShareToken == "" {
+		t.Errorf("response = %+v, want an id and a share token", tr)
+	}
+	if tr.Status != repository.StatusInit {
+		t.Errorf("status = %s, want INIT", tr.Status)
+	}
+
+	got, err := ts.s.repo.Get(t.Context(), tr.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.MaxDownloads != 2 || got.OwnerID == nil || *got.OwnerID != "alice" {
+		t.Errorf("stored transfer = %+v, want max_downloads 2 owned by alice", got)
+	}
+
+	for name, body := range map[string]map[string]interface{}{
+		"expired":         {"expires_at": time.Now().Add(-time.Minute)},
+		"no downloads":    {"expires_at": time.Now().Add(time.Hour), "max_downloads": 0},
+		"unknown field":   {"expires_at": time.Now().Add(time.Hour), "owner": "bob"},
+		"missing expires": {"max_downloads": 1},
+	} {
+		if code := ts.do(http.MethodPost, "/transfers", body, nil); code != http.StatusBadRequest {
+			t.Errorf("%s: POST /transfers = %d, want 400", name, code)
+		}
+	}
+}
+
+func TestUploadURL(t *testing.T) {
+	ts := newTestServer(t)
+	id, _ := ts.createTransfer()
+
+	f := ts.requestUpload(id, "notes.txt", "hello")
+	if want := "uploads/" + id + "/notes.txt"; f.ObjectKey != want {
+		t.Errorf("object_key = %q, want %q", f.ObjectKey, want)
+	}
+	if f.Headers["Content-Length"] != "5" {
+		t.Errorf("headers = %v, want the declared Content-Length", f.Headers)
+	}
+
+	// storage refuses a body of another content type or size than the one signed into the URL
+	for name, put := range map[string]struct{ body, contentType string }{
+		"content type": {"hello", "text/html"},
+		"size":         {"hello, world", "text/plain"},
+	} {
+		req, err := http.NewRequest(http.MethodPut, f.UploadURL, strings.NewReader(put.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", put.contentType)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("PUT with another %s = %d, want 403", name, resp.StatusCode)
+		}
+	}
+
+	for name, body := range map[string]map[string]interface{}{
+		"bad filename": {"filename": "../notes.txt"},
+		"duplicate":    {"files": []map[string]string{{"filename": "a.txt"}, {"filename": "a.txt"}}},
+		"both forms":   {"filename": "a.txt", "files": []map[string]string{{"filename": "b.txt"}}},
+	} {
+		if code := ts.do(http.MethodPost, "/transfers/"+id+"/upload-url", body, nil); code != http.StatusBadRequest {
+			t.Errorf("%s: upload-url = %d, want 400", name, code)
+		}
+	}
+
+	t.Setenv("MAX_UPLOAD_SIZE", "4")
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/upload-url", map[string]interface{}{"filename": "a.txt"}, nil); code != http.StatusBadRequest {
+		t.Errorf("upload-url without size = %d, want 400", code)
+	}
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/upload-url", map[string]interface{}{"filename": "a.txt", "size": 5}, nil); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("upload-url over MAX_UPLOAD_SIZE = %d, want 413", code)
+	}
+	// storage signs no length for size 0, so such a URL would take a body of any size
+	for _, mode := range []string{"put", "post"} {
+		body := map[string]interface{}{"filename": "a.txt", "content_type": "text/plain", "size": 0, "mode": mode}
+		if code := ts.do(http.MethodPost, "/transfers/"+id+"/upload-url", body, nil); code != http.StatusBadRequest {
+			t.Errorf("upload-url mode %s with size 0 under MAX_UPLOAD_SIZE = %d, want 400", mode, code)
+		}
+	}
+}
+
+func TestUploadURLEmptyFileWithoutLimit(t *testing.T) {
+	ts := newTestServer(t)
+	id, _ := ts.createTransfer()
+
+	// without a limit an empty declared size is no size at all: any body is accepted, which
+	// is why TestUploadURL expects size 0 to be refused once MAX_UPLOAD_SIZE is set
+	f := ts.requestUpload(id, "empty.txt", "")
+	if _, ok := f.Headers["Content-Length"]; ok {
+		t.Errorf("headers = %v, want no Content-Length for size 0", f.Headers)
+	}
+	if code, _ := ts.put(f.UploadURL, "text/plain", "hello", f.Headers); code != http.StatusOK {
+		t.Errorf("PUT to a size-0 URL without a limit = %d, want 200", code)
+	}
+}
+
+// postForm posts fields, then content as the file field, to a presigned form URL and
+// returns the status code.
+func (ts *testServer) postForm(url string, fields map[string]string, content string) int {
+	ts.t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		if err := mw.WriteField(k, v); err != nil {
+			ts.t.Fatal(err)
+		}
+	}
+	fw, err := mw.CreateFormFile("file", "upload")
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	io.WriteString(fw, content)
+	if err := mw.Close(); err != nil {
+		ts.t.Fatal(err)
+	}
+	resp, err := http.Post(url, mw.FormDataContentType(), &buf)
+	if err != nil {
+		ts.t.Fatalf("POST form: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestUploadURLPost(t *testing.T) {
+	ts := newTestServer(t)
+	id, _ := ts.createTransfer()
+
+	var up uploadURLResponse
+	req := map[string]interface{}{"filename": "notes.txt", "content_type": "text/plain", "size": 5, "mode": "post"}
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/upload-url", req, &up); code != http.StatusOK {
+		t.Fatalf("upload-url mode post = %d, want 200", code)
+	}
+	f := up.Files[0]
+	if f.Fields["key"] != f.ObjectKey || f.Fields["Content-Type"] != "text/plain" || f.Fields["policy"] == "" || f.Headers != nil {
+		t.Fatalf("form = %+v, want the key, content type and policy as fields", f)
+	}
+
+	for name, body := range map[string]map[string]interface{}{
+		"checksum":     {"filename": "a.txt", "mode": "post", "checksum_sha256": "LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ="},
+		"unknown mode": {"filename": "a.txt", "mode": "form"},
+	} {
+		if code := ts.do(http.MethodPost, "/transfers/"+id+"/upload-url", body, nil); code != http.StatusBadRequest {
+			t.Errorf("upload-url with %s = %d, want 400", name, code)
+		}
+	}
+
+	if code := ts.postForm(f.UploadURL, f.Fields, "hello, world"); code != http.StatusBadRequest {
+		t.Errorf("POST of more than the declared size = %d, want 400", code)
+	}
+	other := map[string]string{}
+	for k, v := range f.Fields {
+		other[k] = v
+	}
+	other["key"] = "uploads/" + id + "/other.txt"
+	if code := ts.postForm(f.UploadURL, other, "hello"); code != http.StatusForbidden {
+		t.Errorf("POST to another key = %d, want 403", code)
+	}
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/complete", nil, nil); code != http.StatusBadGateway {
+		t.Errorf("complete before the form was posted = %d, want 502", code)
+	}
+
+	if code := ts.postForm(f.UploadURL, f.Fields, "hello"); code != http.StatusNoContent {
+		t.Fatalf("POST = %d, want 204", code)
+	}
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/complete", nil, nil); code != http.StatusOK {
+		t.Errorf("complete = %d, want 200", code)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	ts := newTestServer(t)
+	id, _ := ts.createTransfer()
+
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/complete", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("complete without files = %d, want 400", code)
+	}
+
+	// a file that was never uploaded keeps the transfer INIT
+	ts.requestUpload(id, "missing.txt", "gone")
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/complete", nil, nil); code != http.StatusBadGateway {
+		t.Errorf("complete with a missing object = %d, want 502", code)
+	}
+	if got := ts.status(id); got != repository.StatusInit {
+		t.Fatalf("status = %s, want INIT", got)
+	}
+
+	id, _ = ts.createTransfer()
+	ts.upload(id, "a.txt", "hello")
+	ts.upload(id, "b.txt", "world!")
+	var resp struct {
+		Status   string `json:"status"`
+		FileSize int64  `json:"file_size"`
+		Files    []struct {
+			Filename string `json:"filename"`
+		} `json:"files"`
+	}
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/complete", nil, &resp); code != http.StatusOK {
+		t.Fatalf("complete = %d, want 200", code)
+	}
+	if resp.Status != string(repository.StatusReady) || resp.FileSize != 11 || len(resp.Files) != 2 {
+		t.Errorf("complete response = %+v, want READY with 2 files of 11 bytes", resp)
+	}
+	if got := ts.status(id); got != repository.StatusReady {
+		t.Errorf("status = %s, want READY", got)
+	}
+
+	// a retry returns the completed transfer
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/complete", nil, &resp); code != http.StatusOK {
+		t.Errorf("second complete = %d, want 200", code)
+	}
+	var conflict transitionErrorBody
+	body := map[string]interface{}{"files": []map[string]interface{}{{"file_id": "00000000-0000-0000-0000-000000000000"}}}
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/complete", body, &conflict); code != http.StatusConflict {
+		t.Errorf("second complete naming another file = %d, want 409", code)
+	}
+	if conflict.Error != repository.ReasonNotAllowed || conflict.From != "READY" {
+		t.Errorf("conflict = %+v, want transition_not_allowed from READY", conflict)
+	}
+}
+
+func TestCompletePolicyViolation(t *testing.T) {
+	ts := newTestServer(t)
+	id, _ := ts.createTransfer()
+	f := ts.upload(id, "notes.txt", "hello")
+
+	t.Setenv("DENIED_CONTENT_TYPES", "text/plain")
+	var resp struct {
+		Error string `json:"error"`
+		File  string `json:"file"`
+	}
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/complete", nil, &resp); code != http.StatusUnprocessableEntity {
+		t.Fatalf("complete = %d, want 422", code)
+	}
+	if resp.Error != "upload_policy_violation" || resp.File != "notes.txt" {
+		t.Errorf("response = %+v", resp)
+	}
+	if _, _, err := ts.store.HeadObject(t.Context(), testBucket, f.ObjectKey); err == nil {
+		t.Error("the violating object was kept")
+	}
+	if got := ts.status(id); got != repository.StatusInit {
+		t.Errorf("status = %s, want INIT", got)
+	}
+}
+
+func TestUploadChecksum(t *testing.T) {
+	ts := newTestServer(t)
+	id, _ := ts.createTransfer()
+
+	sum := sha256.Sum256([]byte("hello"))
+	declared := base64.StdEncoding.EncodeToString(sum[:])
+	var up uploadURLResponse
+	req := map[string]interface{}{"filename": "notes.txt", "content_type": "text/plain", "size": 5, "checksum_sha256": declared}
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/upload-url", req, &up); code != http.StatusOK {
+		t.Fatalf("upload-url = %d, want 200", code)
+	}
+	f := up.Files[0]
+	if f.Headers["x-amz-checksum-sha256"] != declared {
+		t.Fatalf("headers = %v, want the declared checksum", f.Headers)
+	}
+
+	// storage wants the signed checksum as a header, and a body matching it
+	if code, _ := ts.put(f.UploadURL, "text/plain", "hello", nil); code != http.StatusForbidden {
+		t.Errorf("PUT without the checksum header = %d, want 403", code)
+	}
+	if code, _ := ts.put(f.UploadURL, "text/plain", "jello", f.Headers); code != http.StatusBadRequest {
+		t.Errorf("PUT of another body = %d, want 400", code)
+	}
+	if code, _ := ts.put(f.UploadURL, "text/plain", "hello", f.Headers); code != http.StatusOK {
+		t.Fatalf("PUT = %d, want 200", code)
+	}
+
+	// an object replaced behind storage's back fails complete
+	if err := ts.store.PutObject(t.Context(), testBucket, f.ObjectKey, "text/plain", strings.NewReader("jello")); err != nil {
+		t.Fatalf("PutObject: %v", err)
+	}
+	var resp struct {
+		Error string `json:"error"`
+		File  string `json:"file"`
+	}
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/complete", nil, &resp); code != http.StatusUnprocessableEntity {
+		t.Fatalf("complete = %d, want 422", code)
+	}
+	if resp.Error != "checksum_mismatch" || resp.File != "notes.txt" {
+		t.Errorf("response = %+v, want checksum_mismatch for notes.txt", resp)
+	}
+	if got := ts.status(id); got != repository.StatusInit {
+		t.Errorf("status = %s, want INIT", got)
+	}
+}
+
+func TestRequireUploadChecksum(t *testing.T) {
+	ts := newTestServer(t)
+	id, _ := ts.createTransfer()
+	path := ts.createTus(id, "tus.txt", 5)
+	t.Setenv("REQUIRE_UPLOAD_CHECKSUM", "true")
+
+	for name, body := range map[string]map[string]interface{}{
+		"without checksum": {"filename": "a.txt"},
+		"mode post":        {"filename": "a.txt", "mode": "post"},
+	} {
+		if code := ts.do(http.MethodPost, "/transfers/"+id+"/upload-url", body, nil); code != http.StatusBadRequest {
+			t.Errorf("upload-url %s = %d, want 400", name, code)
+		}
+	}
+	if code, _ := ts.patchTus(path, 0, []byte("hello"), nil); code != http.StatusBadRequest {
+		t.Errorf("tus PATCH without Upload-Checksum = %d, want 400", code)
+	}
+	withSum := map[string]string{"Upload-Checksum": sha1Checksum([]byte("hello"))}
+	if code, _ := ts.patchTus(path, 0, []byte("hello"), withSum); code != http.StatusNoContent {
+		t.Errorf("tus PATCH with Upload-Checksum = %d, want 204", code)
+	}
+}
+
+func TestDownloadURL(t *testing.T) {
+	ts := newTestServer(t)
+	id, _ := ts.createTransfer()
+	ts.upload(id, "notes.txt", "hello")
+
+	if code := ts.do(http.MethodGet, "/transfers/"+id+"/download-url", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("download-url before complete = %d, want 400", code)
+	}
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/complete", nil, nil); code != http.StatusOK {
+		t.Fatalf("complete = %d", code)
+	}
+	if code := ts.do(http.MethodPatch, "/transfers/"+id+"/", map[string]interface{}{"max_downloads": 1}, nil); code != http.StatusOK {
+		t.Fatalf("PATCH max_downloads = %d", code)
+	}
+
+	var dl downloadURLResponse
+	if code := ts.do(http.MethodGet, "/transfers/"+id+"/download-url", nil, &dl); code != http.StatusOK {
+		t.Fatalf("download-url = %d, want 200", code)
+	}
+	resp, err := http.Get(dl.DownloadURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(data) != "hello" {
+		t.Errorf("GET download_url = %d %q, want 200 \"hello\"", resp.StatusCode, data)
+	}
+
+	var limit struct {
+		Error string `json:"error"`
+	}
+	if code := ts.do(http.MethodGet, "/transfers/"+id+"/download-url", nil, &limit); code != http.StatusGone {
+		t.Errorf("download-url past max_downloads = %d, want 410", code)
+	}
+	if limit.Error != "transfer_limit_reached" {
+		t.Errorf("error = %q, want transfer_limit_reached", limit.Error)
+	}
+}
+
+func TestIncrementDownloadRequiresReady(t *testing.T) {
+	ts := newTestServer(t)
+	id, _ := ts.createTransfer()
+	ts.upload(id, "notes.txt", "hello")
+	if _, err := ts.s.repo.IncrementDownload(t.Context(), id, "notes.txt"); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("IncrementDownload of an INIT transfer = %v, want ErrConflict", err)
+	}
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/complete", nil, nil); code != http.StatusOK {
+		t.Fatalf("complete = %d", code)
+	}
+	if n, err := ts.s.repo.IncrementDownload(t.Context(), id, "notes.txt"); err != nil || n != 1 {
+		t.Fatalf("IncrementDownload = %d, %v; want 1", n, err)
+	}
+
+	// a download checked before the transfer expired isn't counted after it
+	if _, err := ts.s.repo.Expire(t.Context(), id); err != nil {
+		t.Fatalf("Expire: %v", err)
+	}
+	if _, err := ts.s.repo.IncrementDownload(t.Context(), id, "notes.txt"); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("IncrementDownload of an EXPIRED transfer = %v, want ErrConflict", err)
+	}
+	tr, err := ts.s.repo.Get(t.Context(), id)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if tr.DownloadCount != 1 {
+		t.Errorf("download_count = %d, want 1", tr.DownloadCount)
+	}
+}
+
+func TestUpdateTransfer(t *testing.T) {
+	ts := newTestServer(t)
+	id, _ := ts.createTransfer()
+
+	var body transitionErrorBody
+	if code := ts.do(http.MethodPatch, "/transfers/"+id+"/", map[string]interface{}{"max_downloads": 5}, &body); code != http.StatusConflict {
+		t.Errorf("PATCH INIT = %d, want 409", code)
+	}
+	if body.Error != "not_updatable" || body.Status != "INIT" {
+		t.Errorf("PATCH INIT = %+v, want not_updatable", body)
+	}
+
+	ts.upload(id, "notes.txt", "hello")
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/complete", nil, nil); code != http.StatusOK {
+		t.Fatalf("complete = %d", code)
+	}
+
+	if code := ts.do(http.MethodPatch, "/transfers/"+id+"/", map[string]interface{}{"status": "DELETED"}, nil); code != http.StatusBadRequest {
+		t.Errorf("PATCH status DELETED = %d, want 400", code)
+	}
+	body = transitionErrorBody{}
+	if code := ts.do(http.MethodPatch, "/transfers/"+id+"/", map[string]interface{}{"status": "READY"}, &body); code != http.StatusConflict {
+		t.Errorf("PATCH READY -> READY = %d, want 409", code)
+	}
+	if body.Error != repository.ReasonNotAllowed || body.From != "READY" || body.To != "READY" {
+		t.Errorf("PATCH READY -> READY = %+v", body)
+	}
+	if code := ts.do(http.MethodPatch, "/transfers/"+id+"/", map[string]interface{}{"status": "EXPIRED"}, nil); code != http.StatusOK {
+		t.Errorf("PATCH READY -> EXPIRED = %d, want 200", code)
+	}
+	if got := ts.status(id); got != repository.StatusExpired {
+		t.Fatalf("status = %s, want EXPIRED", got)
+	}
+
+	past := map[string]interface{}{"expires_at": time.Now().Add(-time.Minute)}
+	if code := ts.do(http.MethodPatch, "/transfers/"+id+"/", past, nil); code != http.StatusBadRequest {
+		t.Errorf("PATCH with a past expires_at = %d, want 400", code)
+	}
+
+	// a new expiry revives the transfer
+	future := map[string]interface{}{"expires_at": time.Now().Add(2 * time.Hour)}
+	if code := ts.do(http.MethodPatch, "/transfers/"+id+"/", future, nil); code != http.StatusOK {
+		t.Errorf("PATCH expires_at on EXPIRED = %d, want 200", code)
+	}
+	if got := ts.status(id); got != repository.StatusReady {
+		t.Errorf("status = %s, want READY", got)
+	}
+}
+
+func TestRunCleanup(t *testing.T) {
+	ts := newTestServer(t)
+
+	expired, _ := ts.createTransfer()
+	f := ts.upload(expired, "old.txt", "hello")
+	if code := ts.do(http.MethodPost, "/transfers/"+expired+"/complete", nil, nil); code != http.StatusOK {
+		t.Fatalf("complete = %d", code)
+	}
+	if code := ts.do(http.MethodPatch, "/transfers/"+expired+"/", map[string]interface{}{"status": "EXPIRED"}, nil); code != http.StatusOK {
+		t.Fatalf("PATCH EXPIRED = %d", code)
+	}
+
+	ready, _ := ts.createTransfer()
+	g := ts.upload(ready, "new.txt", "world")
+	if code := ts.do(http.MethodPost, "/transfers/"+ready+"/complete", nil, nil); code != http.StatusOK {
+		t.Fatalf("complete = %d", code)
+	}
+
+	ts.s.RunCleanup()
+
+	if got := ts.status(expired); got != repository.StatusDeleted {
+		t.Errorf("expired transfer status = %s, want DELETED", got)
+	}
+	if _, _, err := ts.store.HeadObject(t.Context(), testBucket, f.ObjectKey); err == nil {
+		t.Error("object of the expired transfer was kept")
+	}
+	files, err := ts.s.repo.ListFiles(t.Context(), expired)
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("expired transfer still has %d files", len(files))
+	}
+
+	if got := ts.status(ready); got != repository.StatusReady {
+		t.Errorf("ready transfer status = %s, want READY", got)
+	}
+	if _, _, err := ts.store.HeadObject(t.Context(), testBucket, g.ObjectKey); err != nil {
+		t.Errorf("object of the ready transfer: %v", err)
+	}
+}
@@ -0,0 +1,636 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pavithrankb/weTransfer/internal/repository"
+	"github.com/pavithrankb/weTransfer/internal/storage"
+)
+
+// Resumable uploads speak tus 1.0 (https://tus.io/protocols/resumable-upload) with the
+// creation, termination and checksum extensions. Each upload is a file of the manifest
+// backed by a multipart upload; PATCH bodies are cut into parts as they arrive and the
+// bytes that don't fill a part yet are staged in a tail object until the next PATCH.
+const (
+	tusVersion            = "1.0.0"
+	tusExtensions         = "creation,termination,checksum"
+	tusChecksumAlgorithms = "sha1,sha256,md5"
+
+	// statusChecksumMismatch is the status tus defines for a PATCH whose Upload-Checksum
+	// doesn't match the body.
+	statusChecksumMismatch = 460
+
+	// tusUploadLease is how long a PATCH holds its file before another may take over; a
+	// PATCH still receiving its body renews the lease every third of it.
+	tusUploadLease = time.Minute
+)
+
+// tusRouter dispatches /transfers/{id}/tus[/{file_id}] requests.
+// sub is the remainder of the path after "tus".
+func (s *Server) tusRouter(w http.ResponseWriter, r *http.Request, id, sub string) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	// clients behind proxies that drop PATCH and DELETE tunnel them through POST
+	if m := r.Header.Get("X-HTTP-Method-Override"); m != "" && r.Method == http.MethodPost {
+		r.Method = m
+	}
+
+	if r.Method == http.MethodOptions {
+		s.tusOptionsHandler(w, r, id)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+
+	fileID := strings.TrimPrefix(sub, "/")
+	if fileID == "" {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST, OPTIONS")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.tusCreateHandler(w, r, id)
+		return
+	}
+	if strings.Contains(fileID, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead:
+		s.tusHeadHandler(w, r, id, fileID)
+	case http.MethodPatch:
+		s.tusPatchHandler(w, r, id, fileID)
+	case http.MethodDelete:
+		s.tusTerminateHandler(w, r, id, fileID)
+	default:
+		w.Header().Set("Allow", "HEAD, PATCH, DELETE, OPTIONS")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// tusOptionsHandler advertises the protocol version, extensions and size limit.
+// OPTIONS /transfers/{id}/tus
+func (s *Server) tusOptionsHandler(w http.ResponseWriter, r *http.Request, id string) {
+	t := s.loadTransfer(w, r, id, "tus")
+	if t == nil {
+		return
+	}
+
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Checksum-Algorithm", tusChecksumAlgorithms)
+	if max := s.uploadPolicy(t).MaxFileSize; max > 0 {
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(max, 10))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tusCreateHandler adds a file to the manifest of an INIT transfer and starts a resumable
+// upload for it. Upload-Metadata carries the filename and, optionally, the filetype.
+// Creating a file again discards its previous upload.
+// POST /transfers/{id}/tus
+func (s *Server) tusCreateHandler(w http.ResponseWriter, r *http.Request, id string) {
+	mp, ok := s.store.(storage.MultipartStore)
+	if !ok {
+		http.Error(w, "resumable uploads are not supported by this storage backend", http.StatusNotImplemented)
+		return
+	}
+
+	// Upload-Defer-Length is not supported, so the length is required
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	meta, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		s.logger.Printf("tus: invalid metadata: %v", err)
+		http.Error(w, "invalid Upload-Metadata", http.StatusBadRequest)
+		return
+	}
+	filename, contentType := meta["filename"], meta["filetype"]
+	if !validFilename(filename) {
+		s.logger.Printf("tus: invalid filename: %q", filename)
+		http.Error(w, "invalid filename", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	t := s.loadTransfer(w, r, id, "tus")
+	if t == nil {
+		return
+	}
+
+	if t.Status != repository.StatusInit {
+		s.logger.Printf("tus: transfer not in INIT state id=%s status=%s", id, t.Status)
+		http.Error(w, "transfer not in INIT state", http.StatusBadRequest)
+		return
+	}
+
+	policy := s.uploadPolicy(t)
+	if err := policy.checkContentType(contentType); err != nil {
+		s.logger.Printf("tus: rejected by upload policy id=%s filename=%q: %v", id, filename, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := policy.checkSize(length); err != nil {
+		s.logger.Printf("tus: rejected by upload policy id=%s filename=%q: %v", id, filename, err)
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		s.logger.Printf("tus: s3 bucket not configured")
+		http.Error(w, "s3 bucket not configured", http.StatusInternalServerError)
+		return
+	}
+
+	files, err := s.repo.ListFiles(ctx, id)
+	if err != nil {
+		s.logger.Printf("tus: failed to load files id=%s: %v", id, err)
+		http.Error(w, "failed to fetch transfer", http.StatusInternalServerError)
+		return
+	}
+	var prev *repository.File
+	for i := range files {
+		if files[i].Filename == filename {
+			prev = &files[i]
+		}
+	}
+	if prev == nil && len(files) >= maxFilesPerTransfer {
+		http.Error(w, fmt.Sprintf("at most %d files per transfer", maxFilesPerTransfer), http.StatusBadRequest)
+		return
+	}
+
+	objectKey := objectKeyFor(id, filename)
+	offset := int64(0)
+	row := repository.File{Filename: filename, ContentType: contentType, ObjectKey: objectKey, UploadLength: &length, UploadOffset: &offset}
+	if length == 0 {
+		// S3 can't assemble an upload without parts; an empty file is complete right away
+		if err := s.store.PutObject(ctx, bucket, objectKey, contentType, bytes.NewReader(nil)); err != nil {
+			s.logger.Printf("tus: failed to store empty object id=%s key=%s: %v", id, objectKey, err)
+			http.Error(w, "failed to start resumable upload", http.StatusBadGateway)
+			return
+		}
+	} else {
+		uploadID, err := mp.CreateMultipartUpload(ctx, bucket, objectKey, contentType, false)
+		if err != nil {
+			s.logger.Printf("tus: failed to create upload id=%s key=%s: %v", id, objectKey, err)
+			http.Error(w, "failed to start resumable upload", http.StatusBadGateway)
+			return
+		}
+		row.UploadID = &uploadID
+	}
+
+	rows := []repository.File{row}
+	if err := s.repo.PutFiles(ctx, id, rows); err != nil {
+		s.logger.Printf("tus: failed to upsert file id=%s filename=%q: %v", id, filename, err)
+		http.Error(w, "failed to update transfer", http.StatusInternalServerError)
+		return
+	}
+
+	// a restarted upload replaces the previous one; don't leave its parts behind
+	if prev != nil {
+		s.abortMultipart(ctx, bucket, *prev)
+	}
+
+	fileID := rows[0].ID
+	s.logger.Printf("tus upload created id=%s file_id=%s key=%s length=%d", id, fileID, objectKey, length)
+
+	w.Header().Set("Location", fmt.Sprintf("/transfers/%s/tus/%s", id, fileID))
+	if length == 0 {
+		if err := s.completeResumable(ctx, t); err != nil {
+			s.writeCompleteError(w, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// tusHeadHandler reports how many bytes of a resumable upload have arrived, so the client
+// knows where to resume. It keeps answering once the transfer is complete.
+// HEAD /transfers/{id}/tus/{file_id}
+func (s *Server) tusHeadHandler(w http.ResponseWriter, r *http.Request, id, fileID string) {
+	_, file, ok := s.loadTusFile(w, r, id, fileID)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(*file.UploadOffset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(*file.UploadLength, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+// tusPatchHandler appends the body to a resumable upload at Upload-Offset. Bytes that
+// arrived before the connection dropped are kept, unless an Upload-Checksum covers the
+// whole body. The PATCH that completes the last file of an all-tus transfer completes the
+// transfer too.
+// PATCH /transfers/{id}/tus/{file_id}
+func (s *Server) tusPatchHandler(w http.ResponseWriter, r *http.Request, id, fileID string) {
+	mp, ok := s.store.(storage.MultipartStore)
+	if !ok {
+		http.Error(w, "resumable uploads are not supported by this storage backend", http.StatusNotImplemented)
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+	var sum *tusChecksum
+	if v := r.Header.Get("Upload-Checksum"); v != "" {
+		if sum, err = parseTusChecksum(v); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if checksumRequired() {
+		// every PATCH covered by a checksum covers every byte of the upload
+		http.Error(w, "Upload-Checksum is required", http.StatusBadRequest)
+		return
+	}
+
+	t, file, ok := s.loadTusFile(w, r, id, fileID)
+	if !ok {
+		return
+	}
+	if t.Status != repository.StatusInit {
+		s.logger.Printf("tus: transfer not in INIT state id=%s status=%s", id, t.Status)
+		http.Error(w, "transfer not in INIT state", http.StatusBadRequest)
+		return
+	}
+	if offset != *file.UploadOffset {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(*file.UploadOffset, 10))
+		http.Error(w, "Upload-Offset does not match the upload", http.StatusConflict)
+		return
+	}
+	length := *file.UploadLength
+	if offset == length {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		s.logger.Printf("tus: s3 bucket not configured")
+		http.Error(w, "s3 bucket not configured", http.StatusInternalServerError)
+		return
+	}
+
+	// two PATCHes writing the same parts would corrupt each other, whichever instance
+	// they reach, so the offset is claimed in the database before any part is written
+	lease, err := randomToken(16)
+	if err != nil {
+		s.logger.Printf("tus: failed to generate lease id=%s: %v", id, err)
+		http.Error(w, "failed to store upload", http.StatusInternalServerError)
+		return
+	}
+	if err := s.repo.ClaimUpload(r.Context(), file.ID, lease, offset, tusUploadLease); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrConflict):
+			http.Error(w, "Upload-Offset does not match the upload", http.StatusConflict)
+		case errors.Is(err, repository.ErrLocked):
+			http.Error(w, "upload is locked by another request", http.StatusLocked)
+		default:
+			s.logger.Printf("tus: failed to claim upload id=%s file_id=%s: %v", id, file.ID, err)
+			http.Error(w, "failed to update transfer", http.StatusInternalServerError)
+		}
+		return
+	}
+	stopRenewing := s.renewUploadLease(file.ID, lease, offset)
+	defer func() {
+		stopRenewing()
+		// a no-op once AdvanceUpload has released the lease
+		if err := s.repo.ReleaseUpload(context.Background(), file.ID, lease); err != nil {
+			s.logger.Printf("tus: failed to release upload id=%s file_id=%s: %v", id, file.ID, err)
+		}
+	}()
+
+	// a large body takes longer to arrive than the server's ReadTimeout and WriteTimeout allow
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil && s.debug {
+		s.logger.Printf("tus: unable to clear read deadline: %v", err)
+	}
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && s.debug {
+		s.logger.Printf("tus: unable to clear write deadline: %v", err)
+	}
+
+	// what arrived before a dropped connection is still stored, so the work below must
+	// outlive the request
+	ctx := context.WithoutCancel(r.Context())
+
+	spool, err := os.CreateTemp("", "tus-*")
+	if err != nil {
+		s.logger.Printf("tus: failed to create spool file id=%s: %v", id, err)
+		http.Error(w, "failed to store upload", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	// the spool starts at the first byte not yet in a part: the staged tail, then the body
+	partSize := partSizeFor(length)
+	tailLen := offset % partSize
+	if tailLen > 0 {
+		tail, err := s.store.GetObject(ctx, bucket, tusTailKey(file, offset))
+		if err == nil {
+			_, err = io.CopyN(spool, tail, tailLen)
+			tail.Close()
+		}
+		if err != nil {
+			s.logger.Printf("tus: failed to read staged tail id=%s file_id=%s: %v", id, file.ID, err)
+			http.Error(w, "failed to read staged upload", http.StatusBadGateway)
+			return
+		}
+	}
+
+	remaining := length - offset
+	body := io.Writer(spool)
+	if sum != nil {
+		body = io.MultiWriter(spool, sum.hash)
+	}
+	n, readErr := io.Copy(body, io.LimitReader(r.Body, remaining+1))
+	if n > remaining {
+		http.Error(w, "body exceeds Upload-Length", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if readErr != nil {
+		s.logger.Printf("tus: body cut short id=%s file_id=%s received=%d: %v", id, file.ID, n, readErr)
+		if sum != nil {
+			http.Error(w, "incomplete body", http.StatusBadRequest)
+			return
+		}
+	}
+	if sum != nil && !sum.matches() {
+		s.logger.Printf("tus: checksum mismatch id=%s file_id=%s algorithm=%s", id, file.ID, sum.algorithm)
+		http.Error(w, "checksum mismatch", statusChecksumMismatch)
+		return
+	}
+
+	// store every full part; only the last part of the upload may be shorter
+	newOffset := offset + n
+	done := newOffset == length
+	total := tailLen + n
+	part := int32(offset/partSize) + 1
+	var pos int64
+	for total-pos >= partSize || (done && pos < total) {
+		size := min(partSize, total-pos)
+		if _, err := mp.UploadPart(ctx, bucket, file.ObjectKey, *file.UploadID, part, io.NewSectionReader(spool, pos, size), size); err != nil {
+			s.logger.Printf("tus: failed to upload part id=%s file_id=%s part=%d: %v", id, file.ID, part, err)
+			http.Error(w, "failed to store upload", http.StatusBadGateway)
+			return
+		}
+		pos += size
+		part++
+	}
+	if !done && pos < total {
+		if err := s.store.PutObject(ctx, bucket, tusTailKey(file, newOffset), file.ContentType, io.NewSectionReader(spool, pos, total-pos)); err != nil {
+			s.logger.Printf("tus: failed to stage tail id=%s file_id=%s: %v", id, file.ID, err)
+			http.Error(w, "failed to store upload", http.StatusBadGateway)
+			return
+		}
+	}
+
+	if done {
+		parts, err := mp.ListParts(ctx, bucket, file.ObjectKey, *file.UploadID)
+		if err == nil {
+			err = mp.CompleteMultipartUpload(ctx, bucket, file.ObjectKey, *file.UploadID, parts)
+		}
+		if err != nil {
+			s.logger.Printf("tus: failed to assemble upload id=%s file_id=%s: %v", id, file.ID, err)
+			http.Error(w, "failed to store upload", http.StatusBadGateway)
+			return
+		}
+	}
+
+	stopRenewing()
+	if err := s.repo.AdvanceUpload(ctx, file.ID, lease, offset, newOffset, done); err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			s.logger.Printf("tus: upload lease lost id=%s file_id=%s", id, file.ID)
+			http.Error(w, "Upload-Offset does not match the upload", http.StatusConflict)
+			return
+		}
+		s.logger.Printf("tus: failed to advance upload id=%s file_id=%s: %v", id, file.ID, err)
+		http.Error(w, "failed to update transfer", http.StatusInternalServerError)
+		return
+	}
+
+	// the tail read above now lives in a part (or a newer tail)
+	if tailLen > 0 && (done || tusTailKey(file, newOffset) != tusTailKey(file, offset)) {
+		if err := s.store.DeleteObject(ctx, bucket, tusTailKey(file, offset)); err != nil {
+			s.logger.Printf("tus: failed to delete staged tail id=%s file_id=%s: %v", id, file.ID, err)
+		}
+	}
+
+	if s.debug {
+		s.logger.Printf("tus: patched id=%s file_id=%s offset=%d length=%d", id, file.ID, newOffset, length)
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	if done {
+		s.logger.Printf("tus upload finished id=%s file_id=%s size=%d", id, file.ID, length)
+		// the bytes are stored either way; tell the client why the transfer isn't complete
+		if err := s.completeResumable(ctx, t); err != nil {
+			s.writeCompleteError(w, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// renewUploadLease extends the lease on fileID at offset until the returned stop is
+// called; stop may be called more than once. A renewal that fails is logged and left to
+// AdvanceUpload, which refuses a lease that was taken over.
+func (s *Server) renewUploadLease(fileID, lease string, offset int64) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(tusUploadLease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.repo.ClaimUpload(ctx, fileID, lease, offset, tusUploadLease); err != nil && ctx.Err() == nil {
+					s.logger.Printf("tus: failed to renew upload lease file_id=%s: %v", fileID, err)
+				}
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-stopped
+	}
+}
+
+// tusTerminateHandler discards a resumable upload, finished or not, and removes the file
+// from the manifest.
+// DELETE /transfers/{id}/tus/{file_id}
+func (s *Server) tusTerminateHandler(w http.ResponseWriter, r *http.Request, id, fileID string) {
+	t, file, ok := s.loadTusFile(w, r, id, fileID)
+	if !ok {
+		return
+	}
+	if t.Status != repository.StatusInit {
+		s.logger.Printf("tus: transfer not in INIT state id=%s status=%s", id, t.Status)
+		http.Error(w, "transfer not in INIT state", http.StatusBadRequest)
+		return
+	}
+
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		s.logger.Printf("tus: s3 bucket not configured")
+		http.Error(w, "s3 bucket not configured", http.StatusInternalServerError)
+		return
+	}
+
+	ctx := r.Context()
+	if file.UploadID != nil {
+		s.abortMultipart(ctx, bucket, file)
+	} else if err := s.store.DeleteObject(ctx, bucket, file.ObjectKey); err != nil {
+		s.logger.Printf("tus: failed to delete object id=%s key=%s: %v", id, file.ObjectKey, err)
+		http.Error(w, "failed to delete upload", http.StatusBadGateway)
+		return
+	}
+
+	if err := s.repo.DeleteFile(ctx, file.ID, file.UploadID); err != nil {
+		s.logger.Printf("tus: failed to remove file id=%s file_id=%s: %v", id, file.ID, err)
+		http.Error(w, "failed to update transfer", http.StatusInternalServerError)
+		return
+	}
+
+	s.logger.Printf("tus upload terminated id=%s file_id=%s", id, file.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadTusFile fetches the transfer and a file of it that was uploaded through tus.
+// It writes the error response itself and returns ok=false when the request cannot proceed.
+func (s *Server) loadTusFile(w http.ResponseWriter, r *http.Request, id, fileID string) (*repository.Transfer, repository.File, bool) {
+	t := s.loadTransfer(w, r, id, "tus")
+	if t == nil {
+		return nil, repository.File{}, false
+	}
+
+	files, err := s.repo.ListFiles(r.Context(), id)
+	if err != nil {
+		s.logger.Printf("tus: failed to load files id=%s: %v", id, err)
+		http.Error(w, "failed to fetch transfer", http.StatusInternalServerError)
+		return nil, repository.File{}, false
+	}
+	file, err := findFile(files, fileID)
+	if err != nil || file.UploadLength == nil || file.UploadOffset == nil {
+		http.NotFound(w, r)
+		return nil, repository.File{}, false
+	}
+	return t, file, true
+}
+
+// completeResumable completes t the way POST /complete does once every file in it was
+// uploaded through tus and has fully arrived. A manifest that mixes in other uploads waits
+// for the client's /complete, since only the client knows when those are done. Errors are
+// a *completeError; a transfer completed concurrently, e.g. by the PATCH of another file,
+// is not one.
+func (s *Server) completeResumable(ctx context.Context, t *repository.Transfer) error {
+	files, err := s.repo.ListFiles(ctx, t.ID)
+	if err != nil {
+		s.logger.Printf("tus: failed to load files id=%s: %v", t.ID, err)
+		return &completeError{Status: http.StatusInternalServerError, Detail: "failed to fetch transfer"}
+	}
+	for _, f := range files {
+		if f.UploadLength == nil || f.UploadOffset == nil || *f.UploadOffset < *f.UploadLength {
+			return nil
+		}
+	}
+	_, err = s.completeTransfer(ctx, t, "tus", nil)
+	var te *repository.TransitionError
+	if errors.Is(err, repository.ErrConflict) || errors.As(err, &te) {
+		return nil
+	}
+	return err
+}
+
+// tusTailKey is where the bytes of f past its last full part wait for the next PATCH
+// when the upload is at offset. Each part gets its own key, so a tail staged by a PATCH
+// that failed afterwards never overwrites the one the offset still refers to.
+func tusTailKey(f repository.File, offset int64) string {
+	return fmt.Sprintf("tus/%s/%s/%d", f.TransferID, f.ID, offset/partSizeFor(*f.UploadLength)+1)
+}
+
+// parseTusMetadata decodes Upload-Metadata: comma-separated "key base64value" pairs,
+// where the value may be omitted.
+func parseTusMetadata(v string) (map[string]string, error) {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(v, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, enc, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(enc))
+		if err != nil {
+			return nil, fmt.Errorf("metadata %q: %w", key, err)
+		}
+		meta[key] = string(value)
+	}
+	return meta, nil
+}
+
+// tusChecksum is a parsed Upload-Checksum header and the hash of the body it covers.
+type tusChecksum struct {
+	algorithm string
+	want      []byte
+	hash      hash.Hash
+}
+
+// parseTusChecksum parses "<algorithm> <base64 digest>".
+func parseTusChecksum(v string) (*tusChecksum, error) {
+	algorithm, enc, _ := strings.Cut(v, " ")
+	want, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		return nil, errors.New("invalid Upload-Checksum")
+	}
+	var h hash.Hash
+	switch algorithm {
+	case "sha1":
+		h = sha1.New()
+	case "sha256":
+		h = sha256.New()
+	case "md5":
+		h = md5.New()
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+	}
+	return &tusChecksum{algorithm: algorithm, want: want, hash: h}, nil
+}
+
+func (c *tusChecksum) matches() bool {
+	return bytes.Equal(c.hash.Sum(nil), c.want)
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pavithrankb/weTransfer/internal/repository"
+)
+
+// tus sends a tus request to path with the given headers and returns the status code and
+// response headers.
+func (ts *testServer) tus(method, path string, body []byte, header map[string]string) (int, http.Header) {
+	ts.t.Helper()
+	req, err := http.NewRequest(method, ts.url+path, bytes.NewReader(body))
+	if err != nil {
+		ts.t.Fatalf("new request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+ts.key)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	if method == http.MethodPatch {
+		req.Header.Set("Content-Type", "application/offset+octet-stream")
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		ts.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode, resp.Header
+}
+
+// createTus starts a resumable upload of length bytes named filename and returns its path.
+func (ts *testServer) createTus(id, filename string, length int) string {
+	ts.t.Helper()
+	code, h := ts.tus(http.MethodPost, "/transfers/"+id+"/tus", nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(length),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte(filename)),
+	})
+	if code != http.StatusCreated {
+		ts.t.Fatalf("POST tus = %d, want 201", code)
+	}
+	return h.Get("Location")
+}
+
+// patchTus appends data at offset and returns the status code and the Upload-Offset header.
+func (ts *testServer) patchTus(path string, offset int, data []byte, header map[string]string) (int, string) {
+	ts.t.Helper()
+	h := map[string]string{"Upload-Offset": strconv.Itoa(offset)}
+	for k, v := range header {
+		h[k] = v
+	}
+	code, resp := ts.tus(http.MethodPatch, path, data, h)
+	return code, resp.Get("Upload-Offset")
+}
+
+// tusOffset returns the Upload-Offset a HEAD reports for the upload at path.
+func (ts *testServer) tusOffset(path string) string {
+	ts.t.Helper()
+	code, h := ts.tus(http.MethodHead, path, nil, nil)
+	if code != http.StatusOK {
+		ts.t.Fatalf("HEAD tus = %d, want 200", code)
+	}
+	return h.Get("Upload-Offset")
+}
+
+func sha1Checksum(data []byte) string {
+	sum := sha1.Sum(data)
+	return "sha1 " + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestTusUpload(t *testing.T) {
+	ts := newTestServer(t)
+	id, _ := ts.createTransfer()
+
+	// the upload spans two parts, and neither PATCH ends on a part boundary
+	partSize := partSizeFor(0)
+	data := bytes.Repeat([]byte("0123456789"), int(partSize/10)+1)
+	first, second := data[:partSize/2], data[partSize/2:]
+	path := ts.createTus(id, "notes.txt", len(data))
+
+	if code, off := ts.patchTus(path, 0, first, nil); code != http.StatusNoContent || off != strconv.Itoa(len(first)) {
+		t.Fatalf("first PATCH = %d offset %s, want 204 offset %d", code, off, len(first))
+	}
+	// the client lost the response and resumes from the offset the server reports
+	if off := ts.tusOffset(path); off != strconv.Itoa(len(first)) {
+		t.Fatalf("HEAD offset = %s, want %d", off, len(first))
+	}
+	if code, off := ts.patchTus(path, 0, first, nil); code != http.StatusConflict || off != strconv.Itoa(len(first)) {
+		t.Errorf("PATCH at a stale offset = %d offset %s, want 409 offset %d", code, off, len(first))
+	}
+
+	bad := map[string]string{"Upload-Checksum": sha1Checksum([]byte("something else"))}
+	if code, _ := ts.patchTus(path, len(first), second, bad); code != statusChecksumMismatch {
+		t.Errorf("PATCH with a wrong checksum = %d, want 460", code)
+	}
+	if off := ts.tusOffset(path); off != strconv.Itoa(len(first)) {
+		t.Errorf("offset after a checksum mismatch = %s, want %d", off, len(first))
+	}
+	if got := ts.status(id); got != repository.StatusInit {
+		t.Fatalf("status before the final PATCH = %s, want INIT", got)
+	}
+
+	good := map[string]string{"Upload-Checksum": sha1Checksum(second)}
+	if code, off := ts.patchTus(path, len(first), second, good); code != http.StatusNoContent || off != strconv.Itoa(len(data)) {
+		t.Fatalf("final PATCH = %d offset %s, want 204 offset %d", code, off, len(data))
+	}
+	// the final PATCH of an all-tus transfer completes it
+	if got := ts.status(id); got != repository.StatusReady {
+		t.Errorf("status = %s, want READY", got)
+	}
+	obj, err := ts.store.GetObject(t.Context(), testBucket, objectKeyFor(id, "notes.txt"))
+	if err != nil {
+		t.Fatalf("GetObject: %v", err)
+	}
+	stored, err := io.ReadAll(obj)
+	obj.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, data) {
+		t.Errorf("stored object is %d bytes, want the %d uploaded", len(stored), len(data))
+	}
+}
+
+func TestTusPatchLease(t *testing.T) {
+	ts := newTestServer(t)
+	id, _ := ts.createTransfer()
+	path := ts.createTus(id, "notes.txt", 11)
+	fileID := path[strings.LastIndex(path, "/")+1:]
+
+	// another instance is writing the upload
+	if err := ts.s.repo.ClaimUpload(t.Context(), fileID, "other", 0, time.Minute); err != nil {
+		t.Fatalf("ClaimUpload: %v", err)
+	}
+	if code, _ := ts.patchTus(path, 0, []byte("hello"), nil); code != http.StatusLocked {
+		t.Errorf("PATCH while leased = %d, want 423", code)
+	}
+	if off := ts.tusOffset(path); off != "0" {
+		t.Errorf("offset after a locked PATCH = %s, want 0", off)
+	}
+
+	// its lease lapses, e.g. because the instance crashed
+	if err := ts.s.repo.ClaimUpload(t.Context(), fileID, "other", 0, 0); err != nil {
+		t.Fatalf("ClaimUpload: %v", err)
+	}
+	if code, off := ts.patchTus(path, 0, []byte("hello"), nil); code != http.StatusNoContent || off != "5" {
+		t.Fatalf("PATCH after the lease expired = %d offset %s, want 204 offset 5", code, off)
+	}
+	// the PATCH released its own lease, and the other instance can't claim the old offset
+	if err := ts.s.repo.ClaimUpload(t.Context(), fileID, "other", 0, time.Minute); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("ClaimUpload at the old offset = %v, want ErrConflict", err)
+	}
+	if code, off := ts.patchTus(path, 5, []byte(" world"), nil); code != http.StatusNoContent || off != "11" {
+		t.Errorf("final PATCH = %d offset %s, want 204 offset 11", code, off)
+	}
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pavithrankb/weTransfer/internal/repository"
+	"github.com/pavithrankb/weTransfer/internal/storage"
+)
+
+const (
+	// uploadEventRetry is how long a message waits before it is retried after a failure;
+	// it matches the visibility timeout the consumer receives with.
+	uploadEventRetry = 60 * time.Second
+	// maxUploadEventWait is the longest SQS lets a received message stay hidden.
+	maxUploadEventWait     = 12 * time.Hour
+	defaultUploadEventIdle = 15 * time.Minute
+)
+
+// uploadEventIdle is how long a transfer must go without new uploads before an event may
+// complete it (UPLOAD_EVENTS_IDLE), so a client still adding files isn't cut short.
+func uploadEventIdle() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("UPLOAD_EVENTS_IDLE")); err == nil && d >= 0 {
+		return d
+	}
+	return defaultUploadEventIdle
+}
+
+// ConsumeUploadEvents completes transfers from the S3 ObjectCreated notifications on queue
+// (UPLOAD_EVENTS_QUEUE_URL), so a transfer whose client uploaded its files but never called
+// /complete still becomes READY. It is designed to run in a goroutine and returns when ctx
+// is done or the queue is unreachable.
+func (s *Server) ConsumeUploadEvents(ctx context.Context, consumer storage.Consumer, queue string) {
+	if err := consumer.CheckQueue(ctx, queue); err != nil {
+		s.logger.Printf("upload-events: failed to access queue %s: %v (consumer disabled)", queue, err)
+		return
+	}
+	s.logger.Printf("upload-events: polling queue %s", queue)
+
+	for {
+		// wait 20s (long polling), give each batch 60s before it is redelivered
+		msgs, err := consumer.Receive(ctx, queue, 10, 20*time.Second, 60*time.Second)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			s.logger.Printf("upload-events: failed to receive messages: %v", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		for _, m := range msgs {
+			if wait := s.handleUploadEvent(ctx, m); wait > 0 {
+				// hidden until it is worth retrying, then redelivered
+				if err := consumer.ChangeVisibility(ctx, queue, m.ReceiptHandle, min(wait, maxUploadEventWait)); err != nil {
+					s.logger.Printf("upload-events: failed to delay message %s: %v", m.ID, err)
+				}
+				continue
+			}
+			if err := consumer.Delete(ctx, queue, m.ReceiptHandle); err != nil {
+				s.logger.Printf("upload-events: failed to delete message %s: %v", m.ID, err)
+			}
+		}
+	}
+}
+
+// handleUploadEvent completes the transfers the objects of one notification belong to and
+// returns how long to wait before retrying the message, or 0 once it is done with. Events
+// for other buckets or keys, and messages that aren't S3 notifications, are dropped.
+func (s *Server) handleUploadEvent(ctx context.Context, m storage.Message) time.Duration {
+	e, err := storage.ParseS3Event(m.Body)
+	if err != nil {
+		s.logger.Printf("upload-events: dropping message %s: %v", m.ID, err)
+		return 0
+	}
+
+	bucket := os.Getenv("S3_BUCKET")
+	// keys notified per transfer, in the order first seen
+	var ids []string
+	keys := make(map[string][]string)
+	// latest upload notified per transfer
+	uploaded := make(map[string]time.Time)
+	for _, r := range e.Records {
+		if !r.ObjectCreated() || r.S3.Bucket.Name != bucket {
+			continue
+		}
+		key, err := r.ObjectKey()
+		if err != nil {
+			s.logger.Printf("upload-events: invalid key %q in message %s: %v", r.S3.Object.Key, m.ID, err)
+			continue
+		}
+		id, ok := transferIDFromKey(key)
+		if !ok {
+			// tus tails, cached archives and anything else outside uploads/
+			continue
+		}
+		if _, seen := keys[id]; !seen {
+			ids = append(ids, id)
+		}
+		keys[id] = append(keys[id], key)
+		if r.EventTime.After(uploaded[id]) {
+			uploaded[id] = r.EventTime
+		}
+	}
+
+	// retried as soon as the first transfer can make progress; the others wait again
+	var wait time.Duration
+	for _, id := range ids {
+		if w := s.completeFromEvent(ctx, bucket, id, keys[id], uploaded[id]); w > 0 && (wait == 0 || w < wait) {
+			wait = w
+		}
+	}
+	return wait
+}
+
+// completeFromEvent completes transfer id once every file in its manifest is in storage
+// and nothing was uploaded for uploadEventIdle, with the same checks and conditional INIT
+// update as POST /complete. notified are the keys the event reported created, the last of
+// them at uploaded. It returns how long to wait before the event is retried, or 0.
+func (s *Server) completeFromEvent(ctx context.Context, bucket, id string, notified []string, uploaded time.Time) time.Duration {
+	t, err := s.repo.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return 0
+		}
+		s.logger.Printf("upload-events: failed to fetch transfer id=%s: %v", id, err)
+		return uploadEventRetry
+	}
+	s.expireIfDue(ctx, t)
+	if t.Status != repository.StatusInit {
+		// completed by the client, or an event for an object uploaded again later
+		if s.debug {
+			s.logger.Printf("upload-events: transfer not INIT id=%s status=%s", id, t.Status)
+		}
+		return 0
+	}
+
+	// the client may still be adding files with upload-url; only a transfer left alone
+	// for the idle window is taken to be abandoned
+	last := t.CreatedAt
+	if uploaded.After(last) {
+		last = uploaded
+	}
+	if wait := time.Until(last.Add(uploadEventIdle())); wait > 0 {
+		if s.debug {
+			s.logger.Printf("upload-events: transfer still active id=%s, retrying in %s", id, wait.Round(time.Second))
+		}
+		return wait
+	}
+
+	files, err := s.repo.ListFiles(ctx, id)
+	if err != nil {
+		s.logger.Printf("upload-events: failed to load files id=%s: %v", id, err)
+		return uploadEventRetry
+	}
+	if len(files) == 0 {
+		return 0
+	}
+	for _, f := range files {
+		// multipart and tus uploads are assembled by /complete and the last PATCH; completing
+		// now would cut them short
+		if f.UploadID != nil || f.UploadLength != nil {
+			s.logger.Printf("upload-events: transfer has multipart or tus uploads, leaving it to them id=%s", id)
+			return 0
+		}
+		if _, _, err := s.store.HeadObject(ctx, bucket, f.ObjectKey); err != nil {
+			for _, k := range notified {
+				if k == f.ObjectKey {
+					// the event says the object exists, so this is storage failing
+					s.logger.Printf("upload-events: failed to read notified object id=%s key=%s: %v", id, k, err)
+					return uploadEventRetry
+				}
+			}
+			// the event for the last file to arrive completes the transfer
+			if s.debug {
+				s.logger.Printf("upload-events: waiting for more files id=%s key=%s", id, f.ObjectKey)
+			}
+			return 0
+		}
+	}
+
+	if _, err := s.completeTransfer(ctx, t, "upload-events", nil); err != nil {
+		var ce *completeError
+		if errors.As(err, &ce) && ce.Status >= http.StatusInternalServerError {
+			return uploadEventRetry
+		}
+		// rejected uploads and transfers completed meanwhile are left to the client
+		return 0
+	}
+	return 0
+}
+
+// transferIDFromKey returns the transfer of an object key laid out by objectKeyFor.
+func transferIDFromKey(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, "uploads/")
+	if !ok {
+		return "", false
+	}
+	id, filename, ok := strings.Cut(rest, "/")
+	if !ok || !validFilename(filename) {
+		return "", false
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return "", false
+	}
+	return id, true
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pavithrankb/weTransfer/internal/repository"
+	"github.com/pavithrankb/weTransfer/internal/storage"
+)
+
+func TestUploadEventCompletesTransfer(t *testing.T) {
+	t.Setenv("UPLOAD_EVENTS_IDLE", "0s")
+	ts := newTestServer(t)
+	id, _ := ts.createTransfer()
+	f := ts.upload(id, "notes.txt", "hello")
+
+	const queue = "upload-events"
+	broker := storage.NewMemoryBroker(0)
+	broker.CreateQueue(queue)
+	body, err := storage.ObjectCreatedEvent(testBucket, f.ObjectKey, 5)
+	if err != nil {
+		t.Fatalf("ObjectCreatedEvent: %v", err)
+	}
+	if err := broker.SendMessage(t.Context(), queue, body); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		ts.s.ConsumeUploadEvents(ctx, broker, queue)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for ts.status(id) != repository.StatusReady || broker.Len(queue) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("status = %s, %d messages queued; want READY and the event deleted", ts.status(id), broker.Len(queue))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the client's own complete, arriving late, succeeds with the completed transfer
+	var resp struct {
+		Status string `json:"status"`
+	}
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/complete", nil, &resp); code != http.StatusOK {
+		t.Fatalf("complete after event = %d, want 200", code)
+	}
+	if resp.Status != string(repository.StatusReady) {
+		t.Errorf("complete after event status = %q, want READY", resp.Status)
+	}
+}
+
+func TestUploadEventIgnoresIncompleteManifest(t *testing.T) {
+	t.Setenv("UPLOAD_EVENTS_IDLE", "0s")
+	ts := newTestServer(t)
+	id, _ := ts.createTransfer()
+	f := ts.upload(id, "a.txt", "hello")
+	ts.requestUpload(id, "b.txt", "world")
+
+	body, err := storage.ObjectCreatedEvent(testBucket, f.ObjectKey, 5)
+	if err != nil {
+		t.Fatalf("ObjectCreatedEvent: %v", err)
+	}
+	if wait := ts.s.handleUploadEvent(t.Context(), storage.Message{ID: "1", Body: body}); wait != 0 {
+		t.Fatalf("handleUploadEvent = %s, want the event done with", wait)
+	}
+	if got := ts.status(id); got != repository.StatusInit {
+		t.Errorf("status = %s, want INIT until b.txt arrives", got)
+	}
+}
+
+func TestUploadEventWaitsForIdleTransfer(t *testing.T) {
+	t.Setenv("UPLOAD_EVENTS_IDLE", "1h")
+	ts := newTestServer(t)
+	id, _ := ts.createTransfer()
+	a := ts.upload(id, "a.txt", "hello")
+
+	event := func(key string, size int64) storage.Message {
+		t.Helper()
+		body, err := storage.ObjectCreatedEvent(testBucket, key, size)
+		if err != nil {
+			t.Fatalf("ObjectCreatedEvent: %v", err)
+		}
+		return storage.Message{ID: key, Body: body}
+	}
+
+	// the only file of the manifest is in, but the client may not be done yet
+	if wait := ts.s.handleUploadEvent(t.Context(), event(a.ObjectKey, 5)); wait < 59*time.Minute || wait > time.Hour {
+		t.Fatalf("handleUploadEvent = %s, want a retry once the transfer was idle for 1h", wait)
+	}
+	if got := ts.status(id); got != repository.StatusInit {
+		t.Fatalf("status = %s, want INIT within the idle window", got)
+	}
+
+	// so it can still add a file
+	b := ts.upload(id, "b.txt", "world")
+
+	// once the window has passed, the retried event completes both files
+	t.Setenv("UPLOAD_EVENTS_IDLE", "0s")
+	if wait := ts.s.handleUploadEvent(t.Context(), event(a.ObjectKey, 5)); wait != 0 {
+		t.Fatalf("handleUploadEvent after the window = %s, want the event done with", wait)
+	}
+	if got := ts.status(id); got != repository.StatusReady {
+		t.Fatalf("status = %s, want READY", got)
+	}
+	files, err := ts.s.repo.ListFiles(t.Context(), id)
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+	if len(files) != 2 || files[0].ObjectKey != a.ObjectKey || files[1].ObjectKey != b.ObjectKey {
+		t.Errorf("files = %+v, want a.txt and b.txt", files)
+	}
+}
@@ -0,0 +1,559 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pavithrankb/weTransfer/internal/repository"
+)
+
+// Webhook event types.
+const (
+	webhookTransferReady        = "transfer.ready"
+	webhookTransferQuarantined  = "transfer.quarantined"
+	webhookTransferDownloaded   = "transfer.downloaded"
+	webhookTransferLimitReached = "transfer.limit_reached"
+	webhookTransferExpired      = "transfer.expired"
+	webhookTransferDeleted      = "transfer.deleted"
+)
+
+var webhookEventTypes = []string{
+	webhookTransferReady,
+	webhookTransferQuarantined,
+	webhookTransferDownloaded,
+	webhookTransferLimitReached,
+	webhookTransferExpired,
+	webhookTransferDeleted,
+}
+
+const (
+	webhookSecretPrefix       = "whsec_"
+	webhookBatchSize          = 20
+	webhookLease              = time.Minute
+	defaultWebhookMaxAttempts = 8
+)
+
+func webhookMaxAttempts() int {
+	if v, err := strconv.Atoi(os.Getenv("WEBHOOK_MAX_ATTEMPTS")); err == nil && v > 0 {
+		return v
+	}
+	return defaultWebhookMaxAttempts
+}
+
+type webhookPayload struct {
+	ID        string           `json:"id"`
+	Type      string           `json:"type"`
+	CreatedAt time.Time        `json:"created_at"`
+	Transfer  transferResponse `json:"transfer"`
+}
+
+// notifyWebhooks queues eventType for every webhook subscribed to it. Failures are only
+// logged: the change that triggered the event has already happened.
+func (s *Server) notifyWebhooks(ctx context.Context, eventType string, t *repository.Transfer) {
+	body, err := json.Marshal(webhookPayload{
+		ID:        uuid.New().String(),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Transfer:  newTransferResponse(t),
+	})
+	if err != nil {
+		s.logger.Printf("webhooks: failed to encode %s id=%s: %v", eventType, t.ID, err)
+		return
+	}
+	n, err := s.repo.EnqueueDeliveries(ctx, t.OwnerID, eventType, t.ID, body)
+	if err != nil {
+		s.logger.Printf("webhooks: failed to queue %s id=%s: %v", eventType, t.ID, err)
+		return
+	}
+	if n > 0 && s.debug {
+		s.logger.Printf("webhooks: queued %s id=%s deliveries=%d", eventType, t.ID, n)
+	}
+}
+
+// RunWebhookDeliveries sends every delivery that is due. Failed attempts are retried with
+// exponential backoff until WEBHOOK_MAX_ATTEMPTS, after which the delivery is marked failed
+// and can be replayed through the API.
+func (s *Server) RunWebhookDeliveries() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	for {
+		deliveries, err := s.repo.ClaimDeliveries(ctx, webhookBatchSize, webhookLease)
+		if err != nil {
+			s.logger.Printf("webhooks: failed to claim deliveries: %v", err)
+			return
+		}
+
+		// send the batch in parallel so it finishes well within the lease
+		var wg sync.WaitGroup
+		for _, d := range deliveries {
+			wg.Add(1)
+			go func(d repository.Delivery) {
+				defer wg.Done()
+				s.deliverWebhook(ctx, d)
+			}(d)
+		}
+		wg.Wait()
+
+		if len(deliveries) < webhookBatchSize {
+			return
+		}
+	}
+}
+
+func (s *Server) deliverWebhook(ctx context.Context, d repository.Delivery) {
+	var res repository.DeliveryResult
+	code, err := s.postWebhook(ctx, d)
+	res.StatusCode = code
+	switch {
+	case err != nil:
+		res.Error = err.Error()
+	case code < 200 || code > 299:
+		res.Error = fmt.Sprintf("unexpected status %d", code)
+	default:
+		res.Succeeded = true
+	}
+
+	attempt := d.Attempts + 1
+	if !res.Succeeded && attempt < webhookMaxAttempts() {
+		next := time.Now().UTC().Add(repository.RetryBackoff(attempt))
+		res.NextAttemptAt = &next
+	}
+
+	if err := s.repo.RecordDeliveryAttempt(ctx, d.ID, res); err != nil {
+		s.logger.Printf("webhooks: failed to record attempt delivery=%s: %v", d.ID, err)
+	}
+
+	switch {
+	case res.Succeeded:
+		s.logger.Printf("webhooks: delivered %s delivery=%s webhook=%s", d.EventType, d.ID, d.WebhookID)
+	case res.NextAttemptAt != nil:
+		s.logger.Printf("webhooks: attempt %d failed delivery=%s: %s (retry at %s)", attempt, d.ID, res.Error, res.NextAttemptAt.Format(time.RFC3339))
+	default:
+		s.logger.Printf("webhooks: giving up delivery=%s after %d attempts: %s", d.ID, attempt, res.Error)
+	}
+}
+
+// postWebhook sends one signed delivery and returns the receiver's status code.
+func (s *Server) postWebhook(ctx context.Context, d repository.Delivery) (int, error) {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "weTransfer-Webhooks/1.0")
+	req.Header.Set("X-Webhook-Id", d.ID)
+	req.Header.Set("X-Webhook-Event", d.EventType)
+	req.Header.Set("X-Webhook-Timestamp", ts)
+	req.Header.Set("X-Webhook-Signature", "sha256="+signWebhook(d.Secret, ts, d.Payload))
+
+	resp, err := s.webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return resp.StatusCode, nil
+}
+
+// signWebhook returns the hex HMAC-SHA256 of "<timestamp>.<payload>". Including the timestamp
+// lets receivers reject old deliveries replayed by a third party.
+func signWebhook(secret, ts string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type createWebhookRequest struct {
+	URL     string   `json:"url"`
+	Events  []string `json:"events"`
+	OwnerID *string  `json:"owner_id"`
+}
+
+type webhookResponse struct {
+	ID        string    `json:"id"`
+	OwnerID   *string   `json:"owner_id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newWebhookResponse(wh repository.Webhook) webhookResponse {
+	events := wh.Events
+	if events == nil {
+		events = []string{}
+	}
+	return webhookResponse{
+		ID:        wh.ID,
+		OwnerID:   wh.OwnerID,
+		URL:       wh.URL,
+		Events:    events,
+		CreatedAt: wh.CreatedAt,
+	}
+}
+
+type deliveryResponse struct {
+	ID             string          `json:"id"`
+	EventType      string          `json:"event_type"`
+	TransferID     string          `json:"transfer_id"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at"`
+	LastStatusCode *int            `json:"last_status_code"`
+	LastError      *string         `json:"last_error"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+	Payload        json.RawMessage `json:"payload"`
+}
+
+func newDeliveryResponse(d repository.Delivery) deliveryResponse {
+	resp := deliveryResponse{
+		ID:             d.ID,
+		EventType:      d.EventType,
+		TransferID:     d.TransferID,
+		Status:         d.Status,
+		Attempts:       d.Attempts,
+		LastStatusCode: d.LastStatusCode,
+		LastError:      d.LastError,
+		CreatedAt:      d.CreatedAt,
+		DeliveredAt:    d.DeliveredAt,
+		Payload:        d.Payload,
+	}
+	if d.Status == repository.DeliveryPending {
+		next := d.NextAttemptAt
+		resp.NextAttemptAt = &next
+	}
+	return resp
+}
+
+// webhooksRootHandler handles /webhooks: GET lists the caller's webhooks, POST subscribes one.
+func (s *Server) webhooksRootHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.listWebhooksHandler(w, r)
+	case http.MethodPost:
+		s.createWebhookHandler(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// webhooksSubHandler handles dynamic subroutes under /webhooks/
+// expected patterns: /webhooks/{id}, /webhooks/{id}/deliveries, /webhooks/{id}/deliveries/{delivery_id}/replay
+func (s *Server) webhooksSubHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/webhooks/"), "/")
+	wh := s.authorizeWebhook(w, r, parts[0])
+	if wh == nil {
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(newWebhookResponse(*wh))
+		case http.MethodDelete:
+			s.deleteWebhookHandler(w, r, wh.ID)
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case len(parts) == 2 && parts[1] == "deliveries":
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.listDeliveriesHandler(w, r, wh.ID)
+	case len(parts) == 4 && parts[1] == "deliveries" && parts[3] == "replay":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.replayDeliveryHandler(w, r, wh.ID, parts[2])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// authorizeWebhook loads webhook id if the caller may manage it. Other owners' webhooks are
+// reported as 404. It writes the error response itself and returns nil on failure.
+func (s *Server) authorizeWebhook(w http.ResponseWriter, r *http.Request, id string) *repository.Webhook {
+	wh, err := s.repo.GetWebhook(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			http.NotFound(w, r)
+			return nil
+		}
+		s.logger.Printf("webhooks: failed to fetch webhook id=%s: %v", id, err)
+		http.Error(w, "failed to fetch webhook", http.StatusInternalServerError)
+		return nil
+	}
+	if p := principalFrom(r.Context()); !p.Admin && (wh.OwnerID == nil || *wh.OwnerID != p.OwnerID) {
+		http.NotFound(w, r)
+		return nil
+	}
+	return wh
+}
+
+func (s *Server) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var req createWebhookRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		http.Error(w, "url must be an absolute http(s) URL", http.StatusBadRequest)
+		return
+	}
+	if err := checkWebhookHost(r.Context(), u.Hostname()); err != nil {
+		s.logger.Printf("webhooks: rejected url %s: %v", req.URL, err)
+		http.Error(w, "url must point to a public address: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, e := range req.Events {
+		if !validWebhookEvent(e) {
+			http.Error(w, fmt.Sprintf("unknown event %q", e), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// callers subscribe to their own transfers; an admin may pick an owner or omit it for all
+	p := principalFrom(r.Context())
+	ownerID := req.OwnerID
+	if !p.Admin {
+		if req.OwnerID != nil && *req.OwnerID != p.OwnerID {
+			http.Error(w, "owner_id can only be set by an admin", http.StatusForbidden)
+			return
+		}
+		ownerID = &p.OwnerID
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		s.logger.Printf("webhooks: failed to generate secret: %v", err)
+		http.Error(w, "failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+
+	wh := repository.Webhook{
+		ID:      uuid.New().String(),
+		OwnerID: ownerID,
+		URL:     req.URL,
+		Secret:  webhookSecretPrefix + secret,
+		Events:  req.Events,
+	}
+	if err := s.repo.CreateWebhook(r.Context(), &wh); err != nil {
+		s.logger.Printf("webhooks: failed to insert webhook: %v", err)
+		http.Error(w, "failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+
+	s.logger.Printf("webhook created id=%s url=%s", wh.ID, wh.URL)
+
+	// the signing secret is only ever returned here
+	resp := newWebhookResponse(wh)
+	resp.Secret = wh.Secret
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// privateTargetsAllowed reports whether webhooks may point at loopback, link-local and
+// private addresses, for receivers on the same network (WEBHOOK_ALLOW_PRIVATE_TARGETS=true).
+// Otherwise any caller with an API key could make the server probe internal services or the
+// cloud metadata endpoint.
+func privateTargetsAllowed() bool {
+	return os.Getenv("WEBHOOK_ALLOW_PRIVATE_TARGETS") == "true"
+}
+
+// sharedAddressSpace is 100.64.0.0/10, used for carrier-grade NAT and by some cloud networks.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddr reports whether ip is a public unicast address a webhook may be delivered to.
+func publicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
+
+// checkWebhookHost resolves host and fails unless every address it has is public.
+func checkWebhookHost(ctx context.Context, host string) error {
+	if privateTargetsAllowed() {
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("unable to resolve %s", host)
+	}
+	for _, ip := range addrs {
+		if !publicAddr(ip) {
+			return fmt.Errorf("%s resolves to a non-public address", host)
+		}
+	}
+	return nil
+}
+
+// webhookControl is the dialer hook of the delivery client. The host was checked when the
+// webhook was registered, but DNS may point it elsewhere since, so every connection is
+// checked again.
+func webhookControl(network, address string, _ syscall.RawConn) error {
+	if privateTargetsAllowed() {
+		return nil
+	}
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !publicAddr(ap.Addr()) {
+		return fmt.Errorf("webhook target %s is not a public address", ap.Addr())
+	}
+	return nil
+}
+
+func validWebhookEvent(e string) bool {
+	for _, t := range webhookEventTypes {
+		if t == e {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	var ownerID *string
+	if p := principalFrom(r.Context()); !p.Admin {
+		ownerID = &p.OwnerID
+	}
+	hooks, err := s.repo.ListWebhooks(r.Context(), ownerID)
+	if err != nil {
+		s.logger.Printf("webhooks: failed to list webhooks: %v", err)
+		http.Error(w, "failed to list webhooks", http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]webhookResponse, 0, len(hooks))
+	for _, wh := range hooks {
+		items = append(items, newWebhookResponse(wh))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+}
+
+func (s *Server) deleteWebhookHandler(w http.ResponseWriter, r *http.Request, id string) {
+	if err := s.repo.DeleteWebhook(r.Context(), id); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		s.logger.Printf("webhooks: failed to delete webhook id=%s: %v", id, err)
+		http.Error(w, "failed to delete webhook", http.StatusInternalServerError)
+		return
+	}
+
+	s.logger.Printf("webhook deleted id=%s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type listDeliveriesResponse struct {
+	Items      []deliveryResponse `json:"items"`
+	Limit      int                `json:"limit"`
+	Offset     int                `json:"offset"`
+	TotalCount int                `json:"total_count"`
+}
+
+// listDeliveriesHandler returns the delivery log of a webhook, newest first.
+func (s *Server) listDeliveriesHandler(w http.ResponseWriter, r *http.Request, id string) {
+	query := r.URL.Query()
+	limitVal := 50
+	offsetVal := 0
+
+	if l := query.Get("limit"); l != "" {
+		var err error
+		limitVal, err = strconv.Atoi(l)
+		if err != nil || limitVal < 1 || limitVal > 100 {
+			http.Error(w, "invalid limit (1-100)", http.StatusBadRequest)
+			return
+		}
+	}
+	if o := query.Get("offset"); o != "" {
+		var err error
+		offsetVal, err = strconv.Atoi(o)
+		if err != nil || offsetVal < 0 {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+
+	deliveries, totalCount, err := s.repo.ListDeliveries(r.Context(), id, limitVal, offsetVal)
+	if err != nil {
+		s.logger.Printf("webhooks: failed to list deliveries webhook=%s: %v", id, err)
+		http.Error(w, "failed to list deliveries", http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]deliveryResponse, 0, len(deliveries))
+	for _, d := range deliveries {
+		items = append(items, newDeliveryResponse(d))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(listDeliveriesResponse{
+		Items:      items,
+		Limit:      limitVal,
+		Offset:     offsetVal,
+		TotalCount: totalCount,
+	})
+}
+
+// replayDeliveryHandler queues a failed delivery again; the worker sends it on its next run.
+func (s *Server) replayDeliveryHandler(w http.ResponseWriter, r *http.Request, webhookID, id string) {
+	if err := s.repo.ReplayDelivery(r.Context(), webhookID, id); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			http.NotFound(w, r)
+		case errors.Is(err, repository.ErrConflict):
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "delivery_not_failed"})
+		default:
+			s.logger.Printf("webhooks: failed to replay delivery=%s: %v", id, err)
+			http.Error(w, "failed to replay delivery", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	s.logger.Printf("webhooks: delivery queued for replay delivery=%s webhook=%s", id, webhookID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": repository.DeliveryPending})
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pavithrankb/weTransfer/internal/repository"
+)
+
+// receivedWebhook is one delivery a test receiver got.
+type receivedWebhook struct {
+	Header http.Header
+	Body   []byte
+}
+
+// webhookReceiver records every delivery and answers it with status.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	received []receivedWebhook
+	status   int
+}
+
+func newWebhookReceiver(t *testing.T, status int) (*webhookReceiver, string) {
+	t.Helper()
+	rcv := &webhookReceiver{status: status}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rcv.mu.Lock()
+		rcv.received = append(rcv.received, receivedWebhook{Header: r.Header.Clone(), Body: body})
+		status := rcv.status
+		rcv.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return rcv, srv.URL
+}
+
+func (rcv *webhookReceiver) deliveries() []receivedWebhook {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return append([]receivedWebhook(nil), rcv.received...)
+}
+
+func (rcv *webhookReceiver) setStatus(status int) {
+	rcv.mu.Lock()
+	rcv.status = status
+	rcv.mu.Unlock()
+}
+
+// createWebhook subscribes url to every event and returns the webhook with its secret.
+func (ts *testServer) createWebhook(url string) webhookResponse {
+	ts.t.Helper()
+	var wh webhookResponse
+	if code := ts.do(http.MethodPost, "/webhooks", map[string]interface{}{"url": url}, &wh); code != http.StatusCreated {
+		ts.t.Fatalf("POST /webhooks = %d, want 201", code)
+	}
+	return wh
+}
+
+// completeTransfer creates a transfer of one file and completes it, which queues a
+// transfer.ready delivery.
+func (ts *testServer) completeTransfer() string {
+	ts.t.Helper()
+	id, _ := ts.createTransfer()
+	ts.upload(id, "notes.txt", "hello")
+	if code := ts.do(http.MethodPost, "/transfers/"+id+"/complete", nil, nil); code != http.StatusOK {
+		ts.t.Fatalf("complete = %d", code)
+	}
+	return id
+}
+
+func (ts *testServer) deliveries(webhookID string) []deliveryResponse {
+	ts.t.Helper()
+	var list listDeliveriesResponse
+	if code := ts.do(http.MethodGet, "/webhooks/"+webhookID+"/deliveries", nil, &list); code != http.StatusOK {
+		ts.t.Fatalf("GET deliveries = %d", code)
+	}
+	return list.Items
+}
+
+func TestCreateWebhookRejectsPrivateTargets(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, url := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://10.0.0.7/hook",
+		"http://192.168.1.1/hook",
+		"http://100.64.0.1/hook",
+		"http://[::1]/hook",
+		"http://[fd00::1]/hook",
+		"http://0.0.0.0/hook",
+	} {
+		if code := ts.do(http.MethodPost, "/webhooks", map[string]interface{}{"url": url}, nil); code != http.StatusBadRequest {
+			t.Errorf("POST /webhooks %s = %d, want 400", url, code)
+		}
+	}
+	ts.createWebhook("https://93.184.215.14/hook")
+
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_TARGETS", "true")
+	ts.createWebhook("http://10.0.0.7/hook")
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_TARGETS", "true")
+	ts := newTestServer(t)
+	rcv, url := newWebhookReceiver(t, http.StatusNoContent)
+	wh := ts.createWebhook(url)
+	if !strings.HasPrefix(wh.Secret, webhookSecretPrefix) {
+		t.Fatalf("secret = %q, want the %s prefix", wh.Secret, webhookSecretPrefix)
+	}
+
+	id := ts.completeTransfer()
+	ts.s.RunWebhookDeliveries()
+
+	got := rcv.deliveries()
+	if len(got) != 1 {
+		t.Fatalf("receiver got %d deliveries, want 1", len(got))
+	}
+	h := got[0].Header
+	if h.Get("X-Webhook-Event") != webhookTransferReady {
+		t.Errorf("event = %q, want %s", h.Get("X-Webhook-Event"), webhookTransferReady)
+	}
+	want := "sha256=" + signWebhook(wh.Secret, h.Get("X-Webhook-Timestamp"), got[0].Body)
+	if h.Get("X-Webhook-Signature") != want {
+		t.Errorf("signature = %q, want %q", h.Get("X-Webhook-Signature"), want)
+	}
+	var payload webhookPayload
+	if err := json.Unmarshal(got[0].Body, &payload); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if payload.Type != webhookTransferReady || payload.Transfer.ID != id {
+		t.Errorf("payload = %+v, want transfer.ready for %s", payload, id)
+	}
+
+	ds := ts.deliveries(wh.ID)
+	if len(ds) != 1 || ds[0].Status != repository.DeliverySucceeded || ds[0].ID != h.Get("X-Webhook-Id") {
+		t.Errorf("deliveries = %+v, want the one succeeded", ds)
+	}
+
+	// a receiver that resolves to a private address by now is refused when dialled
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_TARGETS", "false")
+	ts.s.webhookClient.CloseIdleConnections()
+	ts.completeTransfer()
+	ts.s.RunWebhookDeliveries()
+	if n := len(rcv.deliveries()); n != 1 {
+		t.Errorf("receiver got %d deliveries, want the private one refused", n)
+	}
+	ds = ts.deliveries(wh.ID)
+	if ds[0].LastError == nil || !strings.Contains(*ds[0].LastError, "not a public address") {
+		t.Errorf("last delivery = %+v, want it refused as not public", ds[0])
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_TARGETS", "true")
+	ts := newTestServer(t)
+	rcv, url := newWebhookReceiver(t, http.StatusInternalServerError)
+	wh := ts.createWebhook(url)
+	ts.completeTransfer()
+
+	start := time.Now()
+	ts.s.RunWebhookDeliveries()
+	d := ts.deliveries(wh.ID)[0]
+	if d.Status != repository.DeliveryPending || d.Attempts != 1 || d.LastStatusCode == nil || *d.LastStatusCode != 500 {
+		t.Fatalf("delivery = %+v, want pending after 1 attempt answered 500", d)
+	}
+	if d.NextAttemptAt == nil || d.NextAttemptAt.Before(start.Add(repository.RetryBackoff(1))) {
+		t.Errorf("next attempt at %v, want %s from now", d.NextAttemptAt, repository.RetryBackoff(1))
+	}
+	// nothing is due before the backoff has passed
+	ts.s.RunWebhookDeliveries()
+	if n := len(rcv.deliveries()); n != 1 {
+		t.Errorf("receiver got %d deliveries, want the retry to wait", n)
+	}
+}
+
+func TestWebhookGiveUpAndReplay(t *testing.T) {
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_TARGETS", "true")
+	t.Setenv("WEBHOOK_MAX_ATTEMPTS", "1")
+	ts := newTestServer(t)
+	rcv, url := newWebhookReceiver(t, http.StatusInternalServerError)
+	wh := ts.createWebhook(url)
+	ts.completeTransfer()
+
+	ts.s.RunWebhookDeliveries()
+	d := ts.deliveries(wh.ID)[0]
+	if d.Status != repository.DeliveryFailed || d.Attempts != 1 || d.NextAttemptAt != nil {
+		t.Fatalf("delivery = %+v, want failed after WEBHOOK_MAX_ATTEMPTS", d)
+	}
+
+	// only the webhook's owner can replay it
+	other := ts.otherOwner("bob")
+	replay := "/webhooks/" + wh.ID + "/deliveries/" + d.ID + "/replay"
+	if code := other.do(http.MethodPost, replay, nil, nil); code != http.StatusNotFound {
+		t.Errorf("replay by another owner = %d, want 404", code)
+	}
+	bobs := other.createWebhook("https://93.184.215.14/hook")
+	if code := other.do(http.MethodPost, "/webhooks/"+bobs.ID+"/deliveries/"+d.ID+"/replay", nil, nil); code != http.StatusNotFound {
+		t.Errorf("replay through another owner's webhook = %d, want 404", code)
+	}
+
+	rcv.setStatus(http.StatusOK)
+	if code := ts.do(http.MethodPost, replay, nil, nil); code != http.StatusAccepted {
+		t.Fatalf("replay = %d, want 202", code)
+	}
+	if code := ts.do(http.MethodPost, replay, nil, nil); code != http.StatusConflict {
+		t.Errorf("replay of a pending delivery = %d, want 409", code)
+	}
+	ts.s.RunWebhookDeliveries()
+	if d = ts.deliveries(wh.ID)[0]; d.Status != repository.DeliverySucceeded || len(rcv.deliveries()) != 2 {
+		t.Errorf("replayed delivery = %+v, want succeeded", d)
+	}
+}
+
+// otherOwner returns a client of ts calling with an API key of owner.
+func (ts *testServer) otherOwner(owner string) *testServer {
+	ts.t.Helper()
+	admin := *ts
+	admin.key = "test-admin"
+	var k struct {
+		Key string `json:"key"`
+	}
+	if code := admin.do(http.MethodPost, "/api-keys", map[string]string{"owner_id": owner}, &k); code != http.StatusCreated {
+		ts.t.Fatalf("POST /api-keys = %d", code)
+	}
+	other := *ts
+	other.key = k.Key
+	return &other
+}
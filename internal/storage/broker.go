@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Publisher is the topic side of the email pipeline. SNS is the production implementation;
+// MemoryBroker delivers in process so sharing works without AWS.
+type Publisher interface {
+	// Publish sends message to every queue subscribed to topic.
+	Publish(ctx context.Context, topic, subject, message string) error
+}
+
+// Consumer is the queue side of the email pipeline, implemented by SQS and MemoryBroker.
+// A received message stays in the queue, invisible for the visibility timeout, until it
+// is deleted; if it isn't, it is delivered again.
+type Consumer interface {
+	// CheckQueue verifies the queue is reachable.
+	CheckQueue(ctx context.Context, queue string) error
+	// Receive waits up to wait for at most max messages and hides them for visibility.
+	Receive(ctx context.Context, queue string, max int, wait, visibility time.Duration) ([]Message, error)
+	// Delete removes a received message; receiptHandle identifies that receive.
+	Delete(ctx context.Context, queue, receiptHandle string) error
+	// ChangeVisibility hides a received message for timeout from now, e.g. to retry it later.
+	ChangeVisibility(ctx context.Context, queue, receiptHandle string, timeout time.Duration) error
+}
+
+// Message is one message received from a queue. Messages fanned out from a topic carry
+// the SNS notification envelope as Body.
+type Message struct {
+	ID            string
+	Body          string
+	ReceiptHandle string
+	// ReceiveCount is how many times the message has been received, including this one.
+	ReceiveCount int
+}
+
+// PublishShareDownload publishes a share-download event to topic.
+func PublishShareDownload(ctx context.Context, p Publisher, topic string, msg ShareDownloadMessage) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return p.Publish(ctx, topic, "File ready for download", string(payload))
+}
+
+var (
+	_ Publisher = (*SNS)(nil)
+	_ Publisher = (*MemoryBroker)(nil)
+	_ Consumer  = (*SQS)(nil)
+	_ Consumer  = (*MemoryBroker)(nil)
+)
@@ -0,0 +1,784 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LocalObjectsPath is the URL prefix under which LocalStore serves its signed URLs.
+const LocalObjectsPath = "/local-objects/"
+
+// ErrObjectNotFound is returned by LocalStore when the requested object does not exist.
+var ErrObjectNotFound = errors.New("object not found")
+
+// ErrChecksumMismatch is returned by LocalStore when an uploaded body doesn't match the
+// checksum signed into its URL; the object is not stored.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// LocalStore is a filesystem-backed ObjectStore for development and CI.
+// Presigned URLs point back at the API process (see ServeHTTP) and are
+// authenticated with an HMAC-SHA256 signature over method, bucket, key,
+// content type, size, checksums, multipart upload/part and expiry. Like S3 it stores the
+// SHA-256 and CRC32C of every object it writes.
+type LocalStore struct {
+	root    string
+	baseURL string
+	secret  []byte
+}
+
+type localMeta struct {
+	ContentType string `json:"content_type"`
+	SHA256      string `json:"sha256,omitempty"`
+	CRC32C      string `json:"crc32c,omitempty"`
+}
+
+// localPostPolicy is the policy document of a LocalStore form upload, signed like S3's.
+type localPostPolicy struct {
+	Expiration  int64  `json:"expiration"`
+	Bucket      string `json:"bucket"`
+	Key         string `json:"key,omitempty"`
+	KeyPrefix   string `json:"key_prefix,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	MinSize     int64  `json:"min_size"`
+	MaxSize     int64  `json:"max_size,omitempty"`
+}
+
+type localUpload struct {
+	Bucket        string `json:"bucket"`
+	Key           string `json:"key"`
+	ContentType   string `json:"content_type"`
+	PartChecksums bool   `json:"part_checksums,omitempty"`
+}
+
+// NewLocalStore returns a LocalStore that keeps objects under root and signs URLs
+// relative to baseURL (e.g. http://localhost:8080).
+func NewLocalStore(root, baseURL string, secret []byte) (*LocalStore, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("local store secret must not be empty")
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(abs, 0o755); err != nil {
+		return nil, err
+	}
+	return &LocalStore{
+		root:    abs,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		secret:  secret,
+	}, nil
+}
+
+// PresignPutURL returns a signed URL that accepts a PUT of the object body with the given
+// content type and, if size is positive, exactly size bytes and, if sums is not empty,
+// only a body matching every checksum in sums.
+func (l *LocalStore) PresignPutURL(ctx context.Context, bucket, key, contentType string, size int64, sums Checksums, expires time.Duration) (string, error) {
+	return l.signedURL(http.MethodPut, bucket, key, contentType, size, sums, "", 0, expires)
+}
+
+// PresignGetURL returns a signed URL that serves the object via GET.
+func (l *LocalStore) PresignGetURL(ctx context.Context, bucket, key string, expires time.Duration) (string, error) {
+	return l.signedURL(http.MethodGet, bucket, key, "", 0, Checksums{}, "", 0, expires)
+}
+
+// PresignPost returns a form upload to the bucket URL whose policy, like S3's, is signed
+// into the fields and checked when the form is posted.
+func (l *LocalStore) PresignPost(ctx context.Context, bucket, key string, cond PostConditions, expires time.Duration) (*PresignedPost, error) {
+	if _, _, err := l.paths(bucket, key); err != nil {
+		return nil, err
+	}
+	p := localPostPolicy{
+		Expiration:  time.Now().Add(expires).Unix(),
+		Bucket:      bucket,
+		KeyPrefix:   cond.KeyPrefix,
+		ContentType: cond.ContentType,
+		MinSize:     cond.MinSize,
+		MaxSize:     cond.MaxSize,
+	}
+	if cond.KeyPrefix == "" {
+		p.Key = key
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	policy := base64.StdEncoding.EncodeToString(b)
+
+	fields := map[string]string{
+		"key":       key,
+		"policy":    policy,
+		"signature": l.signPolicy(policy),
+	}
+	if cond.ContentType != "" {
+		fields["Content-Type"] = cond.ContentType
+	}
+	return &PresignedPost{URL: l.baseURL + LocalObjectsPath + url.PathEscape(bucket), Fields: fields}, nil
+}
+
+// HeadObject returns the size and content type of a stored object.
+func (l *LocalStore) HeadObject(ctx context.Context, bucket, key string) (int64, string, error) {
+	dataPath, metaPath, err := l.paths(bucket, key)
+	if err != nil {
+		return 0, "", err
+	}
+
+	fi, err := os.Stat(dataPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, "", ErrObjectNotFound
+		}
+		return 0, "", err
+	}
+
+	var meta localMeta
+	if b, err := os.ReadFile(metaPath); err == nil {
+		_ = json.Unmarshal(b, &meta)
+	}
+
+	return fi.Size(), meta.ContentType, nil
+}
+
+// ObjectChecksums returns the checksums computed when the object was written.
+func (l *LocalStore) ObjectChecksums(ctx context.Context, bucket, key string) (Checksums, error) {
+	dataPath, metaPath, err := l.paths(bucket, key)
+	if err != nil {
+		return Checksums{}, err
+	}
+	if _, err := os.Stat(dataPath); err != nil {
+		if os.IsNotExist(err) {
+			return Checksums{}, ErrObjectNotFound
+		}
+		return Checksums{}, err
+	}
+
+	var meta localMeta
+	if b, err := os.ReadFile(metaPath); err == nil {
+		_ = json.Unmarshal(b, &meta)
+	}
+	return Checksums{SHA256: meta.SHA256, CRC32C: meta.CRC32C}, nil
+}
+
+// DeleteObject removes an object and its metadata. Missing objects are ignored.
+func (l *LocalStore) DeleteObject(ctx context.Context, bucket, key string) error {
+	dataPath, metaPath, err := l.paths(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(dataPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(metaPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// GetObject opens a stored object for reading. The caller must close it.
+func (l *LocalStore) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	dataPath, _, err := l.paths(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(dataPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// PutObject stores body as the object.
+func (l *LocalStore) PutObject(ctx context.Context, bucket, key, contentType string, body io.Reader) error {
+	return l.putObject(bucket, key, contentType, Checksums{}, body)
+}
+
+// ServeHTTP handles the signed URLs produced by PresignPutURL, PresignGetURL and
+// PresignUploadPartURL, and the form uploads produced by PresignPost.
+// It must be mounted at LocalObjectsPath.
+func (l *LocalStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, LocalObjectsPath)
+	if r.Method == http.MethodPost {
+		l.servePost(w, r, strings.TrimSuffix(rest, "/"))
+		return
+	}
+	parts := strings.SplitN(rest, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	bucket, key := parts[0], parts[1]
+
+	q := r.URL.Query()
+	contentType := q.Get("content_type")
+	sums := Checksums{SHA256: q.Get("checksum_sha256"), CRC32C: q.Get("checksum_crc32c")}
+	uploadID := q.Get("upload_id")
+	partNumber, _ := strconv.ParseInt(q.Get("part_number"), 10, 32)
+	size, _ := strconv.ParseInt(q.Get("size"), 10, 64)
+	if err := l.verify(r.Method, bucket, key, contentType, size, sums, uploadID, int32(partNumber), q.Get("expires"), q.Get("signature")); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		clearDeadlines(w)
+		// like S3, a signed size is the Content-Length the body must be sent with; the
+		// server reads no more than that
+		if size > 0 && r.ContentLength != size {
+			http.Error(w, "content length does not match signature", http.StatusForbidden)
+			return
+		}
+		if uploadID != "" {
+			// like S3, a signed checksum must also be sent as its header
+			if sums.SHA256 != "" && r.Header.Get("x-amz-checksum-sha256") != sums.SHA256 {
+				http.Error(w, "x-amz-checksum-sha256 does not match signature", http.StatusForbidden)
+				return
+			}
+			etag, err := l.putPart(bucket, key, uploadID, int32(partNumber), sums.SHA256, r.Body)
+			if errors.Is(err, ErrChecksumMismatch) {
+				http.Error(w, "body does not match the declared checksum", http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				http.Error(w, "failed to store part", http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if r.Header.Get("Content-Type") != contentType {
+			http.Error(w, "content type does not match signature", http.StatusForbidden)
+			return
+		}
+		// like S3, a signed checksum must also be sent as its header
+		if name, value := sums.Header(); name != "" && r.Header.Get(name) != value {
+			http.Error(w, name+" does not match signature", http.StatusForbidden)
+			return
+		}
+		if err := l.putObject(bucket, key, contentType, sums, r.Body); err != nil {
+			if errors.Is(err, ErrChecksumMismatch) {
+				http.Error(w, "body does not match the declared checksum", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "failed to store object", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet, http.MethodHead:
+		dataPath, _, err := l.paths(bucket, key)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		f, err := os.Open(dataPath)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			http.Error(w, "failed to read object", http.StatusInternalServerError)
+			return
+		}
+		if _, ct, err := l.HeadObject(r.Context(), bucket, key); err == nil && ct != "" {
+			w.Header().Set("Content-Type", ct)
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(key)))
+		http.ServeContent(w, r, filepath.Base(key), fi.ModTime(), f)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// servePost stores the file of a form upload to bucket after checking the form against
+// its signed policy. Like S3 the fields must come before the file, which is streamed.
+func (l *LocalStore) servePost(w http.ResponseWriter, r *http.Request, bucket string) {
+	if bucket == "" || strings.Contains(bucket, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "expected a multipart/form-data body", http.StatusBadRequest)
+		return
+	}
+
+	fields := make(map[string]string)
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			http.Error(w, "form has no file field", http.StatusBadRequest)
+			return
+		}
+		name := part.FormName()
+		if name != "file" {
+			v, err := io.ReadAll(io.LimitReader(part, 64<<10))
+			if err != nil {
+				http.Error(w, "invalid form", http.StatusBadRequest)
+				return
+			}
+			fields[name] = string(v)
+			continue
+		}
+
+		p, err := l.verifyPolicy(fields["policy"], fields["signature"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		key := strings.ReplaceAll(fields["key"], "${filename}", part.FileName())
+		contentType := fields["Content-Type"]
+		switch {
+		case p.Bucket != bucket:
+			err = errors.New("bucket does not match policy")
+		case p.Key != "" && key != p.Key:
+			err = errors.New("key does not match policy")
+		case p.KeyPrefix != "" && !strings.HasPrefix(key, p.KeyPrefix):
+			err = errors.New("key does not match policy")
+		case p.ContentType != "" && contentType != p.ContentType:
+			err = errors.New("content type does not match policy")
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		clearDeadlines(w)
+		body := &rangeReader{r: part, max: p.MaxSize}
+		if err := l.putObject(bucket, key, contentType, Checksums{}, body); err != nil {
+			if errors.Is(err, errEntityTooLarge) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "failed to store object", http.StatusInternalServerError)
+			return
+		}
+		if body.n < p.MinSize {
+			_ = l.DeleteObject(r.Context(), bucket, key)
+			http.Error(w, "body is smaller than the policy allows", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+}
+
+// clearDeadlines lifts the server's ReadTimeout and WriteTimeout for an upload, whose body
+// takes as long to arrive as the client's connection needs, as it does on S3.
+func clearDeadlines(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+}
+
+var errEntityTooLarge = errors.New("body exceeds the maximum size the policy allows")
+
+// rangeReader counts what is read through it and fails once more than max bytes (if
+// max > 0) arrive, so an oversized body is never stored.
+type rangeReader struct {
+	r   io.Reader
+	max int64
+	n   int64
+}
+
+func (rr *rangeReader) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	rr.n += int64(n)
+	if rr.max > 0 && rr.n > rr.max {
+		return n, errEntityTooLarge
+	}
+	return n, err
+}
+
+// putObject writes the object and its checksums. A non-empty field of want must match the
+// body, otherwise nothing is stored and ErrChecksumMismatch is returned.
+func (l *LocalStore) putObject(bucket, key, contentType string, want Checksums, body io.Reader) error {
+	dataPath, metaPath, err := l.paths(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dataPath), 0o755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(metaPath), 0o755); err != nil {
+		return err
+	}
+
+	// write to a temp file first so readers never observe a partial object
+	tmp, err := os.CreateTemp(filepath.Dir(dataPath), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	sha := sha256.New()
+	crc := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	if _, err := io.Copy(io.MultiWriter(tmp, sha, crc), body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	got := Checksums{
+		SHA256: base64.StdEncoding.EncodeToString(sha.Sum(nil)),
+		CRC32C: base64.StdEncoding.EncodeToString(crc.Sum(nil)),
+	}
+	if (want.SHA256 != "" && want.SHA256 != got.SHA256) || (want.CRC32C != "" && want.CRC32C != got.CRC32C) {
+		return ErrChecksumMismatch
+	}
+	if err := os.Rename(tmp.Name(), dataPath); err != nil {
+		return err
+	}
+
+	meta, err := json.Marshal(localMeta{ContentType: contentType, SHA256: got.SHA256, CRC32C: got.CRC32C})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(metaPath, meta, 0o644)
+}
+
+// CreateMultipartUpload starts a multipart upload and returns its upload ID.
+func (l *LocalStore) CreateMultipartUpload(ctx context.Context, bucket, key, contentType string, partChecksums bool) (string, error) {
+	if _, _, err := l.paths(bucket, key); err != nil {
+		return "", err
+	}
+
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	uploadID := hex.EncodeToString(raw)
+
+	dir := l.uploadDir(uploadID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(localUpload{Bucket: bucket, Key: key, ContentType: contentType, PartChecksums: partChecksums})
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "upload.json"), b, 0o644); err != nil {
+		return "", err
+	}
+	return uploadID, nil
+}
+
+// PresignUploadPartURL returns a signed URL that accepts a PUT of a single part of, if
+// size is positive, exactly size bytes, and with the given SHA-256 if one is set.
+func (l *LocalStore) PresignUploadPartURL(ctx context.Context, bucket, key, uploadID string, partNumber int32, size int64, sha256 string, expires time.Duration) (string, error) {
+	if _, err := l.loadUpload(bucket, key, uploadID); err != nil {
+		return "", err
+	}
+	return l.signedURL(http.MethodPut, bucket, key, "", size, Checksums{SHA256: sha256}, uploadID, partNumber, expires)
+}
+
+// UploadPart stores size bytes of body as one part of a multipart upload.
+func (l *LocalStore) UploadPart(ctx context.Context, bucket, key, uploadID string, partNumber int32, body io.ReadSeeker, size int64) (Part, error) {
+	etag, err := l.putPart(bucket, key, uploadID, partNumber, "", io.LimitReader(body, size))
+	if err != nil {
+		return Part{}, err
+	}
+	return Part{PartNumber: partNumber, ETag: etag, Size: size}, nil
+}
+
+// ListParts returns every part uploaded so far, ordered by part number.
+func (l *LocalStore) ListParts(ctx context.Context, bucket, key, uploadID string) ([]Part, error) {
+	if _, err := l.loadUpload(bucket, key, uploadID); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(l.uploadDir(uploadID))
+	if err != nil {
+		return nil, err
+	}
+
+	var parts []Part
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".part")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(name, 10, 32)
+		if err != nil {
+			continue
+		}
+		etag, err := os.ReadFile(filepath.Join(l.uploadDir(uploadID), name+".etag"))
+		if err != nil {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		// only parts sent with a checksum have one
+		sum, _ := os.ReadFile(filepath.Join(l.uploadDir(uploadID), name+".sha256"))
+		parts = append(parts, Part{PartNumber: int32(n), ETag: string(etag), Size: fi.Size(), ChecksumSHA256: string(sum)})
+	}
+
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	return parts, nil
+}
+
+// CompleteMultipartUpload concatenates the given parts into the final object.
+func (l *LocalStore) CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, parts []Part) error {
+	up, err := l.loadUpload(bucket, key, uploadID)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return errors.New("no parts to complete")
+	}
+
+	dir := l.uploadDir(uploadID)
+	readers := make([]io.Reader, 0, len(parts))
+	for i, p := range parts {
+		if i > 0 && p.PartNumber <= parts[i-1].PartNumber {
+			return errors.New("parts must be in ascending order")
+		}
+		etag, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("%d.etag", p.PartNumber)))
+		if err != nil || string(etag) != p.ETag {
+			return fmt.Errorf("invalid part %d", p.PartNumber)
+		}
+		// like S3, an upload with part checksums is completed by naming each one
+		if up.PartChecksums {
+			sum, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("%d.sha256", p.PartNumber)))
+			if err != nil || p.ChecksumSHA256 == "" || string(sum) != p.ChecksumSHA256 {
+				return fmt.Errorf("invalid checksum of part %d", p.PartNumber)
+			}
+		}
+		f, err := os.Open(filepath.Join(dir, fmt.Sprintf("%d.part", p.PartNumber)))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+
+	if err := l.putObject(bucket, key, up.ContentType, Checksums{}, io.MultiReader(readers...)); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// AbortMultipartUpload discards a multipart upload and its parts.
+func (l *LocalStore) AbortMultipartUpload(ctx context.Context, bucket, key, uploadID string) error {
+	if _, err := l.loadUpload(bucket, key, uploadID); err != nil {
+		return err
+	}
+	return os.RemoveAll(l.uploadDir(uploadID))
+}
+
+// putPart stores one part. A non-empty sha256 must match the body, otherwise nothing is
+// stored and ErrChecksumMismatch is returned; an upload with part checksums requires one.
+func (l *LocalStore) putPart(bucket, key, uploadID string, partNumber int32, sha256Sum string, body io.Reader) (string, error) {
+	if partNumber < 1 || partNumber > 10000 {
+		return "", errors.New("invalid part number")
+	}
+	up, err := l.loadUpload(bucket, key, uploadID)
+	if err != nil {
+		return "", err
+	}
+	if up.PartChecksums && sha256Sum == "" {
+		return "", errors.New("part checksum required")
+	}
+
+	dir := l.uploadDir(uploadID)
+	tmp, err := os.CreateTemp(dir, ".part-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := md5.New()
+	sha := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h, sha), body); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if sha256Sum != "" && base64.StdEncoding.EncodeToString(sha.Sum(nil)) != sha256Sum {
+		return "", ErrChecksumMismatch
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, fmt.Sprintf("%d.part", partNumber))); err != nil {
+		return "", err
+	}
+
+	etag := fmt.Sprintf("%q", hex.EncodeToString(h.Sum(nil)))
+	if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%d.etag", partNumber)), []byte(etag), 0o644); err != nil {
+		return "", err
+	}
+	// a part uploaded again without a checksum must not keep the old one
+	sumPath := filepath.Join(dir, fmt.Sprintf("%d.sha256", partNumber))
+	if sha256Sum == "" {
+		if err := os.Remove(sumPath); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		return etag, nil
+	}
+	if err := os.WriteFile(sumPath, []byte(sha256Sum), 0o644); err != nil {
+		return "", err
+	}
+	return etag, nil
+}
+
+// loadUpload reads a multipart upload's manifest and checks it belongs to bucket/key.
+func (l *LocalStore) loadUpload(bucket, key, uploadID string) (*localUpload, error) {
+	if _, err := hex.DecodeString(uploadID); err != nil || uploadID == "" {
+		return nil, fmt.Errorf("invalid upload id %q", uploadID)
+	}
+	b, err := os.ReadFile(filepath.Join(l.uploadDir(uploadID), "upload.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("upload %s not found", uploadID)
+		}
+		return nil, err
+	}
+	var up localUpload
+	if err := json.Unmarshal(b, &up); err != nil {
+		return nil, err
+	}
+	if up.Bucket != bucket || up.Key != key {
+		return nil, fmt.Errorf("upload %s does not belong to %s/%s", uploadID, bucket, key)
+	}
+	return &up, nil
+}
+
+func (l *LocalStore) uploadDir(uploadID string) string {
+	return filepath.Join(l.root, "multipart", uploadID)
+}
+
+// paths maps bucket/key to the data and metadata file paths, rejecting keys that escape the root.
+func (l *LocalStore) paths(bucket, key string) (string, string, error) {
+	if bucket == "" || strings.Contains(bucket, "/") || strings.Contains(bucket, "..") {
+		return "", "", fmt.Errorf("invalid bucket %q", bucket)
+	}
+	clean := filepath.Clean("/" + key)
+	if clean == "/" || strings.Contains(key, "..") {
+		return "", "", fmt.Errorf("invalid key %q", key)
+	}
+	dataPath := filepath.Join(l.root, "data", bucket, clean)
+	metaPath := filepath.Join(l.root, "meta", bucket, clean+".json")
+	return dataPath, metaPath, nil
+}
+
+func (l *LocalStore) signedURL(method, bucket, key, contentType string, size int64, sums Checksums, uploadID string, partNumber int32, expires time.Duration) (string, error) {
+	if _, _, err := l.paths(bucket, key); err != nil {
+		return "", err
+	}
+	exp := strconv.FormatInt(time.Now().Add(expires).Unix(), 10)
+
+	q := url.Values{}
+	q.Set("expires", exp)
+	if size > 0 {
+		q.Set("size", strconv.FormatInt(size, 10))
+	}
+	if uploadID != "" {
+		q.Set("upload_id", uploadID)
+		q.Set("part_number", strconv.Itoa(int(partNumber)))
+	} else if method == http.MethodPut {
+		q.Set("content_type", contentType)
+	}
+	if method == http.MethodPut {
+		if sums.SHA256 != "" {
+			q.Set("checksum_sha256", sums.SHA256)
+		}
+		if sums.CRC32C != "" {
+			q.Set("checksum_crc32c", sums.CRC32C)
+		}
+	}
+	q.Set("signature", l.sign(method, bucket, key, contentType, size, sums, uploadID, partNumber, exp))
+
+	u := l.baseURL + LocalObjectsPath + url.PathEscape(bucket) + "/" + escapeKey(key)
+	return u + "?" + q.Encode(), nil
+}
+
+func (l *LocalStore) verify(method, bucket, key, contentType string, size int64, sums Checksums, uploadID string, partNumber int32, exp, sig string) error {
+	// HEAD is allowed on GET signatures, like S3
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	if method == http.MethodGet {
+		contentType = ""
+		sums = Checksums{}
+	}
+
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return errors.New("invalid expires")
+	}
+	if time.Now().Unix() > expUnix {
+		return errors.New("url expired")
+	}
+
+	want := l.sign(method, bucket, key, contentType, size, sums, uploadID, partNumber, exp)
+	if !hmac.Equal([]byte(want), []byte(sig)) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+func (l *LocalStore) verifyPolicy(policy, sig string) (*localPostPolicy, error) {
+	if !hmac.Equal([]byte(l.signPolicy(policy)), []byte(sig)) {
+		return nil, errors.New("invalid signature")
+	}
+	b, err := base64.StdEncoding.DecodeString(policy)
+	if err != nil {
+		return nil, errors.New("invalid policy")
+	}
+	var p localPostPolicy
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, errors.New("invalid policy")
+	}
+	if time.Now().Unix() > p.Expiration {
+		return nil, errors.New("policy expired")
+	}
+	return &p, nil
+}
+
+func (l *LocalStore) signPolicy(policy string) string {
+	mac := hmac.New(sha256.New, l.secret)
+	fmt.Fprintf(mac, "%s\n%s", http.MethodPost, policy)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (l *LocalStore) sign(method, bucket, key, contentType string, size int64, sums Checksums, uploadID string, partNumber int32, exp string) string {
+	mac := hmac.New(sha256.New, l.secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%d\n%s\n%s\n%s\n%d\n%s", method, bucket, key, contentType, size, sums.SHA256, sums.CRC32C, uploadID, partNumber, exp)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// escapeKey path-escapes each segment of an object key while keeping the slashes.
+func escapeKey(key string) string {
+	segs := strings.Split(key, "/")
+	for i, s := range segs {
+		segs[i] = url.PathEscape(s)
+	}
+	return strings.Join(segs, "/")
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestLocalStore returns a LocalStore whose URLs point at a test server serving it.
+func newTestLocalStore(t *testing.T) *LocalStore {
+	t.Helper()
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	l, err := NewLocalStore(t.TempDir(), srv.URL, []byte("test-secret"))
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	mux.Handle(LocalObjectsPath, l)
+	return l
+}
+
+// postForm posts fields, then content as the file field, to url and returns the status code
+// and response body.
+func postForm(t *testing.T, url string, fields map[string]string, content string) (int, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		if err := mw.WriteField(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fw, err := mw.CreateFormFile("file", "notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(fw, content)
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(url, mw.FormDataContentType(), &buf)
+	if err != nil {
+		t.Fatalf("POST form: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, strings.TrimSpace(string(body))
+}
+
+func TestLocalPostPolicy(t *testing.T) {
+	l := newTestLocalStore(t)
+	ctx := t.Context()
+	cond := PostConditions{ContentType: "text/plain", MinSize: 5, MaxSize: 5}
+	post, err := l.PresignPost(ctx, "bucket", "uploads/notes.txt", cond, time.Minute)
+	if err != nil {
+		t.Fatalf("PresignPost: %v", err)
+	}
+
+	// with returns the presigned fields with overrides applied
+	with := func(overrides map[string]string) map[string]string {
+		fields := make(map[string]string, len(post.Fields))
+		for k, v := range post.Fields {
+			fields[k] = v
+		}
+		for k, v := range overrides {
+			fields[k] = v
+		}
+		return fields
+	}
+
+	tests := []struct {
+		name    string
+		fields  map[string]string
+		content string
+		code    int
+		body    string
+	}{
+		{"larger than MaxSize", post.Fields, "hello, world", http.StatusBadRequest, errEntityTooLarge.Error()},
+		{"smaller than MinSize", post.Fields, "hi", http.StatusBadRequest, "body is smaller than the policy allows"},
+		{"wrong key", with(map[string]string{"key": "uploads/other.txt"}), "hello", http.StatusForbidden, "key does not match policy"},
+		{"wrong content type", with(map[string]string{"Content-Type": "text/html"}), "hello", http.StatusForbidden, "content type does not match policy"},
+		{"tampered policy", with(map[string]string{"policy": post.Fields["policy"] + "e30="}), "hello", http.StatusForbidden, "invalid signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := postForm(t, post.URL, tt.fields, tt.content)
+			if code != tt.code || body != tt.body {
+				t.Errorf("POST = %d %q, want %d %q", code, body, tt.code, tt.body)
+			}
+			if _, _, err := l.HeadObject(ctx, "bucket", tt.fields["key"]); err == nil {
+				t.Errorf("the refused body was stored at %s", tt.fields["key"])
+			}
+		})
+	}
+
+	// a form for another bucket is refused even with a valid policy
+	other := strings.TrimSuffix(post.URL, "bucket") + "other"
+	if code, body := postForm(t, other, post.Fields, "hello"); code != http.StatusForbidden || body != "bucket does not match policy" {
+		t.Errorf("POST to another bucket = %d %q, want 403", code, body)
+	}
+
+	if code, body := postForm(t, post.URL, post.Fields, "hello"); code != http.StatusNoContent {
+		t.Fatalf("POST = %d %q, want 204", code, body)
+	}
+	if size, _, err := l.HeadObject(ctx, "bucket", "uploads/notes.txt"); err != nil || size != 5 {
+		t.Errorf("HeadObject = %d, %v; want the 5 bytes posted", size, err)
+	}
+}
+
+func TestLocalPostPolicyExpired(t *testing.T) {
+	l := newTestLocalStore(t)
+	post, err := l.PresignPost(t.Context(), "bucket", "uploads/notes.txt", PostConditions{}, -time.Second)
+	if err != nil {
+		t.Fatalf("PresignPost: %v", err)
+	}
+	if code, body := postForm(t, post.URL, post.Fields, "hello"); code != http.StatusForbidden || body != "policy expired" {
+		t.Errorf("POST with an expired policy = %d %q, want 403 policy expired", code, body)
+	}
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileMailer captures email instead of sending it: every message is written to dir as an
+// .eml file that mail clients open directly and tests can read back.
+type FileMailer struct {
+	dir string
+}
+
+// NewFileMailer returns a mailer that writes into dir, creating it if needed.
+func NewFileMailer(dir string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileMailer{dir: dir}, nil
+}
+
+// Send writes e to <dir>/<unix nanos>-<random>.eml. The file appears atomically.
+func (m *FileMailer) Send(ctx context.Context, e Email) error {
+	msg, err := formatMessage(e)
+	if err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), hex.EncodeToString(suffix))
+
+	tmp, err := os.CreateTemp(m.dir, ".mail-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(msg); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(m.dir, name))
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readMail parses a captured .eml file into its headers and its text/plain and text/html
+// bodies (html is empty for a plain-text message).
+func readMail(t *testing.T, path string) (mail.Header, string, string) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	msg, err := mail.ReadMessage(f)
+	if err != nil {
+		t.Fatalf("parse %s: %v", path, err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("content type: %v", err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return msg.Header, decodeQP(t, msg.Body), ""
+	}
+	var text, html string
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read part: %v", err)
+		}
+		switch {
+		case strings.HasPrefix(p.Header.Get("Content-Type"), "text/plain"):
+			text = decodeQP(t, p)
+		case strings.HasPrefix(p.Header.Get("Content-Type"), "text/html"):
+			html = decodeQP(t, p)
+		}
+	}
+	return msg.Header, text, html
+}
+
+func decodeQP(t *testing.T, r io.Reader) string {
+	t.Helper()
+	b, err := io.ReadAll(quotedprintable.NewReader(r))
+	if err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	return strings.ReplaceAll(string(b), "\r\n", "\n")
+}
+
+func TestFileMailerSend(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m, err := NewFileMailer(dir)
+	if err != nil {
+		t.Fatalf("NewFileMailer: %v", err)
+	}
+
+	link := "https://files.example.com/s/0123456789abcdef?file_id=1"
+	e := Email{
+		From:    "weTransfer <noreply@example.com>",
+		To:      "anna@example.com",
+		Subject: "Dateien zum Download bereit – 2 Dateien",
+		Text:    "Download link:\n" + link + "\n",
+		HTML:    `<p><a href="` + link + `">Download</a></p>`,
+	}
+	if err := m.Send(t.Context(), e); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("captured %d messages, want 1", len(matches))
+	}
+	h, text, html := readMail(t, matches[0])
+
+	to, err := h.AddressList("To")
+	if err != nil || len(to) != 1 || to[0].Address != "anna@example.com" {
+		t.Errorf("To = %q, want anna@example.com", h.Get("To"))
+	}
+	from, err := h.AddressList("From")
+	if err != nil || len(from) != 1 || from[0].Address != "noreply@example.com" || from[0].Name != "weTransfer" {
+		t.Errorf("From = %q", h.Get("From"))
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(h.Get("Subject"))
+	if err != nil || subject != e.Subject {
+		t.Errorf("Subject = %q, want %q", subject, e.Subject)
+	}
+	if h.Get("Message-ID") == "" || h.Get("Date") == "" {
+		t.Error("Message-ID or Date missing")
+	}
+	if text != e.Text {
+		t.Errorf("text body = %q, want %q", text, e.Text)
+	}
+	if !strings.Contains(html, `href="`+link+`"`) {
+		t.Errorf("html body = %q, want a link to %s", html, link)
+	}
+
+	// nothing but the message is left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("mail dir holds %d files, want 1", len(entries))
+	}
+}
+
+func TestFileMailerPlainText(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewFileMailer(dir)
+	if err != nil {
+		t.Fatalf("NewFileMailer: %v", err)
+	}
+	if err := m.Send(t.Context(), Email{From: "noreply@example.com", To: "ben@example.com", Subject: "Hi", Text: "plain"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(matches) != 1 {
+		t.Fatalf("captured %d messages, want 1", len(matches))
+	}
+	h, text, html := readMail(t, matches[0])
+	if !strings.HasPrefix(h.Get("Content-Type"), "text/plain") || text != "plain" || html != "" {
+		t.Errorf("Content-Type %q, text %q, html %q; want a plain-text message", h.Get("Content-Type"), text, html)
+	}
+}
+
+func TestFileMailerInvalidAddress(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewFileMailer(dir)
+	if err != nil {
+		t.Fatalf("NewFileMailer: %v", err)
+	}
+	if err := m.Send(t.Context(), Email{From: "noreply@example.com", To: "not an address", Subject: "Hi", Text: "x"}); err == nil {
+		t.Fatal("Send to an invalid address succeeded")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("mail dir holds %d files after a failed send, want 0", len(entries))
+	}
+}
@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DeadLetterSuffix names the dead-letter queue MemoryBroker pairs with every queue.
+const DeadLetterSuffix = "-dlq"
+
+// ErrInvalidReceipt is returned by MemoryBroker.Delete for a receipt handle that is not
+// the message's latest receive, e.g. after its visibility timeout ran out.
+var ErrInvalidReceipt = errors.New("invalid receipt handle")
+
+// MemoryBroker is an in-process stand-in for SNS topics fanned out to SQS queues, for
+// development and tests. It follows SQS semantics: a received message is hidden for its
+// visibility timeout and delivered again unless deleted, and a message received more than
+// maxReceives times moves to the queue's dead-letter queue (queue + DeadLetterSuffix).
+// Nothing survives a restart.
+type MemoryBroker struct {
+	maxReceives int
+
+	mu     sync.Mutex
+	topics map[string][]string // topic -> subscribed queues
+	queues map[string][]*brokerMessage
+	// notify is closed and replaced whenever a message is enqueued, waking long polls.
+	notify chan struct{}
+}
+
+type brokerMessage struct {
+	Message
+	visibleAt time.Time
+}
+
+// NewMemoryBroker returns an empty broker. maxReceives <= 0 disables the dead-letter queue.
+func NewMemoryBroker(maxReceives int) *MemoryBroker {
+	return &MemoryBroker{
+		maxReceives: maxReceives,
+		topics:      make(map[string][]string),
+		queues:      make(map[string][]*brokerMessage),
+		notify:      make(chan struct{}),
+	}
+}
+
+// Subscribe delivers every message published to topic to queue, creating the queue.
+func (b *MemoryBroker) Subscribe(topic, queue string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.topics[topic] = append(b.topics[topic], queue)
+	if _, ok := b.queues[queue]; !ok {
+		b.queues[queue] = nil
+	}
+}
+
+// CreateQueue creates queue, for messages sent to it directly rather than through a topic.
+func (b *MemoryBroker) CreateQueue(queue string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.queues[queue]; !ok {
+		b.queues[queue] = nil
+	}
+}
+
+// Publish wraps message in an SNS notification envelope and enqueues it on every
+// subscribed queue, as SNS does for SQS subscriptions.
+func (b *MemoryBroker) Publish(ctx context.Context, topic, subject, message string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	queues, ok := b.topics[topic]
+	if !ok {
+		return fmt.Errorf("topic %q does not exist", topic)
+	}
+
+	id := randomID()
+	body, err := json.Marshal(map[string]string{
+		"Type":      "Notification",
+		"MessageId": id,
+		"TopicArn":  topic,
+		"Subject":   subject,
+		"Message":   message,
+		"Timestamp": time.Now().UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return err
+	}
+	for _, q := range queues {
+		b.enqueue(q, Message{ID: id, Body: string(body)})
+	}
+	return nil
+}
+
+// SendMessage enqueues body on queue directly, without a topic.
+func (b *MemoryBroker) SendMessage(ctx context.Context, queue, body string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.queues[queue]; !ok {
+		return fmt.Errorf("queue %q does not exist", queue)
+	}
+	b.enqueue(queue, Message{ID: randomID(), Body: body})
+	return nil
+}
+
+// CheckQueue reports whether queue exists.
+func (b *MemoryBroker) CheckQueue(ctx context.Context, queue string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.queues[queue]; !ok {
+		return fmt.Errorf("queue %q does not exist", queue)
+	}
+	return nil
+}
+
+// Receive returns up to max visible messages, waiting up to wait for one to arrive.
+func (b *MemoryBroker) Receive(ctx context.Context, queue string, max int, wait, visibility time.Duration) ([]Message, error) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		b.mu.Lock()
+		if _, ok := b.queues[queue]; !ok {
+			b.mu.Unlock()
+			return nil, fmt.Errorf("queue %q does not exist", queue)
+		}
+		msgs, next := b.receive(queue, max, visibility)
+		notify := b.notify
+		b.mu.Unlock()
+
+		if len(msgs) > 0 {
+			return msgs, nil
+		}
+
+		// sleep until a publish, the next hidden message becomes visible, or the wait ends
+		var reappear <-chan time.Time
+		if !next.IsZero() {
+			reappear = time.After(time.Until(next))
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+			return nil, nil
+		case <-notify:
+		case <-reappear:
+		}
+	}
+}
+
+// Delete removes the message received with receiptHandle.
+func (b *MemoryBroker) Delete(ctx context.Context, queue, receiptHandle string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	msgs := b.queues[queue]
+	for i, m := range msgs {
+		if m.ReceiptHandle == receiptHandle {
+			b.queues[queue] = append(msgs[:i], msgs[i+1:]...)
+			return nil
+		}
+	}
+	return ErrInvalidReceipt
+}
+
+// ChangeVisibility hides the message received with receiptHandle for timeout from now.
+func (b *MemoryBroker) ChangeVisibility(ctx context.Context, queue, receiptHandle string, timeout time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, m := range b.queues[queue] {
+		if m.ReceiptHandle == receiptHandle {
+			m.visibleAt = time.Now().Add(timeout)
+			return nil
+		}
+	}
+	return ErrInvalidReceipt
+}
+
+// Len returns the number of messages in queue, visible or not.
+func (b *MemoryBroker) Len(queue string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.queues[queue])
+}
+
+// receive hands out visible messages of queue and returns the time the earliest hidden
+// one becomes visible again (zero if none). Callers must hold b.mu.
+func (b *MemoryBroker) receive(queue string, max int, visibility time.Duration) ([]Message, time.Time) {
+	now := time.Now()
+	var out []Message
+	var next time.Time
+	kept := b.queues[queue][:0]
+	for _, m := range b.queues[queue] {
+		if m.visibleAt.After(now) || len(out) >= max {
+			if m.visibleAt.After(now) && (next.IsZero() || m.visibleAt.Before(next)) {
+				next = m.visibleAt
+			}
+			kept = append(kept, m)
+			continue
+		}
+		if b.maxReceives > 0 && m.ReceiveCount >= b.maxReceives {
+			// received too often without being deleted
+			b.enqueue(queue+DeadLetterSuffix, Message{ID: m.ID, Body: m.Body})
+			continue
+		}
+		m.ReceiveCount++
+		m.ReceiptHandle = randomID()
+		m.visibleAt = now.Add(visibility)
+		out = append(out, m.Message)
+		kept = append(kept, m)
+	}
+	b.queues[queue] = kept
+	return out, next
+}
+
+// enqueue appends a visible message to queue. Callers must hold b.mu.
+func (b *MemoryBroker) enqueue(queue string, m Message) {
+	b.queues[queue] = append(b.queues[queue], &brokerMessage{Message: m})
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+func randomID() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -2,11 +2,14 @@ package storage
 
 import (
 	"context"
+	"io"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 type S3 struct {
@@ -28,12 +31,23 @@ func NewS3(ctx context.Context) (*S3, error) {
 }
 
 // PresignPutURL returns a presigned PUT URL for the given bucket/key and content type.
-func (s *S3) PresignPutURL(ctx context.Context, bucket, key, contentType string, expires time.Duration) (string, error) {
+// The size and checksum are signed into the URL, so S3 rejects a body that doesn't match them.
+func (s *S3) PresignPutURL(ctx context.Context, bucket, key, contentType string, size int64, sums Checksums, expires time.Duration) (string, error) {
 	input := &s3.PutObjectInput{
 		Bucket:      aws.String(bucket),
 		Key:         aws.String(key),
 		ContentType: aws.String(contentType),
 	}
+	if size > 0 {
+		// signed as the Content-Length header
+		input.ContentLength = aws.Int64(size)
+	}
+	switch name, value := sums.Header(); name {
+	case "x-amz-checksum-sha256":
+		input.ChecksumSHA256 = aws.String(value)
+	case "x-amz-checksum-crc32c":
+		input.ChecksumCRC32C = aws.String(value)
+	}
 
 	resp, err := s.presign.PresignPutObject(ctx, input, s3.WithPresignExpires(expires))
 	if err != nil {
@@ -43,6 +57,44 @@ func (s *S3) PresignPutURL(ctx context.Context, bucket, key, contentType string,
 	return resp.URL, nil
 }
 
+// maxPostSize is the largest object S3 accepts in a POST upload.
+const maxPostSize = 5 << 30
+
+// PresignPost returns a presigned POST form for the given bucket/key whose policy carries
+// the key, content type and content-length-range conditions.
+func (s *S3) PresignPost(ctx context.Context, bucket, key string, cond PostConditions, expires time.Duration) (*PresignedPost, error) {
+	maxSize := cond.MaxSize
+	if maxSize <= 0 || maxSize > maxPostSize {
+		maxSize = maxPostSize
+	}
+	conditions := []interface{}{
+		[]interface{}{"content-length-range", cond.MinSize, maxSize},
+	}
+	if cond.KeyPrefix != "" {
+		conditions = append(conditions, []interface{}{"starts-with", "$key", cond.KeyPrefix})
+	}
+	if cond.ContentType != "" {
+		conditions = append(conditions, map[string]string{"Content-Type": cond.ContentType})
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	resp, err := s.presign.PresignPostObject(ctx, input, func(o *s3.PresignPostOptions) {
+		o.Expires = expires
+		o.Conditions = conditions
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if cond.ContentType != "" {
+		resp.Values["Content-Type"] = cond.ContentType
+	}
+	return &PresignedPost{URL: resp.URL, Fields: resp.Values}, nil
+}
+
 // PresignGetURL returns a presigned GET URL for the given bucket/key.
 func (s *S3) PresignGetURL(ctx context.Context, bucket, key string, expires time.Duration) (string, error) {
 	input := &s3.GetObjectInput{
@@ -67,6 +119,29 @@ func (s *S3) DeleteObject(ctx context.Context, bucket, key string) error {
 	return err
 }
 
+// GetObject opens an object for streaming. The caller must close the returned body.
+func (s *S3) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	output, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.Body, nil
+}
+
+// PutObject uploads body to S3. body should be seekable (e.g. *os.File) so the SDK can sign it.
+func (s *S3) PutObject(ctx context.Context, bucket, key, contentType string, body io.Reader) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+		Body:        body,
+	})
+	return err
+}
+
 // HeadObject retrieves metadata for an object from S3.
 // Returns size (ContentLength) and contentType (ContentType).
 func (s *S3) HeadObject(ctx context.Context, bucket, key string) (int64, string, error) {
@@ -90,3 +165,142 @@ func (s *S3) HeadObject(ctx context.Context, bucket, key string) (int64, string,
 
 	return size, contentType, nil
 }
+
+// ObjectChecksums returns the SHA-256 and CRC32C checksums S3 stored with an object.
+// Composite checksums of multipart uploads ("<base64>-<parts>") are not full-object
+// checksums and are left out.
+func (s *S3) ObjectChecksums(ctx context.Context, bucket, key string) (Checksums, error) {
+	output, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		return Checksums{}, err
+	}
+
+	var sums Checksums
+	if v := aws.ToString(output.ChecksumSHA256); !strings.Contains(v, "-") {
+		sums.SHA256 = v
+	}
+	if v := aws.ToString(output.ChecksumCRC32C); !strings.Contains(v, "-") {
+		sums.CRC32C = v
+	}
+	return sums, nil
+}
+
+// CreateMultipartUpload starts a multipart upload and returns its upload ID.
+func (s *S3) CreateMultipartUpload(ctx context.Context, bucket, key, contentType string, partChecksums bool) (string, error) {
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	}
+	if partChecksums {
+		input.ChecksumAlgorithm = types.ChecksumAlgorithmSha256
+	}
+	output, err := s.client.CreateMultipartUpload(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(output.UploadId), nil
+}
+
+// PresignUploadPartURL returns a presigned PUT URL for a single part of a multipart upload.
+// A sha256 is signed as the x-amz-checksum-sha256 header, which S3 checks against the body.
+func (s *S3) PresignUploadPartURL(ctx context.Context, bucket, key, uploadID string, partNumber int32, size int64, sha256 string, expires time.Duration) (string, error) {
+	input := &s3.UploadPartInput{
+		Bucket:     aws.String(bucket),
+		Key:        aws.String(key),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int32(partNumber),
+	}
+	if size > 0 {
+		input.ContentLength = aws.Int64(size)
+	}
+	if sha256 != "" {
+		input.ChecksumSHA256 = aws.String(sha256)
+	}
+
+	resp, err := s.presign.PresignUploadPart(ctx, input, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", err
+	}
+
+	return resp.URL, nil
+}
+
+// UploadPart uploads size bytes of body as one part of a multipart upload.
+func (s *S3) UploadPart(ctx context.Context, bucket, key, uploadID string, partNumber int32, body io.ReadSeeker, size int64) (Part, error) {
+	output, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(bucket),
+		Key:           aws.String(key),
+		UploadId:      aws.String(uploadID),
+		PartNumber:    aws.Int32(partNumber),
+		Body:          body,
+		ContentLength: aws.Int64(size),
+	})
+	if err != nil {
+		return Part{}, err
+	}
+	return Part{PartNumber: partNumber, ETag: aws.ToString(output.ETag), Size: size}, nil
+}
+
+// ListParts returns every part uploaded so far, ordered by part number.
+func (s *S3) ListParts(ctx context.Context, bucket, key, uploadID string) ([]Part, error) {
+	paginator := s3.NewListPartsPaginator(s.client, &s3.ListPartsInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+
+	var parts []Part
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range page.Parts {
+			parts = append(parts, Part{
+				PartNumber:     aws.ToInt32(p.PartNumber),
+				ETag:           aws.ToString(p.ETag),
+				Size:           aws.ToInt64(p.Size),
+				ChecksumSHA256: aws.ToString(p.ChecksumSHA256),
+			})
+		}
+	}
+	return parts, nil
+}
+
+// CompleteMultipartUpload assembles the given parts into the final object.
+func (s *S3) CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, parts []Part) error {
+	completed := make([]types.CompletedPart, 0, len(parts))
+	for _, p := range parts {
+		part := types.CompletedPart{
+			PartNumber: aws.Int32(p.PartNumber),
+			ETag:       aws.String(p.ETag),
+		}
+		if p.ChecksumSHA256 != "" {
+			part.ChecksumSHA256 = aws.String(p.ChecksumSHA256)
+		}
+		completed = append(completed, part)
+	}
+
+	_, err := s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	return err
+}
+
+// AbortMultipartUpload discards a multipart upload and any parts already stored.
+func (s *S3) AbortMultipartUpload(ctx context.Context, bucket, key, uploadID string) error {
+	_, err := s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	return err
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// S3Event is an S3 event notification, as S3 sends it to an SQS queue or SNS topic.
+type S3Event struct {
+	Records []S3EventRecord `json:"Records"`
+}
+
+// S3EventRecord is one object event of a notification.
+type S3EventRecord struct {
+	EventSource string    `json:"eventSource"`
+	EventName   string    `json:"eventName"`
+	EventTime   time.Time `json:"eventTime"`
+	S3          struct {
+		Bucket struct {
+			Name string `json:"name"`
+		} `json:"bucket"`
+		Object struct {
+			// Key is URL-encoded as in the notification; use ObjectKey for the real key.
+			Key       string `json:"key"`
+			Size      int64  `json:"size"`
+			ETag      string `json:"eTag"`
+			Sequencer string `json:"sequencer"`
+		} `json:"object"`
+	} `json:"s3"`
+}
+
+// ObjectCreated reports whether the record is one of the s3:ObjectCreated:* events.
+func (r S3EventRecord) ObjectCreated() bool {
+	return strings.HasPrefix(r.EventName, "ObjectCreated:")
+}
+
+// ObjectKey returns the decoded object key.
+func (r S3EventRecord) ObjectKey() (string, error) {
+	return url.QueryUnescape(r.S3.Object.Key)
+}
+
+// ParseS3Event decodes a queue message carrying an S3 notification, delivered to SQS
+// directly or through an SNS topic. The s3:TestEvent S3 sends when notifications are
+// configured parses to an event without records.
+func ParseS3Event(body string) (*S3Event, error) {
+	var envelope struct {
+		Type    string `json:"Type"`
+		Message string `json:"Message"`
+	}
+	if err := json.Unmarshal([]byte(body), &envelope); err != nil {
+		return nil, fmt.Errorf("parse message: %w", err)
+	}
+	if envelope.Type == "Notification" {
+		body = envelope.Message
+	}
+
+	var e S3Event
+	if err := json.Unmarshal([]byte(body), &e); err != nil {
+		return nil, fmt.Errorf("parse s3 event: %w", err)
+	}
+	return &e, nil
+}
+
+// ObjectCreatedEvent returns the notification S3 sends when bucket/key is uploaded with a
+// PUT, for injecting synthetic events, e.g. with MemoryBroker.SendMessage.
+func ObjectCreatedEvent(bucket, key string, size int64) (string, error) {
+	var r S3EventRecord
+	r.EventSource = "aws:s3"
+	r.EventName = "ObjectCreated:Put"
+	r.EventTime = time.Now().UTC()
+	r.S3.Bucket.Name = bucket
+	// S3 encodes keys like form values but leaves the slashes alone
+	r.S3.Object.Key = strings.ReplaceAll(url.QueryEscape(key), "%2F", "/")
+	r.S3.Object.Size = size
+	r.S3.Object.Sequencer = fmt.Sprintf("%016X", r.EventTime.UnixNano())
+
+	b, err := json.Marshal(S3Event{Records: []S3EventRecord{r}})
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// ScanResult is the verdict of a malware scan.
+type ScanResult struct {
+	Infected bool
+	// Signature names what was found, e.g. "Eicar-Test-Signature".
+	Signature string
+}
+
+// Scanner checks an object body for malware. ClamdScanner talks to a ClamAV daemon;
+// NoopScanner passes everything and stands in where no scanner runs.
+type Scanner interface {
+	Scan(ctx context.Context, body io.Reader) (ScanResult, error)
+}
+
+var (
+	_ Scanner = (*ClamdScanner)(nil)
+	_ Scanner = NoopScanner{}
+)
+
+// NoopScanner reports every body clean without reading it.
+type NoopScanner struct{}
+
+// Scan returns a clean result.
+func (NoopScanner) Scan(ctx context.Context, body io.Reader) (ScanResult, error) {
+	return ScanResult{}, nil
+}
+
+// clamdChunkSize is the size of the INSTREAM chunks sent to clamd.
+const clamdChunkSize = 64 << 10
+
+// ClamdScanner streams bodies to clamd with the INSTREAM command. Bodies larger than
+// clamd's StreamMaxLength are reported as errors, not as clean.
+type ClamdScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamdScanner returns a scanner for the clamd at addr: "tcp://host:port",
+// "unix:///path/to/clamd.sock" or a bare "host:port". timeout bounds one scan.
+func NewClamdScanner(addr string, timeout time.Duration) (*ClamdScanner, error) {
+	network, address := "tcp", addr
+	switch {
+	case strings.HasPrefix(addr, "tcp://"):
+		address = strings.TrimPrefix(addr, "tcp://")
+	case strings.HasPrefix(addr, "unix://"):
+		network, address = "unix", strings.TrimPrefix(addr, "unix://")
+	}
+	if address == "" {
+		return nil, fmt.Errorf("invalid clamd address %q", addr)
+	}
+	return &ClamdScanner{network: network, address: address, timeout: timeout}, nil
+}
+
+// Scan sends body to clamd and parses its reply.
+func (c *ClamdScanner) Scan(ctx context.Context, body io.Reader) (ScanResult, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return ScanResult{}, fmt.Errorf("connect to clamd: %w", err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(c.timeout)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return ScanResult{}, err
+	}
+
+	// the z prefix makes clamd expect and send null-terminated commands and replies
+	if _, err := io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+		return ScanResult{}, fmt.Errorf("send INSTREAM: %w", err)
+	}
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, rerr := io.ReadFull(body, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				// clamd closes the stream once StreamMaxLength is exceeded; its reply says so
+				break
+			}
+		}
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		}
+		if rerr != nil {
+			return ScanResult{}, fmt.Errorf("read object: %w", rerr)
+		}
+	}
+	// a zero-length chunk ends the stream
+	_, _ = conn.Write([]byte{0, 0, 0, 0})
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return ScanResult{}, fmt.Errorf("read clamd reply: %w", err)
+	}
+	return parseClamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseClamdReply interprets "stream: OK", "stream: <signature> FOUND" and error replies.
+func parseClamdReply(reply string) (ScanResult, error) {
+	msg := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case msg == "OK":
+		return ScanResult{}, nil
+	case strings.HasSuffix(msg, " FOUND"):
+		return ScanResult{Infected: true, Signature: strings.TrimSuffix(msg, " FOUND")}, nil
+	case strings.HasSuffix(msg, " ERROR"):
+		return ScanResult{}, fmt.Errorf("clamd: %s", strings.TrimSuffix(msg, " ERROR"))
+	}
+	return ScanResult{}, errors.New("unexpected clamd reply: " + reply)
+}
@@ -24,25 +24,32 @@ func NewSES(ctx context.Context) (*SES, error) {
 	return &SES{client: client}, nil
 }
 
-// SendEmail sends a simple text email via SES.
-func (s *SES) SendEmail(ctx context.Context, from, to, subject, body string) error {
+// Send sends an email via SES, with an HTML alternative when e.HTML is set.
+func (s *SES) Send(ctx context.Context, e Email) error {
 	input := &ses.SendEmailInput{
 		Destination: &types.Destination{
-			ToAddresses: []string{to},
+			ToAddresses: []string{e.To},
 		},
 		Message: &types.Message{
 			Body: &types.Body{
 				Text: &types.Content{
 					Charset: aws.String("UTF-8"),
-					Data:    aws.String(body),
+					Data:    aws.String(e.Text),
 				},
 			},
 			Subject: &types.Content{
 				Charset: aws.String("UTF-8"),
-				Data:    aws.String(subject),
+				Data:    aws.String(e.Subject),
 			},
 		},
-		Source: aws.String(from),
+		Source: aws.String(e.From),
+	}
+
+	if e.HTML != "" {
+		input.Message.Body.Html = &types.Content{
+			Charset: aws.String("UTF-8"),
+			Data:    aws.String(e.HTML),
+		}
 	}
 
 	_, err := s.client.SendEmail(ctx, input)
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+)
+
+// SMTPConfig configures an SMTPMailer.
+type SMTPConfig struct {
+	Host string
+	Port int
+	// Username and Password enable AUTH PLAIN; both empty sends unauthenticated.
+	Username string
+	Password string
+	// StartTLS requires the server to upgrade the connection before anything is sent.
+	// Without it the connection is upgraded only if the server offers STARTTLS.
+	StartTLS bool
+}
+
+// SMTPMailer sends email through an SMTP relay, e.g. an on-prem MTA or mailpit.
+type SMTPMailer struct {
+	cfg SMTPConfig
+}
+
+// NewSMTPMailer returns a mailer for the relay in cfg.
+func NewSMTPMailer(cfg SMTPConfig) (*SMTPMailer, error) {
+	if cfg.Host == "" {
+		return nil, errors.New("smtp host must not be empty")
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 587
+	}
+	return &SMTPMailer{cfg: cfg}, nil
+}
+
+// Send delivers e in a new SMTP session.
+func (m *SMTPMailer) Send(ctx context.Context, e Email) error {
+	msg, err := formatMessage(e)
+	if err != nil {
+		return err
+	}
+	from, _ := mail.ParseAddress(e.From)
+	to, _ := mail.ParseAddress(e.To)
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(m.cfg.Host, strconv.Itoa(m.cfg.Port)))
+	if err != nil {
+		return err
+	}
+	// net/smtp has no context support; the deadline bounds the whole session instead
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, m.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: m.cfg.Host}); err != nil {
+			return err
+		}
+	} else if m.cfg.StartTLS {
+		return errors.New("smtp server does not support STARTTLS")
+	}
+
+	if m.cfg.Username != "" || m.cfg.Password != "" {
+		// PlainAuth refuses to send credentials over an unencrypted connection to a remote host
+		if err := c.Auth(smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to.Address); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
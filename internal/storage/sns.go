@@ -2,7 +2,6 @@ package storage
 
 import (
 	"context"
-	"encoding/json"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -24,28 +23,46 @@ func NewSNS(ctx context.Context) (*SNS, error) {
 	return &SNS{client: client}, nil
 }
 
-// ShareDownloadMessage is the event published to SNS for email sharing
+// ShareDownloadMessage is the event published to SNS for email sharing.
+// DownloadURL is only set for single-file transfers; Files always lists every file.
+// The URLs are API links that presign on click and work until ExpiresAt, the transfer's
+// expiry; messages published by older versions carry presigned S3 URLs instead.
 type ShareDownloadMessage struct {
-	EventType   string   `json:"event_type"`
-	TransferID  string   `json:"transfer_id"`
-	Emails      []string `json:"emails"`
-	DownloadURL string   `json:"download_url"`
-	ExpiresAt   string   `json:"expires_at"`
-	Filename    string   `json:"filename"`
-	FileSize    int64    `json:"file_size"`
+	EventType   string       `json:"event_type"`
+	TransferID  string       `json:"transfer_id"`
+	ShareID     string       `json:"share_id,omitempty"`
+	Emails      []string     `json:"emails"`
+	DownloadURL string       `json:"download_url,omitempty"`
+	ExpiresAt   string       `json:"expires_at"`
+	Filename    string       `json:"filename"`
+	FileSize    int64        `json:"file_size"`
+	Files       []SharedFile `json:"files"`
+	// Locale and OwnerID select the email templates and branding.
+	Locale  string `json:"locale,omitempty"`
+	OwnerID string `json:"owner_id,omitempty"`
+	// Links maps each email to the recipient's personal download link, which takes
+	// ?file_id= for a single file of a multi-file transfer.
+	Links map[string]string `json:"links,omitempty"`
+	// PasswordProtected transfers are downloaded with a token from UnlockURL, or from the
+	// personal link followed by /unlock.
+	PasswordProtected bool   `json:"password_protected,omitempty"`
+	UnlockURL         string `json:"unlock_url,omitempty"`
 }
 
-// PublishShareDownload publishes a share-download event to SNS
-func (s *SNS) PublishShareDownload(ctx context.Context, topicARN string, msg ShareDownloadMessage) error {
-	payload, err := json.Marshal(msg)
-	if err != nil {
-		return err
-	}
+// SharedFile is one file of a shared transfer with its own download link
+type SharedFile struct {
+	ID          string `json:"id,omitempty"`
+	Filename    string `json:"filename"`
+	FileSize    int64  `json:"file_size"`
+	DownloadURL string `json:"download_url"`
+}
 
-	_, err = s.client.Publish(ctx, &sns.PublishInput{
-		TopicArn: aws.String(topicARN),
-		Message:  aws.String(string(payload)),
-		Subject:  aws.String("File ready for download"),
+// Publish publishes message to the SNS topic with ARN topic.
+func (s *SNS) Publish(ctx context.Context, topic, subject, message string) error {
+	_, err := s.client.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(topic),
+		Message:  aws.String(message),
+		Subject:  aws.String(subject),
 	})
 	return err
 }
@@ -2,6 +2,8 @@ package storage
 
 import (
 	"context"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -35,25 +37,50 @@ func (s *SQS) CheckQueue(ctx context.Context, queueURL string) error {
 	return err
 }
 
-// ReceiveMessages polls SQS for messages
-func (s *SQS) ReceiveMessages(ctx context.Context, queueURL string, maxMessages int32, waitTime int32) ([]types.Message, error) {
+// Receive long-polls the queue for up to wait. A redrive policy on the queue moves
+// messages that are received too often to its dead-letter queue.
+func (s *SQS) Receive(ctx context.Context, queueURL string, max int, wait, visibility time.Duration) ([]Message, error) {
 	output, err := s.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
 		QueueUrl:            aws.String(queueURL),
-		MaxNumberOfMessages: maxMessages,
-		WaitTimeSeconds:     waitTime,
-		VisibilityTimeout:   60, // Give worker 60s to process email
+		MaxNumberOfMessages: int32(max),
+		WaitTimeSeconds:     int32(wait / time.Second),
+		VisibilityTimeout:   int32(visibility / time.Second),
+		MessageSystemAttributeNames: []types.MessageSystemAttributeName{
+			types.MessageSystemAttributeNameApproximateReceiveCount,
+		},
 	})
 	if err != nil {
 		return nil, err
 	}
-	return output.Messages, nil
+
+	msgs := make([]Message, 0, len(output.Messages))
+	for _, m := range output.Messages {
+		count, _ := strconv.Atoi(m.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)])
+		msgs = append(msgs, Message{
+			ID:            aws.ToString(m.MessageId),
+			Body:          aws.ToString(m.Body),
+			ReceiptHandle: aws.ToString(m.ReceiptHandle),
+			ReceiveCount:  count,
+		})
+	}
+	return msgs, nil
 }
 
-// DeleteMessage deletes a processed message from SQS
-func (s *SQS) DeleteMessage(ctx context.Context, queueURL string, receiptHandle *string) error {
+// Delete deletes a processed message from SQS
+func (s *SQS) Delete(ctx context.Context, queueURL, receiptHandle string) error {
 	_, err := s.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
 		QueueUrl:      aws.String(queueURL),
-		ReceiptHandle: receiptHandle,
+		ReceiptHandle: aws.String(receiptHandle),
+	})
+	return err
+}
+
+// ChangeVisibility sets the remaining visibility timeout of a received message (at most 12h).
+func (s *SQS) ChangeVisibility(ctx context.Context, queueURL, receiptHandle string, timeout time.Duration) error {
+	_, err := s.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(queueURL),
+		ReceiptHandle:     aws.String(receiptHandle),
+		VisibilityTimeout: int32(timeout / time.Second),
 	})
 	return err
 }
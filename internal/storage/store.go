@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// ObjectStore is the set of object operations the API needs from a storage backend.
+// S3 is the production implementation; LocalStore keeps objects on the local filesystem
+// so the API can run without AWS credentials.
+type ObjectStore interface {
+	// PresignPutURL returns a URL the client can PUT the object body to. If size is
+	// positive the PUT must send exactly size bytes. If sums is not empty the PUT must
+	// carry the header named by sums.Header and the body must match it.
+	PresignPutURL(ctx context.Context, bucket, key, contentType string, size int64, sums Checksums, expires time.Duration) (string, error)
+	// PresignGetURL returns a URL the client can GET the object from.
+	PresignGetURL(ctx context.Context, bucket, key string, expires time.Duration) (string, error)
+	// HeadObject returns the size and content type of a stored object.
+	HeadObject(ctx context.Context, bucket, key string) (int64, string, error)
+	// ObjectChecksums returns the full-object checksums the backend stored with an object.
+	// Fields it has no checksum for are empty.
+	ObjectChecksums(ctx context.Context, bucket, key string) (Checksums, error)
+	// DeleteObject removes an object. Deleting a missing object is not an error.
+	DeleteObject(ctx context.Context, bucket, key string) error
+	// GetObject opens the object body for reading. The caller must close it.
+	GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error)
+	// PutObject stores body as the object. S3 needs a seekable body such as *os.File.
+	PutObject(ctx context.Context, bucket, key, contentType string, body io.Reader) error
+}
+
+// Checksums are base64-encoded object checksums in the form S3 uses for the
+// x-amz-checksum-sha256 and x-amz-checksum-crc32c headers.
+type Checksums struct {
+	SHA256 string
+	CRC32C string
+}
+
+// IsZero reports whether no checksum is set.
+func (c Checksums) IsZero() bool {
+	return c.SHA256 == "" && c.CRC32C == ""
+}
+
+// Header returns the checksum header a presigned PUT requires: SHA-256 if it is set,
+// otherwise CRC32C. S3 accepts a single checksum per upload, so the other one can only
+// be checked by backends that compute both.
+func (c Checksums) Header() (name, value string) {
+	switch {
+	case c.SHA256 != "":
+		return "x-amz-checksum-sha256", c.SHA256
+	case c.CRC32C != "":
+		return "x-amz-checksum-crc32c", c.CRC32C
+	}
+	return "", ""
+}
+
+// Part describes one uploaded part of a multipart upload.
+type Part struct {
+	PartNumber int32  `json:"part_number"`
+	ETag       string `json:"etag"`
+	Size       int64  `json:"size"`
+	// ChecksumSHA256 is the base64 SHA-256 of the part, for uploads created with part checksums.
+	ChecksumSHA256 string `json:"checksum_sha256,omitempty"`
+}
+
+// MultipartStore is implemented by backends that support uploading an object in parts,
+// which lifts the single-PUT size limit and lets clients retry individual parts.
+type MultipartStore interface {
+	// CreateMultipartUpload starts an upload. With partChecksums every part must be sent
+	// with its SHA-256, and completing the upload names each part's checksum.
+	CreateMultipartUpload(ctx context.Context, bucket, key, contentType string, partChecksums bool) (string, error)
+	// PresignUploadPartURL returns a URL the client can PUT one part to. If size is
+	// positive the part must be exactly size bytes; if sha256 is set the part must be sent
+	// with it as x-amz-checksum-sha256 and match it.
+	PresignUploadPartURL(ctx context.Context, bucket, key, uploadID string, partNumber int32, size int64, sha256 string, expires time.Duration) (string, error)
+	// UploadPart stores a part sent through this server rather than to a presigned URL.
+	UploadPart(ctx context.Context, bucket, key, uploadID string, partNumber int32, body io.ReadSeeker, size int64) (Part, error)
+	ListParts(ctx context.Context, bucket, key, uploadID string) ([]Part, error)
+	CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, parts []Part) error
+	AbortMultipartUpload(ctx context.Context, bucket, key, uploadID string) error
+}
+
+// PostConditions constrain what a presigned POST accepts.
+type PostConditions struct {
+	// KeyPrefix, if set, accepts any key starting with it instead of only the presigned
+	// key; the form's key field may then use ${filename} for the name of the uploaded file.
+	KeyPrefix string
+	// ContentType is the only Content-Type the form may declare; empty accepts any.
+	ContentType string
+	// MinSize and MaxSize bound the body in bytes. MaxSize 0 means the backend's maximum.
+	MinSize int64
+	MaxSize int64
+}
+
+// PresignedPost is a browser form upload: a multipart/form-data POST to URL carrying Fields,
+// followed by the body in a field named "file".
+type PresignedPost struct {
+	URL    string            `json:"url"`
+	Fields map[string]string `json:"fields"`
+}
+
+// PostStore is implemented by backends that can sign form uploads. Unlike a presigned PUT,
+// the signed policy is checked by storage, so the size range is enforced as the body arrives.
+type PostStore interface {
+	PresignPost(ctx context.Context, bucket, key string, cond PostConditions, expires time.Duration) (*PresignedPost, error)
+}
+
+var (
+	_ ObjectStore    = (*S3)(nil)
+	_ ObjectStore    = (*LocalStore)(nil)
+	_ MultipartStore = (*S3)(nil)
+	_ MultipartStore = (*LocalStore)(nil)
+	_ PostStore      = (*S3)(nil)
+	_ PostStore      = (*LocalStore)(nil)
+)
@@ -3,11 +3,13 @@ package worker
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"time"
 
+	"github.com/pavithrankb/weTransfer/internal/email"
+	"github.com/pavithrankb/weTransfer/internal/repository"
 	"github.com/pavithrankb/weTransfer/internal/storage"
 )
 
@@ -18,47 +20,86 @@ type SNSEnvelope struct {
 	Message   string `json:"Message"` // This is the escaped JSON string of our actual payload
 }
 
-// StartEmailWorker starts the email worker process.
-// It is designed to run in a goroutine and blocking forever (or until context cancel if we improved it, but for now loop forever).
-func StartEmailWorker(ctx context.Context, logger *log.Logger) {
+const (
+	defaultEmailMaxAttempts = 5
+	// recipientLease keeps a second worker holding the same message off a recipient
+	// while it is being mailed
+	recipientLease = time.Minute
+)
+
+// EmailMaxAttempts is the number of sends per recipient before it is marked failed (EMAIL_MAX_ATTEMPTS).
+func EmailMaxAttempts() int {
+	if v, err := strconv.Atoi(os.Getenv("EMAIL_MAX_ATTEMPTS")); err == nil && v > 0 {
+		return v
+	}
+	return defaultEmailMaxAttempts
+}
+
+// EmailStore is the part of the repository the email worker uses.
+type EmailStore interface {
+	repository.RecipientRepository
+	repository.SuppressionRepository
+}
+
+type emailWorker struct {
+	consumer  storage.Consumer
+	mailer    storage.Mailer
+	renderer  *email.Renderer
+	store     EmailStore
+	queueURL  string
+	fromEmail string
+	logger    *log.Logger
+}
+
+// StartEmailWorker consumes share events from queueURL and emails the recipients
+// through mailer, recording each recipient in store. SES bounce and complaint notifications
+// arriving on the same queue feed the suppression list. It is designed to run in a goroutine
+// and blocks forever. A nil consumer or mailer, or an empty queueURL, disables the worker.
+func StartEmailWorker(ctx context.Context, consumer storage.Consumer, queueURL string, mailer storage.Mailer, store EmailStore, logger *log.Logger) {
 	// 1. Load Environment Variables
-	queueURL := os.Getenv("SQS_QUEUE_URL")
-	fromEmail := os.Getenv("SES_FROM_EMAIL")
-	region := os.Getenv("AWS_REGION")
+	fromEmail := os.Getenv("MAIL_FROM")
+	if fromEmail == "" {
+		fromEmail = os.Getenv("SES_FROM_EMAIL")
+	}
 
-	if queueURL == "" || fromEmail == "" || region == "" {
+	if consumer == nil || mailer == nil || queueURL == "" || fromEmail == "" {
 		// Log as warning and return, so we don't crash the main server but also don't run the worker
-		logger.Println("Email worker: Missing required environment variables (SQS_QUEUE_URL, SES_FROM_EMAIL, AWS_REGION). Worker disabled.")
+		logger.Println("Email worker: Missing message broker, mailer, queue or required environment variables (SQS_QUEUE_URL, MAIL_FROM). Worker disabled.")
 		return
 	}
 
 	// Use a separate context for the long-running worker to avoid timeout from initialization context
 	workerCtx := context.Background()
 
-	// 2. Initialize AWS Clients
-	sqsClient, err := storage.NewSQS(workerCtx)
+	// templates are parsed once; a broken override fails here instead of on every message
+	renderer, err := email.NewRenderer(os.Getenv("EMAIL_TEMPLATE_DIR"), os.Getenv("EMAIL_BRANDING_FILE"))
 	if err != nil {
-		logger.Printf("Email worker: Failed to initialize SQS: %v. Worker disabled.", err)
+		logger.Printf("Email worker: Failed to load email templates: %v. Worker disabled.", err)
 		return
 	}
 
-	sesClient, err := storage.NewSES(workerCtx)
-	if err != nil {
-		logger.Printf("Email worker: Failed to initialize SES: %v. Worker disabled.", err)
+	// Verify Queue Access
+	if err := consumer.CheckQueue(workerCtx, queueURL); err != nil {
+		logger.Printf("Email worker: Failed to access queue: %v. Worker disabled.", err)
 		return
 	}
 
-	// Verify Queue Access
-	if err := sqsClient.CheckQueue(workerCtx, queueURL); err != nil {
-		logger.Printf("Email worker: Failed to access SQS queue: %v. Worker disabled.", err)
-		return
+	w := &emailWorker{
+		consumer:  consumer,
+		mailer:    mailer,
+		renderer:  renderer,
+		store:     store,
+		queueURL:  queueURL,
+		fromEmail: fromEmail,
+		logger:    logger,
 	}
 
 	logger.Printf("Email worker started. Polling queue: %s", queueURL)
 
 	// 3. Polling Loop
 	for {
-		messages, err := sqsClient.ReceiveMessages(workerCtx, queueURL, 10, 20) // max 10 msgs, wait 20s (long polling)
+		// max 10 msgs, wait 20s (long polling), give the worker 60s to process them
+		messages, err := consumer.Receive(workerCtx, queueURL, 10, 20*time.Second, 60*time.Second)
 		if err != nil {
 			logger.Printf("Email worker: Error receiving messages: %v", err)
 			time.Sleep(5 * time.Second) // Backoff on error
@@ -74,20 +115,20 @@ func StartEmailWorker(ctx context.Context, logger *log.Logger) {
 
 		// 4. Process Messages
 		for _, msg := range messages {
-			processMessage(workerCtx, sqsClient, sesClient, queueURL, msg.Body, msg.ReceiptHandle, fromEmail, logger)
+			w.processMessage(workerCtx, msg)
 		}
 	}
 }
 
-func processMessage(ctx context.Context, sqsClient *storage.SQS, sesClient *storage.SES, queueURL string, body *string, receiptHandle *string, fromEmail string, logger *log.Logger) {
-	if body == nil {
-		return
-	}
-
+// processMessage mails every recipient of a share that hasn't got it yet. The message is
+// deleted once each recipient is sent or failed; until then it is hidden until the next
+// retry is due. A message that can't be handled at all is left in the queue, received
+// again after the visibility timeout, and ends up in the dead-letter queue.
+func (w *emailWorker) processMessage(ctx context.Context, msg storage.Message) {
 	// Parse SNS Envelope
 	var envelope SNSEnvelope
-	if err := json.Unmarshal([]byte(*body), &envelope); err != nil {
-		logger.Printf("Error parsing SNS envelope: %v. Body: %s", err, *body)
+	if err := json.Unmarshal([]byte(msg.Body), &envelope); err != nil {
+		w.logger.Printf("Error parsing SNS envelope: %v. Body: %s", err, msg.Body)
 		// If we can't parse it, it might be a raw message or malformed.
 		return
 	}
@@ -95,53 +136,140 @@ func processMessage(ctx context.Context, sqsClient *storage.SQS, sesClient *stor
 	// Parse Logic Event from envelope.Message
 	var event storage.ShareDownloadMessage
 	if err := json.Unmarshal([]byte(envelope.Message), &event); err != nil {
-		logger.Printf("Error parsing event payload: %v. Message: %s", err, envelope.Message)
+		w.logger.Printf("Error parsing event payload: %v. Message: %s", err, envelope.Message)
 		// We delete malformed application messages so they don't block
-		_ = sqsClient.DeleteMessage(ctx, queueURL, receiptHandle)
+		w.deleteMessage(ctx, msg)
+		return
+	}
+
+	// SES feedback published to the same topic
+	if n, ok := parseFeedback(envelope.Message); ok {
+		// an unrecorded notification is redelivered; suppressing twice is harmless
+		if w.handleFeedback(ctx, n) {
+			w.deleteMessage(ctx, msg)
+		}
 		return
 	}
 
 	// Only process recognized events
 	if event.EventType != "TRANSFER_SHARED" {
-		logger.Printf("Skipping unknown event type: %s", event.EventType)
-		_ = sqsClient.DeleteMessage(ctx, queueURL, receiptHandle)
+		w.logger.Printf("Skipping unknown event type: %s", event.EventType)
+		w.deleteMessage(ctx, msg)
 		return
 	}
 
-	logger.Printf("Processing TRANSFER_SHARED event for transfer %s. Recipients: %d", event.TransferID, len(event.Emails))
-
-	// Send Emails
-	emailSubject := "File ready for download"
-	emailBody := fmt.Sprintf(`A file has been shared with you.
+	// messages published before the outbox carry no share id
+	shareID := event.ShareID
+	if shareID == "" {
+		shareID = envelope.MessageId
+	}
 
-File: %s
-Size: %d bytes
+	w.logger.Printf("Processing TRANSFER_SHARED event for transfer %s share %s. Recipients: %d", event.TransferID, shareID, len(event.Emails))
 
-Download link:
-%s
+	// a redelivered message finds the recipients that already got the mail marked sent
+	recipients, err := w.store.EnsureRecipients(ctx, shareID, event.TransferID, event.Emails)
+	if err != nil {
+		w.logger.Printf("Failed to load recipients of share %s: %v", shareID, err)
+		return
+	}
 
-Note: This link will expire at %s.
-`, event.Filename, event.FileSize, event.DownloadURL, event.ExpiresAt)
+	// addresses that bounced or complained since the share was accepted are not mailed
+	suppressed, err := w.store.Suppressed(ctx, event.Emails)
+	if err != nil {
+		w.logger.Printf("Failed to check suppressions of share %s: %v", shareID, err)
+		return
+	}
+	isSuppressed := make(map[string]bool, len(suppressed))
+	for _, e := range suppressed {
+		isSuppressed[e] = true
+	}
 
-	successCount := 0
-	for _, recipient := range event.Emails {
-		err := sesClient.SendEmail(ctx, fromEmail, recipient, emailSubject, emailBody)
+	var nextRetry time.Time
+	for _, rc := range recipients {
+		if rc.Status != repository.RecipientPending {
+			continue
+		}
+		if isSuppressed[rc.Email] {
+			if err := w.store.RecordRecipientFailure(ctx, shareID, rc.Email, "address is suppressed", nil); err != nil {
+				w.logger.Printf("Failed to record suppressed recipient %s: %v", rc.Email, err)
+			}
+			w.logger.Printf("Skipping suppressed recipient %s", rc.Email)
+			continue
+		}
+		// Render the email in the share's locale and the owner's branding, with the
+		// recipient's personal link
+		rendered, err := w.renderer.RenderShare(event, rc.Email)
 		if err != nil {
-			logger.Printf("Failed to send email to %s: %v", recipient, err)
-		} else {
-			logger.Printf("Email sent to %s", recipient)
-			successCount++
+			w.logger.Printf("Failed to render email for transfer %s: %v", event.TransferID, err)
+			return
 		}
+		if next := w.sendTo(ctx, shareID, rc, rendered); !next.IsZero() && (nextRetry.IsZero() || next.Before(nextRetry)) {
+			nextRetry = next
+		}
+	}
+
+	if nextRetry.IsZero() {
+		w.deleteMessage(ctx, msg)
+		w.logger.Printf("Share %s processed and deleted.", shareID)
+		return
 	}
 
-	// Delete message if at least one email was sent or if list was empty (prevent infinite loop)
-	if successCount > 0 || len(event.Emails) == 0 {
-		if err := sqsClient.DeleteMessage(ctx, queueURL, receiptHandle); err != nil {
-			logger.Printf("Failed to delete message: %v", err)
-		} else {
-			logger.Printf("Message processed and deleted.")
+	delay := max(time.Until(nextRetry), time.Second)
+	if err := w.consumer.ChangeVisibility(ctx, w.queueURL, msg.ReceiptHandle, delay); err != nil {
+		w.logger.Printf("Failed to postpone message of share %s: %v", shareID, err)
+		return
+	}
+	w.logger.Printf("Share %s has pending recipients; retrying in %s (receive %d).", shareID, delay.Round(time.Second), msg.ReceiveCount)
+}
+
+// sendTo mails one pending recipient and records the outcome. It returns when the
+// recipient is due again, or the zero time once it is sent or given up.
+func (w *emailWorker) sendTo(ctx context.Context, shareID string, rc repository.Recipient, rendered email.Rendered) time.Time {
+	claimed, err := w.store.ClaimRecipient(ctx, shareID, rc.Email, recipientLease)
+	if err != nil {
+		w.logger.Printf("Failed to claim recipient %s of share %s: %v", rc.Email, shareID, err)
+		return time.Now().Add(recipientLease)
+	}
+	if !claimed {
+		// not due yet, or another worker is mailing it right now
+		if rc.NextAttemptAt.After(time.Now()) {
+			return rc.NextAttemptAt
 		}
-	} else {
-		logger.Printf("Failed to send any emails. Message left in queue for retry.")
+		return time.Now().Add(recipientLease)
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	err = w.mailer.Send(sendCtx, storage.Email{From: w.fromEmail, To: rc.Email, Subject: rendered.Subject, Text: rendered.Text, HTML: rendered.HTML})
+	cancel()
+	if err == nil {
+		if err := w.store.MarkRecipientSent(ctx, shareID, rc.Email); err != nil {
+			// the mail is out; the claim lease keeps it from being sent again right away
+			w.logger.Printf("Failed to record email to %s as sent: %v", rc.Email, err)
+		}
+		w.logger.Printf("Email sent to %s", rc.Email)
+		return time.Time{}
+	}
+
+	attempt := rc.Attempts + 1
+	var next *time.Time
+	if attempt < EmailMaxAttempts() {
+		t := time.Now().UTC().Add(repository.RetryBackoff(attempt))
+		next = &t
+	}
+	if rerr := w.store.RecordRecipientFailure(ctx, shareID, rc.Email, err.Error(), next); rerr != nil {
+		w.logger.Printf("Failed to record failed email to %s: %v", rc.Email, rerr)
+	}
+	if next == nil {
+		// dead-lettered: the recipient stays failed and shows up in the recipients API
+		w.logger.Printf("Giving up on email to %s after %d attempts: %v", rc.Email, attempt, err)
+		return time.Time{}
+	}
+	w.logger.Printf("Failed to send email to %s (attempt %d, retry at %s): %v", rc.Email, attempt, next.Format(time.RFC3339), err)
+	return *next
+}
+
+func (w *emailWorker) deleteMessage(ctx context.Context, msg storage.Message) {
+	if err := w.consumer.Delete(ctx, w.queueURL, msg.ReceiptHandle); err != nil {
+		w.logger.Printf("Failed to delete message: %v", err)
 	}
 }
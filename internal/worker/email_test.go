@@ -0,0 +1,241 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pavithrankb/weTransfer/internal/email"
+	"github.com/pavithrankb/weTransfer/internal/repository"
+	"github.com/pavithrankb/weTransfer/internal/storage"
+)
+
+const (
+	testTopic = "shares"
+	testQueue = "emails"
+)
+
+// captured is one message the FileMailer wrote, with its plain-text body decoded.
+type captured struct {
+	To      string
+	Subject string
+	Text    string
+}
+
+func newTestWorker(t *testing.T, mailer storage.Mailer) (*emailWorker, *storage.MemoryBroker, *repository.Memory) {
+	t.Helper()
+	renderer, err := email.NewRenderer("", "")
+	if err != nil {
+		t.Fatalf("NewRenderer: %v", err)
+	}
+	broker := storage.NewMemoryBroker(0)
+	broker.Subscribe(testTopic, testQueue)
+	store := repository.NewMemory()
+	w := &emailWorker{
+		consumer:  broker,
+		mailer:    mailer,
+		renderer:  renderer,
+		store:     store,
+		queueURL:  testQueue,
+		fromEmail: "weTransfer <noreply@example.com>",
+		logger:    log.New(io.Discard, "", 0),
+	}
+	return w, broker, store
+}
+
+// share publishes a TRANSFER_SHARED event for emails, each with a personal link, and
+// returns it as the worker receives it.
+func share(t *testing.T, broker *storage.MemoryBroker, emails ...string) storage.Message {
+	t.Helper()
+	links := make(map[string]string, len(emails))
+	for _, e := range emails {
+		links[e] = "https://files.example.com/s/token-" + strings.SplitN(e, "@", 2)[0]
+	}
+	body, err := json.Marshal(storage.ShareDownloadMessage{
+		EventType:  "TRANSFER_SHARED",
+		TransferID: "transfer-1",
+		ShareID:    "share-1",
+		Emails:     emails,
+		ExpiresAt:  time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339),
+		Filename:   "notes.txt",
+		FileSize:   5,
+		Files:      []storage.SharedFile{{ID: "file-1", Filename: "notes.txt", FileSize: 5}},
+		Links:      links,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Publish(t.Context(), testTopic, "", string(body)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	msgs, err := broker.Receive(t.Context(), testQueue, 1, 0, time.Minute)
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("Receive = %d messages, %v; want 1", len(msgs), err)
+	}
+	return msgs[0]
+}
+
+// readMailDir returns the messages captured in dir by recipient.
+func readMailDir(t *testing.T, dir string) map[string]captured {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string]captured, len(paths))
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := mail.ReadMessage(f)
+		if err != nil {
+			f.Close()
+			t.Fatalf("parse %s: %v", p, err)
+		}
+		var c captured
+		if to, err := msg.Header.AddressList("To"); err == nil && len(to) == 1 {
+			c.To = to[0].Address
+		}
+		c.Subject, _ = new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+
+		_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+		if err != nil {
+			f.Close()
+			t.Fatalf("content type: %v", err)
+		}
+		// the plain-text alternative comes first
+		part, err := multipart.NewReader(msg.Body, params["boundary"]).NextRawPart()
+		if err != nil {
+			f.Close()
+			t.Fatalf("read part: %v", err)
+		}
+		text, err := io.ReadAll(quotedprintable.NewReader(part))
+		f.Close()
+		if err != nil {
+			t.Fatalf("decode body: %v", err)
+		}
+		c.Text = string(text)
+		out[c.To] = c
+	}
+	return out
+}
+
+func recipientStatus(t *testing.T, store *repository.Memory) map[string]repository.Recipient {
+	t.Helper()
+	rcs, err := store.ListRecipients(t.Context(), "transfer-1")
+	if err != nil {
+		t.Fatalf("ListRecipients: %v", err)
+	}
+	out := make(map[string]repository.Recipient, len(rcs))
+	for _, rc := range rcs {
+		out[rc.Email] = rc
+	}
+	return out
+}
+
+func TestProcessMessageSendsShare(t *testing.T) {
+	dir := t.TempDir()
+	mailer, err := storage.NewFileMailer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, broker, store := newTestWorker(t, mailer)
+
+	w.processMessage(t.Context(), share(t, broker, "anna@example.com", "ben@example.com"))
+
+	mails := readMailDir(t, dir)
+	if len(mails) != 2 {
+		t.Fatalf("captured %d messages, want 2", len(mails))
+	}
+	for _, who := range []string{"anna", "ben"} {
+		m, ok := mails[who+"@example.com"]
+		if !ok {
+			t.Errorf("no message to %s@example.com", who)
+			continue
+		}
+		if m.Subject != "File ready for download" {
+			t.Errorf("subject to %s = %q", who, m.Subject)
+		}
+		if !strings.Contains(m.Text, "https://files.example.com/s/token-"+who) {
+			t.Errorf("message to %s lacks their link:\n%s", who, m.Text)
+		}
+		if !strings.Contains(m.Text, "notes.txt") {
+			t.Errorf("message to %s lacks the filename:\n%s", who, m.Text)
+		}
+	}
+	if strings.Contains(mails["anna@example.com"].Text, "token-ben") {
+		t.Error("anna got ben's link")
+	}
+
+	for email, rc := range recipientStatus(t, store) {
+		if rc.Status != repository.RecipientSent {
+			t.Errorf("recipient %s is %s, want sent", email, rc.Status)
+		}
+	}
+	if n := broker.Len(testQueue); n != 0 {
+		t.Errorf("queue holds %d messages, want the share deleted", n)
+	}
+}
+
+func TestProcessMessageSkipsSuppressed(t *testing.T) {
+	dir := t.TempDir()
+	mailer, err := storage.NewFileMailer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, broker, store := newTestWorker(t, mailer)
+	if err := store.Suppress(t.Context(), "ben@example.com", "bounce", nil); err != nil {
+		t.Fatalf("Suppress: %v", err)
+	}
+
+	w.processMessage(t.Context(), share(t, broker, "anna@example.com", "ben@example.com"))
+
+	mails := readMailDir(t, dir)
+	if _, ok := mails["ben@example.com"]; ok || len(mails) != 1 {
+		t.Errorf("captured messages to %v, want anna only", mails)
+	}
+	rcs := recipientStatus(t, store)
+	if rcs["anna@example.com"].Status != repository.RecipientSent || rcs["ben@example.com"].Status != repository.RecipientFailed {
+		t.Errorf("recipients = %+v, want anna sent and ben failed", rcs)
+	}
+}
+
+type failingMailer struct{}
+
+func (failingMailer) Send(ctx context.Context, e storage.Email) error {
+	return errors.New("relay unavailable")
+}
+
+func TestProcessMessageRetriesFailedSend(t *testing.T) {
+	w, broker, store := newTestWorker(t, failingMailer{})
+
+	w.processMessage(t.Context(), share(t, broker, "anna@example.com"))
+
+	rc := recipientStatus(t, store)["anna@example.com"]
+	if rc.Status != repository.RecipientPending || rc.Attempts != 1 || rc.LastError == nil {
+		t.Errorf("recipient = %+v, want pending after 1 failed attempt", rc)
+	}
+	if !rc.NextAttemptAt.After(time.Now()) {
+		t.Errorf("next attempt at %s, want a retry in the future", rc.NextAttemptAt)
+	}
+	// the message stays queued, hidden until the retry is due
+	if n := broker.Len(testQueue); n != 1 {
+		t.Errorf("queue holds %d messages, want 1", n)
+	}
+	msgs, err := broker.Receive(t.Context(), testQueue, 1, 0, time.Minute)
+	if err != nil || len(msgs) != 0 {
+		t.Errorf("Receive = %d messages, %v; want the share hidden until its retry", len(msgs), err)
+	}
+}
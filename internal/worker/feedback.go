@@ -0,0 +1,90 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/pavithrankb/weTransfer/internal/repository"
+)
+
+// sesNotification is an SES bounce or complaint notification. SES identity notifications
+// carry notificationType, configuration-set event publishing carries eventType.
+type sesNotification struct {
+	NotificationType string `json:"notificationType"`
+	EventType        string `json:"eventType"`
+	Bounce           *struct {
+		BounceType        string `json:"bounceType"`
+		BounceSubType     string `json:"bounceSubType"`
+		BouncedRecipients []struct {
+			EmailAddress string `json:"emailAddress"`
+		} `json:"bouncedRecipients"`
+	} `json:"bounce"`
+	Complaint *struct {
+		ComplaintFeedbackType string `json:"complaintFeedbackType"`
+		ComplainedRecipients  []struct {
+			EmailAddress string `json:"emailAddress"`
+		} `json:"complainedRecipients"`
+	} `json:"complaint"`
+}
+
+func (n sesNotification) kind() string {
+	if n.NotificationType != "" {
+		return n.NotificationType
+	}
+	return n.EventType
+}
+
+// parseFeedback reports whether message is an SES bounce or complaint notification.
+func parseFeedback(message string) (sesNotification, bool) {
+	var n sesNotification
+	if err := json.Unmarshal([]byte(message), &n); err != nil {
+		return n, false
+	}
+	switch n.kind() {
+	case "Bounce":
+		return n, n.Bounce != nil
+	case "Complaint":
+		return n, n.Complaint != nil
+	}
+	return n, false
+}
+
+// handleFeedback adds the addresses of a permanent bounce or a complaint to the suppression
+// list. Transient bounces (mailbox full, throttling) are left to the recipient retries.
+// It reports whether the notification was fully recorded.
+func (w *emailWorker) handleFeedback(ctx context.Context, n sesNotification) bool {
+	var emails []string
+	var reason, detail string
+	switch n.kind() {
+	case "Bounce":
+		if n.Bounce.BounceType != "Permanent" {
+			w.logger.Printf("Ignoring %s bounce (%s)", strings.ToLower(n.Bounce.BounceType), n.Bounce.BounceSubType)
+			return true
+		}
+		for _, r := range n.Bounce.BouncedRecipients {
+			emails = append(emails, r.EmailAddress)
+		}
+		reason, detail = repository.SuppressionBounce, n.Bounce.BounceType+"/"+n.Bounce.BounceSubType
+	case "Complaint":
+		for _, r := range n.Complaint.ComplainedRecipients {
+			emails = append(emails, r.EmailAddress)
+		}
+		reason, detail = repository.SuppressionComplaint, n.Complaint.ComplaintFeedbackType
+	}
+
+	ok := true
+	for _, e := range emails {
+		var d *string
+		if detail != "" {
+			d = &detail
+		}
+		if err := w.store.Suppress(ctx, e, reason, d); err != nil {
+			w.logger.Printf("Failed to suppress %s: %v", e, err)
+			ok = false
+			continue
+		}
+		w.logger.Printf("Suppressed %s (%s)", e, reason)
+	}
+	return ok
+}